@@ -0,0 +1,301 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// maxCallDepth 别名与函数的最大嵌套层数，防止相互引用导致无限递归
+const maxCallDepth = 64
+
+// aliasDef config.toml [aliases] 中的一项
+//
+//	[aliases]
+//	ll = "svx-show --long"
+//	st = { command = "svx-status --all", desc = "查看全部状态" }
+type aliasDef struct {
+	command  string
+	desc     string
+	category string
+}
+
+// functionDef config.toml [functions] 中的一项
+//
+//	[functions.restart]
+//	desc  = "重启服务"
+//	usage = "restart SERVICE"
+//	lines = ["svx-stop $1", "svx-start $1"]
+type functionDef struct {
+	lines    []string
+	desc     string
+	usage    string
+	category string
+}
+
+// NormalizeAliases 值可以是命令字符串，或含 command / desc / category 的表
+func (c *Config) NormalizeAliases() (map[string]aliasDef, error) {
+	result := make(map[string]aliasDef, len(c.Aliases))
+	for name, rawVal := range c.Aliases {
+		switch val := rawVal.(type) {
+		case string:
+			result[name] = aliasDef{command: val}
+		case map[string]interface{}:
+			def := aliasDef{
+				command:  tomlString(val, "command"),
+				desc:     tomlString(val, "desc"),
+				category: tomlString(val, "category"),
+			}
+			if def.command == "" {
+				return nil, fmt.Errorf("别名 %s 缺少 command", name)
+			}
+			result[name] = def
+		default:
+			return nil, fmt.Errorf("别名 %s 的值应为字符串或表", name)
+		}
+	}
+	return result, nil
+}
+
+// NormalizeFunctions 值可以是命令行数组、多行字符串，或含 lines / desc / usage / category 的表
+func (c *Config) NormalizeFunctions() (map[string]functionDef, error) {
+	result := make(map[string]functionDef, len(c.Functions))
+	for name, rawVal := range c.Functions {
+		var def functionDef
+		switch val := rawVal.(type) {
+		case string, []interface{}:
+			def.lines = tomlLines(val)
+		case map[string]interface{}:
+			def.lines = tomlLines(val["lines"])
+			def.desc = tomlString(val, "desc")
+			def.usage = tomlString(val, "usage")
+			def.category = tomlString(val, "category")
+		default:
+			return nil, fmt.Errorf("函数 %s 的值应为数组、字符串或表", name)
+		}
+		if len(def.lines) == 0 {
+			return nil, fmt.Errorf("函数 %s 缺少 lines", name)
+		}
+		result[name] = def
+	}
+	return result, nil
+}
+
+func tomlString(m map[string]interface{}, key string) string {
+	if s, ok := m[key].(string); ok {
+		return s
+	}
+	return ""
+}
+
+// tomlLines 数组按元素、字符串按换行拆分，忽略空行与注释行
+func tomlLines(v interface{}) []string {
+	var raw []string
+	switch val := v.(type) {
+	case string:
+		raw = strings.Split(val, "\n")
+	case []interface{}:
+		for _, item := range val {
+			if s, ok := item.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	}
+	lines := make([]string, 0, len(raw))
+	for _, line := range raw {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// shellQuote 用单引号包裹参数，使其重新解析时保持原样
+func shellQuote(arg string) string {
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// enterCall 复制 stdio 并增加嵌套层数
+func enterCall(name string, stdio *Stdio) (*Stdio, error) {
+	if stdio.depth >= maxCallDepth {
+		return nil, fmt.Errorf("%s 嵌套超过 %d 层，可能存在循环引用", name, maxCallDepth)
+	}
+	st := *stdio
+	st.depth++
+	return &st, nil
+}
+
+// AliasCommand 别名：执行时把参数追加到别名内容之后
+type AliasCommand struct {
+	shell *Shell
+	name  string
+	value string
+	desc  CommandDesc
+}
+
+func NewAliasCommand(s *Shell, name string, def aliasDef, descMgr *DescManager) *AliasCommand {
+	desc := CommandDesc{
+		Category: "alias",
+		Desc:     "别名: " + def.command,
+		Usage:    name + " [ARGS...]",
+		Args:     []string{"ARGS 追加到 " + def.command + " 之后"},
+	}
+	applyDesc(&desc, def.desc, def.category, descMgr, name)
+	return &AliasCommand{shell: s, name: name, value: def.command, desc: desc}
+}
+
+func (a *AliasCommand) Name() string          { return a.name }
+func (a *AliasCommand) Category() string      { return a.desc.Category }
+func (a *AliasCommand) Path() string          { return "" }
+func (a *AliasCommand) IsBuiltin() bool       { return false }
+func (a *AliasCommand) Desc() string          { return a.desc.Desc }
+func (a *AliasCommand) Usage() string         { return a.desc.Usage }
+func (a *AliasCommand) Args() []string        { return a.desc.Args }
+func (a *AliasCommand) Returns() []string     { return a.desc.Returns }
+func (a *AliasCommand) Flags() []string       { return a.desc.Flags }
+func (a *AliasCommand) Subcommands() []string { return a.desc.Subcommands }
+func (a *AliasCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	st, err := enterCall(a.name, stdio)
+	if err != nil {
+		return err
+	}
+	line := a.value
+	for _, arg := range args[1:] {
+		line += " " + shellQuote(arg)
+	}
+	if status := a.shell.runLine(line, st); status != 0 {
+		return &exitCodeError{code: status}
+	}
+	return nil
+}
+
+// FunctionCommand 用户函数：依次执行各行，$1..$n 为调用参数，返回最后一行的退出码
+type FunctionCommand struct {
+	shell *Shell
+	name  string
+	lines []string
+	desc  CommandDesc
+}
+
+func NewFunctionCommand(s *Shell, name string, def functionDef, descMgr *DescManager) *FunctionCommand {
+	desc := CommandDesc{
+		Category: "function",
+		Desc:     fmt.Sprintf("函数，共 %d 行", len(def.lines)),
+		Usage:    def.usage,
+	}
+	if desc.Usage == "" {
+		desc.Usage = name + " [ARGS...]"
+	}
+	applyDesc(&desc, def.desc, def.category, descMgr, name)
+	return &FunctionCommand{shell: s, name: name, lines: def.lines, desc: desc}
+}
+
+func (f *FunctionCommand) Name() string          { return f.name }
+func (f *FunctionCommand) Category() string      { return f.desc.Category }
+func (f *FunctionCommand) Path() string          { return "" }
+func (f *FunctionCommand) IsBuiltin() bool       { return false }
+func (f *FunctionCommand) Desc() string          { return f.desc.Desc }
+func (f *FunctionCommand) Usage() string         { return f.desc.Usage }
+func (f *FunctionCommand) Args() []string        { return f.desc.Args }
+func (f *FunctionCommand) Returns() []string     { return f.desc.Returns }
+func (f *FunctionCommand) Flags() []string       { return f.desc.Flags }
+func (f *FunctionCommand) Subcommands() []string { return f.desc.Subcommands }
+func (f *FunctionCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	st, err := enterCall(f.name, stdio)
+	if err != nil {
+		return err
+	}
+	st.Params = args
+	status := 0
+	for _, line := range f.lines {
+		if f.shell.Exited() {
+			break
+		}
+		status = f.shell.runLine(line, st)
+	}
+	if status != 0 {
+		return &exitCodeError{code: status}
+	}
+	return nil
+}
+
+// applyDesc 描述优先级：desc.toml > config.toml 中的 desc / category > 默认值
+func applyDesc(desc *CommandDesc, text, category string, descMgr *DescManager, name string) {
+	if text != "" {
+		desc.Desc = text
+	}
+	if category != "" {
+		desc.Category = category
+	}
+	d, ok := descMgr.Get(name)
+	if !ok {
+		return
+	}
+	if d.Category != "" {
+		desc.Category = d.Category
+	}
+	if d.Desc != "" {
+		desc.Desc = d.Desc
+	}
+	if d.Usage != "" {
+		desc.Usage = d.Usage
+	}
+	if len(d.Args) > 0 {
+		desc.Args = d.Args
+	}
+	desc.Flags = d.Flags
+	desc.Subcommands = d.Subcommands
+	desc.Returns = d.Returns
+}
+
+// loadAliases 注册别名与函数，替换上一次加载的全部别名与函数；
+// 与内置命令或外部命令重名时跳过，避免别名调用自身
+func (s *Shell) loadAliases(cfg *Config, descMgr *DescManager) {
+	aliases, err := cfg.NormalizeAliases()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 别名配置错误: %v\n", err)
+		return
+	}
+	functions, err := cfg.NormalizeFunctions()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 函数配置错误: %v\n", err)
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, cmd := range s.commands {
+		switch cmd.(type) {
+		case *AliasCommand, *FunctionCommand:
+			delete(s.commands, name)
+		}
+	}
+	var (
+		skipped     []string
+		nAlias, nFn int
+	)
+	for name, def := range aliases {
+		if _, exists := s.commands[name]; exists || strings.ContainsAny(name, " \t") {
+			skipped = append(skipped, name)
+			continue
+		}
+		s.commands[name] = NewAliasCommand(s, name, def, descMgr)
+		nAlias++
+	}
+	for name, def := range functions {
+		if _, exists := s.commands[name]; exists || strings.ContainsAny(name, " \t") {
+			skipped = append(skipped, name)
+			continue
+		}
+		s.commands[name] = NewFunctionCommand(s, name, def, descMgr)
+		nFn++
+	}
+	if len(skipped) > 0 {
+		sort.Strings(skipped)
+		fmt.Fprintf(os.Stderr, "⚠️ 以下别名或函数与已有命令重名或名称非法，已忽略: %s\n", strings.Join(skipped, ", "))
+	}
+	logInfo("🔄 已加载 %d 个🔗别名，%d 个🧩函数\n", nAlias, nFn)
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuditConfig config.toml [audit]，只从 /etc/flyos 中 root 所有的配置读取（见 isTrustedLayer）
+//
+//	[audit]
+//	file = "/var/log/flyos/audit.log"   # 默认 ~/.flyos/audit.log
+//	max_size = "10M"                    # 超过后轮转，默认 10M
+//	max_files = 5                       # 保留的历史文件数，默认 5
+//	disable = false
+type AuditConfig struct {
+	File     string      `toml:"file"`
+	MaxSize  interface{} `toml:"max_size"`
+	MaxFiles int         `toml:"max_files"`
+	Disable  bool        `toml:"disable"`
+}
+
+const (
+	defaultAuditMaxSize  = 10 << 20
+	defaultAuditMaxFiles = 5
+)
+
+// AuditEntry 审计日志中的一行
+type AuditEntry struct {
+	Time       time.Time `json:"time"`
+	User       string    `json:"user"`
+	Session    string    `json:"session"`
+	Argv       []string  `json:"argv"`
+	Path       string    `json:"path"` // 外部命令的实际路径，内置命令、别名与函数为空
+	Exit       int       `json:"exit"`
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// AuditLog 以 JSON Lines 追加写入审计日志，按大小轮转为 file.1 .. file.N
+type AuditLog struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	file     *os.File
+	size     int64
+
+	configured bool // 已应用过配置，之后的变化记录为审计事件
+	user       string
+	session    string
+}
+
+func NewAuditLog() *AuditLog {
+	name := strconv.Itoa(os.Getuid())
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return &AuditLog{user: name, session: uuid.NewString()}
+}
+
+// Configure 应用配置，配置热加载时重新调用；文件变化时关闭旧文件。
+// 热加载改变了设置时，在旧日志与新日志中各记录一条 config reload audit 事件
+func (a *AuditLog) Configure(cfg AuditConfig) error {
+	start := time.Now()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	file := cfg.File
+	if file == "" {
+		file = filepath.Join(homeDir, ".flyos", "audit.log")
+	}
+	maxSize := int64(defaultAuditMaxSize)
+	if cfg.MaxSize != nil {
+		n, err := parseSize(cfg.MaxSize)
+		if err != nil {
+			return fmt.Errorf("audit.max_size 无效: %v", err)
+		}
+		maxSize = int64(n)
+	}
+	maxFiles := cfg.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = defaultAuditMaxFiles
+	}
+	if cfg.Disable {
+		file = ""
+	}
+
+	changed := file != a.path || maxSize != a.maxSize || maxFiles != a.maxFiles
+	var event []byte
+	if a.configured && changed {
+		event = a.entry([]string{
+			"config", "reload", "audit",
+			"file=" + file,
+			"max_size=" + strconv.FormatInt(maxSize, 10),
+			"max_files=" + strconv.Itoa(maxFiles),
+			"disable=" + strconv.FormatBool(cfg.Disable),
+		}, "", 0, start)
+		if err := a.writeEvent(event); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ 写入审计日志失败: %v\n", err)
+		}
+	}
+
+	oldPath := a.path
+	if file != a.path && a.file != nil {
+		a.file.Close()
+		a.file = nil
+	}
+	a.path, a.maxSize, a.maxFiles = file, maxSize, maxFiles
+	a.configured = true
+	if event != nil && file != oldPath {
+		if err := a.writeEvent(event); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ 写入审计日志失败: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// writeEvent 审计未关闭时写入一行，调用方持有 a.mu
+func (a *AuditLog) writeEvent(line []byte) error {
+	if a.path == "" || line == nil {
+		return nil
+	}
+	return a.write(line)
+}
+
+// entry 编码一条记录
+func (a *AuditLog) entry(argv []string, cmdPath string, exit int, start time.Time) []byte {
+	line, err := json.Marshal(AuditEntry{
+		Time:       start,
+		User:       a.user,
+		Session:    a.session,
+		Argv:       argv,
+		Path:       cmdPath,
+		Exit:       exit,
+		DurationMs: time.Since(start).Milliseconds(),
+	})
+	if err != nil {
+		return nil
+	}
+	return append(line, '\n')
+}
+
+// Record 写入一条记录，写入失败只提示不影响命令执行
+func (a *AuditLog) Record(argv []string, cmdPath string, exit int, start time.Time) {
+	line := a.entry(argv, cmdPath, exit, start)
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.writeEvent(line); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 写入审计日志失败: %v\n", err)
+	}
+}
+
+func (a *AuditLog) write(line []byte) error {
+	if a.file != nil && a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+	if a.file == nil {
+		if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(a.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+		a.file, a.size = f, info.Size()
+		if a.size > 0 && a.size+int64(len(line)) > a.maxSize {
+			return a.write(line)
+		}
+	}
+	n, err := a.file.Write(line)
+	a.size += int64(n)
+	return err
+}
+
+// rotate file -> file.1，已有的 file.i -> file.i+1，超出保留数量的删除
+func (a *AuditLog) rotate() error {
+	a.file.Close()
+	a.file = nil
+	os.Remove(fmt.Sprintf("%s.%d", a.path, a.maxFiles))
+	for i := a.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", a.path, i), fmt.Sprintf("%s.%d", a.path, i+1))
+	}
+	return os.Rename(a.path, a.path+".1")
+}
+
+// files 返回按时间从旧到新排列的日志文件
+func (a *AuditLog) files() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.path == "" {
+		return nil
+	}
+	var files []string
+	for i := a.maxFiles; i >= 1; i-- {
+		files = append(files, fmt.Sprintf("%s.%d", a.path, i))
+	}
+	return append(files, a.path)
+}
+
+// auditFilter audit show 的过滤条件
+type auditFilter struct {
+	user    string
+	command string // 命令名，支持通配符
+	since   time.Time
+	until   time.Time
+	limit   int // 只保留最后 N 条
+}
+
+func (f *auditFilter) match(e *AuditEntry) bool {
+	if f.user != "" && e.User != f.user {
+		return false
+	}
+	if f.command != "" {
+		if len(e.Argv) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(f.command, e.Argv[0]); !ok {
+			return false
+		}
+	}
+	if !f.since.IsZero() && e.Time.Before(f.since) {
+		return false
+	}
+	if !f.until.IsZero() && e.Time.After(f.until) {
+		return false
+	}
+	return true
+}
+
+// Query 读取全部日志文件中满足条件的记录，无法解析的行跳过
+func (a *AuditLog) Query(filter auditFilter) ([]AuditEntry, error) {
+	var entries []AuditEntry
+	for _, name := range a.files() {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		sc := bufio.NewScanner(f)
+		sc.Buffer(make([]byte, 64*1024), 1024*1024)
+		for sc.Scan() {
+			var e AuditEntry
+			if json.Unmarshal(sc.Bytes(), &e) != nil {
+				continue
+			}
+			if filter.match(&e) {
+				entries = append(entries, e)
+			}
+		}
+		f.Close()
+	}
+	if filter.limit > 0 && len(entries) > filter.limit {
+		entries = entries[len(entries)-filter.limit:]
+	}
+	return entries, nil
+}
+
+// parseAuditTime 支持 RFC3339、"2006-01-02 15:04:05"、"2006-01-02"，或 "1h" 这样的相对时长（表示此前）
+func parseAuditTime(s string) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("无法解析时间: %s", s)
+}
+
+// auditOutput audit show 的 JSON 输出
+type auditOutput struct {
+	Entries []AuditEntry `json:"entries"`
+}
+
+func writeAudit(w io.Writer, format OutputFormat, entries []AuditEntry) error {
+	switch format {
+	case OutputJSON:
+		if entries == nil {
+			entries = []AuditEntry{}
+		}
+		return writeJSON(w, auditOutput{Entries: entries})
+	case OutputTable:
+		rows := make([][]string, 0, len(entries))
+		for _, e := range entries {
+			rows = append(rows, []string{
+				e.Time.Format("2006-01-02 15:04:05"), e.User, shortID(e.Session),
+				strconv.Itoa(e.Exit), strconv.FormatInt(e.DurationMs, 10), strings.Join(e.Argv, " "), e.Path,
+			})
+		}
+		return writeTable(w, []string{"TIME", "USER", "SESSION", "EXIT", "MS", "COMMAND", "PATH"}, rows)
+	}
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "⚠️ 没有匹配的审计记录")
+		return nil
+	}
+	for _, e := range entries {
+		mark := "✅"
+		if e.Exit != 0 {
+			mark = "❌"
+		}
+		fmt.Fprintf(w, "%s %s %-8s [%s] %s → %d (%dms)\n",
+			mark, e.Time.Format("2006-01-02 15:04:05"), e.User, shortID(e.Session), strings.Join(e.Argv, " "), e.Exit, e.DurationMs)
+	}
+	return nil
+}
+
+// shortID 会话 id 只显示前 8 位
+func shortID(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
+
+// Builtin Audit
+type AuditCommand struct {
+	shell *Shell
+}
+
+func NewAuditCommand(s *Shell) *AuditCommand {
+	return &AuditCommand{shell: s}
+}
+
+func (c *AuditCommand) Name() string     { return "audit" }
+func (c *AuditCommand) Category() string { return "sys" }
+func (c *AuditCommand) Path() string     { return "" }
+func (c *AuditCommand) IsBuiltin() bool  { return true }
+func (c *AuditCommand) Desc() string     { return "查看命令审计日志" }
+func (c *AuditCommand) Usage() string {
+	return "audit show [--user USER] [--command NAME] [--since TIME] [--until TIME] [-n N]"
+}
+func (c *AuditCommand) Args() []string {
+	return []string{"TIME 支持 2006-01-02、2006-01-02 15:04:05、RFC3339，或 1h、30m 表示此前一段时间"}
+}
+func (c *AuditCommand) Returns() []string {
+	return []string{"按时间顺序输出匹配的审计记录"}
+}
+func (c *AuditCommand) Flags() []string {
+	return []string{
+		"--user USER       # 只看指定 OS 用户",
+		"--command NAME    # 只看指定命令，支持通配符，如 svx-*",
+		"--since TIME      # 起始时间",
+		"--until TIME      # 结束时间",
+		"-n N              # 只显示最后 N 条",
+	}
+}
+func (c *AuditCommand) Subcommands() []string {
+	return []string{"show    # 查询审计记录"}
+}
+func (c *AuditCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) < 2 || args[1] != "show" {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	var filter auditFilter
+	rest := args[2:]
+	for len(rest) > 0 {
+		if len(rest) < 2 {
+			return fmt.Errorf("%s 缺少参数", rest[0])
+		}
+		opt, val := rest[0], rest[1]
+		rest = rest[2:]
+		var err error
+		switch opt {
+		case "--user":
+			filter.user = val
+		case "--command":
+			filter.command = val
+		case "--since":
+			filter.since, err = parseAuditTime(val)
+		case "--until":
+			filter.until, err = parseAuditTime(val)
+		case "-n":
+			filter.limit, err = strconv.Atoi(val)
+		default:
+			return fmt.Errorf("未知选项: %s", opt)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	entries, err := c.shell.audit.Query(filter)
+	if err != nil {
+		return err
+	}
+	return writeAudit(stdio.Out, c.shell.OutputFormat(), entries)
+}
@@ -0,0 +1,646 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"flyos/pkg/dsl"
+)
+
+// configure 模式：DSL 语句先暂存到候选配置，commit 时与运行配置比较，
+// 把差异通过 dsl 执行器生效，并把完整配置保存为一次提交，供 rollback 使用
+
+// maxRollbacks 保留的提交数，rollback 0 为当前运行配置
+const maxRollbacks = 50
+
+// configDBDir 提交历史与 commit confirmed 状态，运行配置是整台设备的，所有用户的会话共用一份。
+// 提交中可能有 psk 等密钥，目录与文件只允许所有者与 flyos 组读写，组由安装时设置，
+// 目录带 setgid 使新文件继承该组
+var configDBDir = "/var/lib/flyos/commits"
+
+const (
+	sharedDirMode  = os.ModeSetgid | 0770
+	sharedFileMode = 0660
+)
+
+// makeSharedDir 创建共用目录并修正权限
+func makeSharedDir(dir string) error {
+	if err := os.MkdirAll(dir, 0770); err != nil {
+		return err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&(os.ModeSetgid|os.ModePerm) != sharedDirMode {
+		return os.Chmod(dir, sharedDirMode)
+	}
+	return nil
+}
+
+// shareFile 新建的文件受 umask 影响，改为组内可读写；不是本人创建的文件保持原样
+func shareFile(path string) {
+	_ = os.Chmod(path, sharedFileMode)
+}
+
+// dslKey 配置中的一个对象，由 kind 与标识确定；标识与 pkg/dsl 的 Store 相同（dsl.ObjectName）：
+// 属性中有 name 或 prefix 时取其值，否则为 subtype。同一 subtype 可以有多个对象，
+// 如 route add static { prefix 10.0.0.0/24 } 与 route add static { prefix 20.0.0.0/24 }
+type dslKey struct {
+	Kind    string
+	Subtype string
+	Name    string
+}
+
+// keyOf 语句或 sync 块中对象的 key
+func keyOf(kind, subtype string, attrs map[string]interface{}) dslKey {
+	name, _ := dsl.ObjectName(subtype, attrs)
+	return dslKey{Kind: strings.ToLower(kind), Subtype: subtype, Name: name}
+}
+
+func (k dslKey) String() string {
+	parts := []string{k.Kind}
+	if k.Subtype != "" {
+		parts = append(parts, k.Subtype)
+	}
+	if k.Name != k.Subtype {
+		parts = append(parts, k.Name)
+	}
+	return strings.Join(parts, " ")
+}
+
+// dslTree 一份完整的 DSL 配置
+type dslTree map[dslKey]map[string]interface{}
+
+func (t dslTree) clone() dslTree {
+	c := make(dslTree, len(t))
+	for k, attrs := range t {
+		c[k] = cloneAttrs(attrs)
+	}
+	return c
+}
+
+// cloneAttrs 深拷贝属性，包括列表与嵌套块
+func cloneAttrs(attrs map[string]interface{}) map[string]interface{} {
+	c := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		switch val := v.(type) {
+		case []string:
+			v = append([]string(nil), val...)
+		case map[string]interface{}:
+			v = cloneAttrs(val)
+		case []map[string]interface{}:
+			blocks := make([]map[string]interface{}, len(val))
+			for i, b := range val {
+				blocks[i] = cloneAttrs(b)
+			}
+			v = blocks
+		}
+		c[k] = v
+	}
+	return c
+}
+
+// keys 按 kind、subtype、标识排序
+func (t dslTree) keys() []dslKey {
+	keys := make([]dslKey, 0, len(t))
+	for k := range t {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].Kind != keys[j].Kind {
+			return keys[i].Kind < keys[j].Kind
+		}
+		if keys[i].Subtype != keys[j].Subtype {
+			return keys[i].Subtype < keys[j].Subtype
+		}
+		return keys[i].Name < keys[j].Name
+	})
+	return keys
+}
+
+// apply 把一条语句应用到配置：add 新建，set 新建或合并属性，delete 删除，sync 替换该 kind 的全部对象；
+// delete 没有给出 name 或 prefix 时删除该 subtype 的全部对象
+func (t dslTree) apply(c dsl.Command) error {
+	key := keyOf(c.Kind, c.Subtype, c.Attrs)
+	switch c.Verb {
+	case "add":
+		if _, ok := t[key]; ok {
+			return fmt.Errorf("%s 已存在，使用 set 修改", key)
+		}
+		t[key] = cloneAttrs(c.Attrs)
+	case "set":
+		attrs, ok := t[key]
+		if !ok {
+			attrs = make(map[string]interface{})
+			t[key] = attrs
+		}
+		for k, v := range cloneAttrs(c.Attrs) {
+			attrs[k] = v
+		}
+	case "delete":
+		if _, ok := t[key]; ok {
+			delete(t, key)
+			break
+		}
+		n := 0
+		if key.Name == key.Subtype {
+			for k := range t {
+				if k.Kind == key.Kind && k.Subtype == key.Subtype {
+					delete(t, k)
+					n++
+				}
+			}
+		}
+		if n == 0 {
+			return fmt.Errorf("%s 不存在", key)
+		}
+	case "sync":
+		for k := range t {
+			if k.Kind == key.Kind {
+				delete(t, k)
+			}
+		}
+		for _, b := range c.Blocks {
+			k := keyOf(key.Kind, b.Subtype, b.Attrs)
+			if _, ok := t[k]; ok {
+				return fmt.Errorf("sync 中 %s 重复", k)
+			}
+			t[k] = cloneAttrs(b.Attrs)
+		}
+	default:
+		return fmt.Errorf("不支持的动词: %s", c.Verb)
+	}
+	return nil
+}
+
+// parseTree 解析 DSL 文本为配置
+func parseTree(src string) (dslTree, error) {
+	p := dsl.NewParser(src)
+	cmds, err := p.Parse()
+	if err != nil {
+		return nil, err
+	}
+	t := make(dslTree)
+	for _, c := range cmds {
+		if err := t.apply(c); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
+// render 输出可被 parseTree 读回的 DSL 文本
+func (t dslTree) render(w io.Writer) {
+	for _, k := range t.keys() {
+		for _, line := range objectLines(k.Kind, k.Subtype, t[k]) {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
+
+func (t dslTree) String() string {
+	var b strings.Builder
+	t.render(&b)
+	return b.String()
+}
+
+// objectLines 一个对象的 DSL 文本，属性按名称排序；name、prefix 等标识在属性中
+func objectLines(kind, subtype string, attrs map[string]interface{}) []string {
+	head := kind + " add"
+	if subtype != "" {
+		head += " " + dslValue(subtype)
+	}
+	lines := []string{head + " {"}
+	for _, name := range sortedAttrs(attrs) {
+		lines = append(lines, attrLines(name, attrs[name], 1)...)
+	}
+	return append(lines, "}")
+}
+
+// attrLines 一个属性的 DSL 文本，嵌套块逐层缩进，重复的块各占一段
+func attrLines(name string, v interface{}, depth int) []string {
+	indent := strings.Repeat("    ", depth)
+	var blocks []map[string]interface{}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		blocks = []map[string]interface{}{val}
+	case []map[string]interface{}:
+		blocks = val
+	default:
+		return []string{fmt.Sprintf("%s%s %s;", indent, name, dslValue(v))}
+	}
+	var lines []string
+	for _, b := range blocks {
+		lines = append(lines, indent+name+" {")
+		for _, k := range sortedAttrs(b) {
+			lines = append(lines, attrLines(k, b[k], depth+1)...)
+		}
+		lines = append(lines, indent+"}")
+	}
+	return lines
+}
+
+func sortedAttrs(attrs map[string]interface{}) []string {
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dslValue 把属性值写成 DSL 字面量；能被读成同一个标识符的字符串不加引号，
+// 否则加引号并转义其中的 \ 与 "
+func dslValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		l := dsl.NewLexer(val)
+		if tok := l.NextToken(); tok.Type == dsl.TT_IDENT && tok.Literal == val && l.NextToken().Type == dsl.TT_EOF {
+			return val
+		}
+		return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(val) + `"`
+	case []string:
+		items := make([]string, len(val))
+		for i, item := range val {
+			items[i] = dslValue(item)
+		}
+		return "[ " + strings.Join(items, ", ") + " ]"
+	case bool:
+		return strconv.FormatBool(val)
+	case map[string]interface{}:
+		items := make([]string, 0, len(val))
+		for _, k := range sortedAttrs(val) {
+			items = append(items, k+" "+dslValue(val[k]))
+		}
+		if len(items) == 0 {
+			return "{ }"
+		}
+		return "{ " + strings.Join(items, "; ") + " }"
+	case []map[string]interface{}:
+		items := make([]string, len(val))
+		for i, b := range val {
+			items[i] = dslValue(b)
+		}
+		return strings.Join(items, " ")
+	}
+	return fmt.Sprint(v)
+}
+
+// dslChange 两份配置之间一个对象的差异，Old 为 nil 表示新增，New 为 nil 表示删除
+type dslChange struct {
+	Key dslKey
+	Old map[string]interface{}
+	New map[string]interface{}
+}
+
+// diffTrees 按对象比较两份配置，结果按 kind、名称排序
+func diffTrees(from, to dslTree) []dslChange {
+	all := make(dslTree, len(from)+len(to))
+	for k := range from {
+		all[k] = nil
+	}
+	for k := range to {
+		all[k] = nil
+	}
+	var changes []dslChange
+	for _, k := range all.keys() {
+		old, inOld := from[k]
+		cur, inNew := to[k]
+		switch {
+		case !inOld:
+			changes = append(changes, dslChange{Key: k, New: cur})
+		case !inNew:
+			changes = append(changes, dslChange{Key: k, Old: old})
+		case !sameAttrs(old, cur):
+			changes = append(changes, dslChange{Key: k, Old: old, New: cur})
+		}
+	}
+	return changes
+}
+
+func sameAttrs(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		w, ok := b[k]
+		if !ok || dslValue(v) != dslValue(w) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareLines Junos 风格的差异：[edit kind] 下整块增删，[edit kind name] 下逐个属性增删
+func compareLines(changes []dslChange) []string {
+	var lines []string
+	for _, c := range changes {
+		switch {
+		case c.Old == nil || c.New == nil:
+			sign, attrs := "+", c.New
+			if c.New == nil {
+				sign, attrs = "-", c.Old
+			}
+			lines = append(lines, "[edit "+c.Key.Kind+"]")
+			for _, line := range objectLines(c.Key.Kind, c.Key.Subtype, attrs) {
+				lines = append(lines, sign+" "+strings.TrimPrefix(line, c.Key.Kind+" add "))
+			}
+		default:
+			lines = append(lines, "[edit "+c.Key.String()+"]")
+			names := make(map[string]bool)
+			for k := range c.Old {
+				names[k] = true
+			}
+			for k := range c.New {
+				names[k] = true
+			}
+			keys := make(map[string]interface{}, len(names))
+			for k := range names {
+				keys[k] = nil
+			}
+			for _, name := range sortedAttrs(keys) {
+				old, inOld := c.Old[name]
+				cur, inNew := c.New[name]
+				if inOld && inNew && dslValue(old) == dslValue(cur) {
+					continue
+				}
+				if inOld {
+					for _, line := range attrLines(name, old, 1) {
+						lines = append(lines, "-"+line)
+					}
+				}
+				if inNew {
+					for _, line := range attrLines(name, cur, 1) {
+						lines = append(lines, "+"+line)
+					}
+				}
+			}
+		}
+	}
+	return lines
+}
+
+// changeCommands 把差异转为交给执行器的语句：先删除，再新增和修改；
+// 修改时删掉了属性的对象先 delete 再 add，其余用 set 写入完整属性
+func changeCommands(changes []dslChange) []dsl.Command {
+	var deletes, updates []dsl.Command
+	for _, c := range changes {
+		cmd := func(verb string, attrs map[string]interface{}) dsl.Command {
+			return dsl.Command{Kind: c.Key.Kind, Verb: verb, Subtype: c.Key.Subtype, Attrs: cloneAttrs(attrs)}
+		}
+		switch {
+		case c.New == nil:
+			deletes = append(deletes, cmd("delete", c.Old))
+		case c.Old == nil:
+			updates = append(updates, cmd("add", c.New))
+		default:
+			removed := false
+			for k := range c.Old {
+				if _, ok := c.New[k]; !ok {
+					removed = true
+				}
+			}
+			if removed {
+				deletes = append(deletes, cmd("delete", c.Old))
+				updates = append(updates, cmd("add", c.New))
+			} else {
+				updates = append(updates, cmd("set", c.New))
+			}
+		}
+	}
+	return append(deletes, updates...)
+}
+
+// commitInfo 一次提交，保存在 configDBDir/NNNNNN.dsl，首行注释记录提交者与时间
+type commitInfo struct {
+	Rollback int       `json:"rollback"`
+	Seq      int       `json:"seq"`
+	User     string    `json:"user"`
+	Time     time.Time `json:"time"`
+	Path     string    `json:"path"`
+}
+
+// ConfigDB 运行配置、候选配置与提交历史
+type ConfigDB struct {
+	applyMu   sync.Mutex // 串行化 commit 与自动回滚
+	mu        sync.Mutex
+	dir       string
+	running   dslTree
+	candidate dslTree // 进入过 configure 模式后才有，退出后保留未提交的更改
+	editing   bool
+}
+
+func NewConfigDB(dir string) *ConfigDB {
+	db := &ConfigDB{dir: dir, running: make(dslTree)}
+	db.reload()
+	return db
+}
+
+// reload 运行配置即最近一次提交，其他 flyos 进程提交后重新读取
+func (db *ConfigDB) reload() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if commits, err := db.commits(); err == nil && len(commits) > 0 {
+		if data, err := os.ReadFile(commits[0].Path); err == nil {
+			if t, err := parseTree(string(data)); err == nil {
+				db.running = t
+			}
+		}
+	}
+}
+
+// Enter 进入 configure 模式，返回候选配置是否有上次未提交的更改
+func (db *ConfigDB) Enter() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.editing = true
+	if db.candidate == nil {
+		db.candidate = db.running.clone()
+	}
+	return len(diffTrees(db.running, db.candidate)) > 0
+}
+
+// Leave 退出 configure 模式，返回之前是否在 configure 模式中
+func (db *ConfigDB) Leave() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	was := db.editing
+	db.editing = false
+	return was
+}
+
+func (db *ConfigDB) Editing() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.editing
+}
+
+// Dirty 候选配置是否有未提交的更改
+func (db *ConfigDB) Dirty() bool {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.candidate != nil && len(diffTrees(db.running, db.candidate)) > 0
+}
+
+// Running 运行配置的副本
+func (db *ConfigDB) Running() dslTree {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.running.clone()
+}
+
+// Candidate 候选配置的副本，不在 configure 模式时为运行配置
+func (db *ConfigDB) Candidate() dslTree {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if !db.editing {
+		return db.running.clone()
+	}
+	return db.candidate.clone()
+}
+
+// Stage 把语句应用到候选配置，任一语句失败时候选配置保持不变
+func (db *ConfigDB) Stage(cmds []dsl.Command) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	t := db.candidate.clone()
+	for _, c := range cmds {
+		if err := t.apply(c); err != nil {
+			return err
+		}
+	}
+	db.candidate = t
+	return nil
+}
+
+// Discard 丢弃未提交的更改
+func (db *ConfigDB) Discard() {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.candidate = db.running.clone()
+}
+
+// Load 把第 n 次之前的提交载入候选配置，0 为运行配置
+func (db *ConfigDB) Load(n int) error {
+	t, err := db.Rollback(n)
+	if err != nil {
+		return err
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.candidate = t
+	return nil
+}
+
+// Rollback 读取第 n 次之前的提交
+func (db *ConfigDB) Rollback(n int) (dslTree, error) {
+	if n == 0 {
+		return db.Running(), nil
+	}
+	commits, err := db.commits()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n >= len(commits) {
+		return nil, fmt.Errorf("没有 rollback %d，共 %d 次提交", n, len(commits))
+	}
+	data, err := os.ReadFile(commits[n].Path)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseTree(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("rollback %d 无法解析: %v", n, err)
+	}
+	return t, nil
+}
+
+// Commit 保存候选配置为一次提交并设为运行配置，超出 maxRollbacks 的旧提交被删除
+func (db *ConfigDB) Commit(t dslTree, user string) (commitInfo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if err := makeSharedDir(db.dir); err != nil {
+		return commitInfo{}, err
+	}
+	commits, err := db.commits()
+	if err != nil {
+		return commitInfo{}, err
+	}
+	info := commitInfo{Seq: 1, User: user, Time: time.Now()}
+	if len(commits) > 0 {
+		info.Seq = commits[0].Seq + 1
+	}
+	info.Path = filepath.Join(db.dir, fmt.Sprintf("%06d.dsl", info.Seq))
+	data := fmt.Sprintf("# commit %d by %s at %s\n%s", info.Seq, user, info.Time.Format(time.RFC3339), t)
+	if err := os.WriteFile(info.Path, []byte(data), sharedFileMode); err != nil {
+		return commitInfo{}, err
+	}
+	shareFile(info.Path)
+	if all := append([]commitInfo{info}, commits...); len(all) > maxRollbacks {
+		for _, old := range all[maxRollbacks:] {
+			os.Remove(old.Path)
+		}
+	}
+	db.running = t.clone()
+	db.candidate = t.clone()
+	return info, nil
+}
+
+// commits 全部提交，最新的在前
+func (db *ConfigDB) commits() ([]commitInfo, error) {
+	entries, err := os.ReadDir(db.dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var commits []commitInfo
+	for _, e := range entries {
+		seq, err := strconv.Atoi(strings.TrimSuffix(e.Name(), ".dsl"))
+		if err != nil || !strings.HasSuffix(e.Name(), ".dsl") {
+			continue
+		}
+		info := commitInfo{Seq: seq, Path: filepath.Join(db.dir, e.Name())}
+		readCommitHeader(&info)
+		commits = append(commits, info)
+	}
+	sort.Slice(commits, func(i, j int) bool { return commits[i].Seq > commits[j].Seq })
+	for i := range commits {
+		commits[i].Rollback = i
+	}
+	return commits, nil
+}
+
+// readCommitHeader 从首行注释读取提交者与时间
+func readCommitHeader(info *commitInfo) {
+	f, err := os.Open(info.Path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	var seq int
+	var user, at string
+	if _, err := fmt.Sscanf(line, "# commit %d by %s at %s", &seq, &user, &at); err != nil {
+		return
+	}
+	info.User = user
+	info.Time, _ = time.Parse(time.RFC3339, at)
+}
+
+// Commits 全部提交，最新的在前
+func (db *ConfigDB) Commits() ([]commitInfo, error) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.commits()
+}
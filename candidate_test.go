@@ -0,0 +1,79 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"flyos/pkg/dsl"
+)
+
+func TestDSLTreeIdentity(t *testing.T) {
+	// 同一 subtype 的对象以 prefix 区分
+	tree, err := parseTree(`
+route add static { prefix 10.0.0.0/24; via 1 }
+route add static { prefix 20.0.0.0/24; via 2 }
+route add ospf { area 0 }
+`)
+	if err != nil {
+		t.Fatalf("parseTree: %v", err)
+	}
+	if len(tree) != 3 {
+		t.Fatalf("对象数 = %d, want 3: %v", len(tree), tree.keys())
+	}
+	if err := tree.apply(mustCommand(t, "route add static { prefix 10.0.0.0/24 }")); err == nil || !strings.Contains(err.Error(), "已存在") {
+		t.Errorf("重复 add: expected 已存在, got %v", err)
+	}
+
+	// 文本读回后不变
+	back, err := parseTree(tree.String())
+	if err != nil {
+		t.Fatalf("parseTree(String): %v", err)
+	}
+	if back.String() != tree.String() {
+		t.Errorf("读回后 = %q, want %q", back.String(), tree.String())
+	}
+
+	// 修改一个 static 只影响该对象
+	next, _ := parseTree(tree.String())
+	if err := next.apply(mustCommand(t, "route set static { prefix 20.0.0.0/24; via 3 }")); err != nil {
+		t.Fatal(err)
+	}
+	changes := diffTrees(tree, next)
+	if len(changes) != 1 || changes[0].Key.Name != "20.0.0.0/24" {
+		t.Fatalf("diffTrees = %+v", changes)
+	}
+	cmds := changeCommands(changes)
+	if len(cmds) != 1 || cmds[0].Subtype != "static" || cmds[0].Attrs["prefix"] != "20.0.0.0/24" {
+		t.Errorf("changeCommands = %+v", cmds)
+	}
+
+	// 不带 prefix 的 delete 删除该 subtype 的全部对象
+	if err := next.apply(mustCommand(t, "route delete static")); err != nil {
+		t.Fatal(err)
+	}
+	if len(next) != 1 {
+		t.Errorf("delete static 后对象数 = %d, want 1", len(next))
+	}
+
+	// sync 中同一 subtype 的多个块都保留，重复的标识报错
+	synced, err := parseTree("route sync { static { prefix 1.0.0.0/8 } static { prefix 2.0.0.0/8 } }")
+	if err != nil {
+		t.Fatalf("parseTree(sync): %v", err)
+	}
+	if len(synced) != 2 {
+		t.Errorf("sync 后对象数 = %d, want 2: %v", len(synced), synced.keys())
+	}
+	if _, err := parseTree("route sync { static { prefix 1.0.0.0/8 } static { prefix 1.0.0.0/8 } }"); err == nil {
+		t.Error("sync 中重复的对象: expected error")
+	}
+}
+
+// mustCommand 解析一条 DSL 语句
+func mustCommand(t *testing.T, src string) dsl.Command {
+	t.Helper()
+	cmds, err := dsl.NewParser(src).Parse()
+	if err != nil || len(cmds) != 1 {
+		t.Fatalf("parse %q: %v, %d 条语句", src, err, len(cmds))
+	}
+	return cmds[0]
+}
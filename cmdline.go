@@ -0,0 +1,307 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// 命令行词法单元
+type tokenKind int
+
+const (
+	tokWord tokenKind = iota
+	tokOp
+)
+
+type cmdToken struct {
+	kind tokenKind
+	val  string // tokWord 保留原始引号，执行前再展开
+}
+
+// redirect 描述一次重定向：> file, >> file, < file, 2> file, 2>&1
+type redirect struct {
+	fd     int    // 0 stdin, 1 stdout, 2 stderr
+	op     string // "<" ">" ">>" ">&"
+	target string // 文件名（原始单词）或 ">&" 的目标 fd
+}
+
+// simpleCmd 一条简单命令
+type simpleCmd struct {
+	words  []string
+	redirs []redirect
+	run    func(*Stdio) int // 不为 nil 时代替 words 执行，见 dslmode.go
+}
+
+// pipeline cmd1 | cmd2 | ... [| filter ...]
+type pipeline struct {
+	cmds    []*simpleCmd
+	filters []outputFilter // 末尾的输出过滤器，见 filter.go
+}
+
+// String 还原管道的命令行文本，用于作业列表显示
+func (p *pipeline) String() string {
+	parts := make([]string, 0, len(p.cmds))
+	for _, c := range p.cmds {
+		words := append([]string(nil), c.words...)
+		for _, r := range c.redirs {
+			op := r.op
+			if r.fd == 2 || (r.fd == 1 && r.op == ">&") {
+				op = fmt.Sprint(r.fd) + op
+			}
+			words = append(words, op+r.target)
+		}
+		parts = append(parts, strings.Join(words, " "))
+	}
+	for _, f := range p.filters {
+		parts = append(parts, f.String())
+	}
+	return strings.Join(parts, " | ")
+}
+
+// chainItem 由 && / || / ; / & 连接的一段管道，op 为其前面的连接符
+type chainItem struct {
+	op         string
+	pipe       *pipeline
+	background bool // 以 & 结尾，作为后台作业运行
+}
+
+var errUnclosedQuote = errors.New("引号未闭合")
+
+// splitLine 将命令行切分为单词和操作符，处理单/双引号与反斜杠转义
+func splitLine(line string) ([]cmdToken, error) {
+	var (
+		tokens []cmdToken
+		word   strings.Builder
+		inWord bool
+		quoted bool // 当前单词是否含引号，含引号的 "2" 不作为 fd
+	)
+	flush := func() {
+		if inWord {
+			tokens = append(tokens, cmdToken{kind: tokWord, val: word.String()})
+			word.Reset()
+			inWord, quoted = false, false
+		}
+	}
+	rs := []rune(line)
+	for i := 0; i < len(rs); i++ {
+		ch := rs[i]
+		switch {
+		case ch == '\\':
+			word.WriteRune(ch)
+			if i+1 < len(rs) {
+				i++
+				word.WriteRune(rs[i])
+			}
+			inWord = true
+		case ch == '\'' || ch == '"':
+			end, err := closingQuote(rs, i)
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(string(rs[i : end+1]))
+			i = end
+			inWord, quoted = true, true
+		case ch == '$' && i+1 < len(rs) && (rs[i+1] == '(' || rs[i+1] == '{'):
+			end, err := closingDollar(rs, i)
+			if err != nil {
+				return nil, err
+			}
+			word.WriteString(string(rs[i : end+1]))
+			i = end
+			inWord = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			flush()
+		case ch == '#' && !inWord:
+			// 单词开头的 # 之后为注释
+			i = len(rs)
+		case ch == '|' || ch == '&' || ch == ';':
+			flush()
+			op := string(ch)
+			if ch != ';' && i+1 < len(rs) && rs[i+1] == ch {
+				op += string(ch)
+				i++
+			}
+			tokens = append(tokens, cmdToken{kind: tokOp, val: op})
+		case ch == '>' || ch == '<':
+			op := string(ch)
+			// 紧邻的 1 / 2 作为文件描述符
+			if inWord && !quoted && (word.String() == "1" || word.String() == "2") && ch == '>' {
+				op = word.String() + op
+				word.Reset()
+				inWord = false
+			}
+			flush()
+			if ch == '>' && i+1 < len(rs) && rs[i+1] == '>' {
+				op += ">"
+				i++
+			} else if ch == '>' && i+1 < len(rs) && rs[i+1] == '&' {
+				op += "&"
+				i++
+			}
+			tokens = append(tokens, cmdToken{kind: tokOp, val: op})
+		default:
+			word.WriteRune(ch)
+			inWord = true
+		}
+	}
+	flush()
+	return tokens, nil
+}
+
+// closingQuote 返回 rs[i] 处引号对应的闭合引号下标，双引号内可嵌套 $( ) 与 ${ }
+func closingQuote(rs []rune, i int) (int, error) {
+	q := rs[i]
+	for j := i + 1; j < len(rs); j++ {
+		switch {
+		case rs[j] == q:
+			return j, nil
+		case q == '"' && rs[j] == '\\':
+			j++
+		case q == '"' && rs[j] == '$' && j+1 < len(rs) && (rs[j+1] == '(' || rs[j+1] == '{'):
+			end, err := closingDollar(rs, j)
+			if err != nil {
+				return 0, err
+			}
+			j = end
+		}
+	}
+	return 0, errUnclosedQuote
+}
+
+// closingDollar 返回 $( 或 ${ 对应的闭合括号下标，支持嵌套与引号
+func closingDollar(rs []rune, i int) (int, error) {
+	open := rs[i+1]
+	close := ')'
+	if open == '{' {
+		close = '}'
+	}
+	depth := 0
+	for j := i + 1; j < len(rs); j++ {
+		switch rs[j] {
+		case '\\':
+			j++
+		case '\'', '"':
+			end, err := closingQuote(rs, j)
+			if err != nil {
+				return 0, err
+			}
+			j = end
+		case open:
+			depth++
+		case close:
+			depth--
+			if depth == 0 {
+				return j, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("$%c 未闭合", open)
+}
+
+// parseLine 将命令行解析为 && / || / ; / & 连接的管道序列；
+// isCommand 判断名称是否为已注册的命令，同名的命令优先于过滤器，为 nil 时没有命令与过滤器同名
+func parseLine(line string, isCommand func(string) bool) ([]chainItem, error) {
+	tokens, err := splitLine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		items []chainItem
+		pipe  = &pipeline{}
+		cur   = &simpleCmd{}
+		op    string
+	)
+	// endPipe 结束当前管道，next 为触发结束的操作符（行尾为 ""）
+	endPipe := func(next string) error {
+		switch {
+		case len(cur.words) > 0:
+			pipe.cmds = append(pipe.cmds, cur)
+			cur = &simpleCmd{}
+		case len(cur.redirs) > 0:
+			return fmt.Errorf("语法错误: 重定向缺少命令")
+		case len(pipe.cmds) > 0:
+			return fmt.Errorf("语法错误: | 后缺少命令")
+		case op == "&&" || op == "||":
+			return fmt.Errorf("语法错误: %s 后缺少命令", op)
+		case next != "" && next != ";":
+			return fmt.Errorf("语法错误: %s 前缺少命令", next)
+		default:
+			return nil
+		}
+		if err := pipe.splitFilters(isCommand); err != nil {
+			return err
+		}
+		items = append(items, chainItem{op: op, pipe: pipe})
+		pipe = &pipeline{}
+		return nil
+	}
+
+	for i := 0; i < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.kind == tokWord {
+			cur.words = append(cur.words, tok.val)
+			continue
+		}
+		switch tok.val {
+		case "|":
+			if len(cur.words) == 0 {
+				return nil, fmt.Errorf("语法错误: | 前缺少命令")
+			}
+			pipe.cmds = append(pipe.cmds, cur)
+			cur = &simpleCmd{}
+		case "&&", "||", ";":
+			if err := endPipe(tok.val); err != nil {
+				return nil, err
+			}
+			op = tok.val
+		case "&":
+			if err := endPipe(tok.val); err != nil {
+				return nil, err
+			}
+			items[len(items)-1].background = true
+			op = tok.val
+		default:
+			// 重定向
+			if i+1 >= len(tokens) || tokens[i+1].kind != tokWord {
+				return nil, fmt.Errorf("语法错误: %s 后缺少目标", tok.val)
+			}
+			i++
+			r, err := newRedirect(tok.val, tokens[i].val)
+			if err != nil {
+				return nil, err
+			}
+			cur.redirs = append(cur.redirs, r)
+		}
+	}
+	if err := endPipe(""); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func newRedirect(op, target string) (redirect, error) {
+	r := redirect{fd: 1, target: target}
+	if strings.HasPrefix(op, "2") {
+		r.fd = 2
+		op = op[1:]
+	} else if strings.HasPrefix(op, "1") {
+		op = op[1:]
+	}
+	switch op {
+	case "<":
+		r.fd = 0
+		r.op = "<"
+	case ">", ">>":
+		r.op = op
+	case ">&":
+		if target != "1" && target != "2" {
+			return r, fmt.Errorf("语法错误: 不支持的重定向 %s%s", op, target)
+		}
+		r.op = ">&"
+	default:
+		return r, fmt.Errorf("语法错误: 未知重定向 %s", op)
+	}
+	return r, nil
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func wordTok(v string) cmdToken { return cmdToken{kind: tokWord, val: v} }
+func opTok(v string) cmdToken   { return cmdToken{kind: tokOp, val: v} }
+
+func TestSplitLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want []cmdToken
+	}{
+		{"ls -l  /tmp", []cmdToken{wordTok("ls"), wordTok("-l"), wordTok("/tmp")}},
+		{"a|b||c&&d;e&", []cmdToken{wordTok("a"), opTok("|"), wordTok("b"), opTok("||"), wordTok("c"), opTok("&&"), wordTok("d"), opTok(";"), wordTok("e"), opTok("&")}},
+		{"cmd >out >>log <in", []cmdToken{wordTok("cmd"), opTok(">"), wordTok("out"), opTok(">>"), wordTok("log"), opTok("<"), wordTok("in")}},
+		{"cmd 2>err 2>&1 1>out", []cmdToken{wordTok("cmd"), opTok("2>"), wordTok("err"), opTok("2>&"), wordTok("1"), opTok("1>"), wordTok("out")}},
+		{"cmd >&2", []cmdToken{wordTok("cmd"), opTok(">&"), wordTok("2")}},
+		// 带引号或不是独立单词的 2 不是文件描述符
+		{`echo "2">f x2>f`, []cmdToken{wordTok("echo"), wordTok(`"2"`), opTok(">"), wordTok("f"), wordTok("x2"), opTok(">"), wordTok("f")}},
+		// 引号保留到展开时，其中的操作符与空白不切分
+		{`echo 'a | b' "c; d"`, []cmdToken{wordTok("echo"), wordTok("'a | b'"), wordTok(`"c; d"`)}},
+		{`echo "a \" b"x`, []cmdToken{wordTok("echo"), wordTok(`"a \" b"x`)}},
+		{`echo a\ b \|`, []cmdToken{wordTok("echo"), wordTok(`a\ b`), wordTok(`\|`)}},
+		// $( ) 与 ${ } 作为一个单词，可嵌套并含引号
+		{"echo $(ls | wc -l) ${HOME}", []cmdToken{wordTok("echo"), wordTok("$(ls | wc -l)"), wordTok("${HOME}")}},
+		{`echo "$(echo ")")" $(a $(b))`, []cmdToken{wordTok("echo"), wordTok(`"$(echo ")")"`), wordTok("$(a $(b))")}},
+		{"echo a # comment | x", []cmdToken{wordTok("echo"), wordTok("a")}},
+		{"echo a#b", []cmdToken{wordTok("echo"), wordTok("a#b")}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			got, err := splitLine(tt.line)
+			if err != nil {
+				t.Fatalf("splitLine: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("tokens = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	for _, line := range []string{`echo "abc`, `echo 'abc`, "echo $(ls", "echo ${HOME"} {
+		if _, err := splitLine(line); err == nil {
+			t.Errorf("%s: expected error", line)
+		}
+	}
+}
+
+// chainString 把 parseLine 的结果还原为 "[op]管道[&]" 的序列，便于比较
+func chainString(items []chainItem) string {
+	parts := make([]string, len(items))
+	for i, item := range items {
+		s := item.pipe.String()
+		if item.op != "" {
+			s = item.op + " " + s
+		}
+		if item.background {
+			s += " &"
+		}
+		parts[i] = s
+	}
+	return strings.Join(parts, ", ")
+}
+
+func TestParseLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"ls -l", "ls -l"},
+		{"a | b | c", "a | b | c"},
+		{"a && b || c", "a, && b, || c"},
+		{"a; b;", "a, ; b"},
+		{"a & b", "a &, & b"},
+		{"sleep 1 &", "sleep 1 &"},
+		{"cmd > out 2>&1", "cmd >out 2>&1"},
+		{"cmd >> log 2> err < in", "cmd >>log 2>err <in"},
+		{"cmd 1>&2 | wc", "cmd 1>&2 | wc"},
+		{`echo "a && b" $(x; y) | wc`, `echo "a && b" $(x; y) | wc`},
+		{"show | match bgp | count", "show | match bgp | count"},
+		{"# only comment", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			items, err := parseLine(tt.line, nil)
+			if err != nil {
+				t.Fatalf("parseLine: %v", err)
+			}
+			if got := chainString(items); got != tt.want {
+				t.Errorf("parseLine = %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// 过滤器从管道中分离
+	items, err := parseLine("ls | grep a | match b | count", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := items[0].pipe; len(p.cmds) != 2 || len(p.filters) != 2 || p.filters[0].name != "match" {
+		t.Errorf("filters not split: cmds=%d filters=%v", len(p.cmds), p.filters)
+	}
+
+	// 与已注册命令同名时按命令执行
+	isCommand := func(name string) bool { return name == "count" }
+	items, err = parseLine("ls | count | match a", isCommand)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := items[0].pipe; len(p.cmds) != 2 || len(p.filters) != 1 || p.filters[0].name != "match" {
+		t.Errorf("registered command treated as filter: cmds=%d filters=%v", len(p.cmds), p.filters)
+	}
+	if _, err := parseLine("ls | match a | count", isCommand); err == nil {
+		t.Error("command after filter: expected error")
+	}
+
+	bad := []struct {
+		line, msg string
+	}{
+		{"| a", "| 前缺少命令"},
+		{"a |", "| 后缺少命令"},
+		{"a && ", "&& 后缺少命令"},
+		{"&& a", "&& 前缺少命令"},
+		{"a ||", "|| 后缺少命令"},
+		{"a >", "> 后缺少目标"},
+		{"a > | b", "> 后缺少目标"},
+		{"> out", "重定向缺少命令"},
+		{"a 2>&3", "不支持的重定向"},
+		{`echo "x`, "引号未闭合"},
+	}
+	for _, tt := range bad {
+		if _, err := parseLine(tt.line, nil); err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: expected error %q, got %v", tt.line, tt.msg, err)
+		}
+	}
+}
+
+func TestNewRedirect(t *testing.T) {
+	tests := []struct {
+		op, target string
+		want       redirect
+	}{
+		{">", "out", redirect{fd: 1, op: ">", target: "out"}},
+		{"1>", "out", redirect{fd: 1, op: ">", target: "out"}},
+		{">>", "log", redirect{fd: 1, op: ">>", target: "log"}},
+		{"2>", "err", redirect{fd: 2, op: ">", target: "err"}},
+		{"2>>", "err", redirect{fd: 2, op: ">>", target: "err"}},
+		{"<", "in", redirect{fd: 0, op: "<", target: "in"}},
+		{"2>&", "1", redirect{fd: 2, op: ">&", target: "1"}},
+		{">&", "2", redirect{fd: 1, op: ">&", target: "2"}},
+		{"1>&", "2", redirect{fd: 1, op: ">&", target: "2"}},
+	}
+	for _, tt := range tests {
+		got, err := newRedirect(tt.op, tt.target)
+		if err != nil || got != tt.want {
+			t.Errorf("newRedirect(%q, %q) = %+v, %v, want %+v", tt.op, tt.target, got, err, tt.want)
+		}
+	}
+
+	for _, tt := range [][2]string{{">&", "3"}, {"2>&", "file"}, {"<<", "x"}} {
+		if _, err := newRedirect(tt[0], tt[1]); err == nil {
+			t.Errorf("newRedirect(%q, %q): expected error", tt[0], tt[1])
+		}
+	}
+}
@@ -2,20 +2,40 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/pelletier/go-toml/v2"
 )
 
+// Stdio 命令的标准输入输出，管道与重定向时替换为对应文件
+type Stdio struct {
+	In  io.Reader
+	Out io.Writer
+	Err io.Writer
+	Job *Job // 所属作业，外部命令在作业的进程组中运行
+
+	Params []string // 函数的位置参数 $0..$n
+	depth  int      // 别名与函数的嵌套层数
+}
+
+func defaultStdio() *Stdio {
+	return &Stdio{In: os.Stdin, Out: os.Stdout, Err: os.Stderr}
+}
+
 // Command 接口
 type Command interface {
 	Name() string
 	Path() string // 外部命令路径，内置命令返回 ""
-	Execute(args []string, env map[string]string) error
+	Execute(args []string, env map[string]string, stdio *Stdio) error
 	IsBuiltin() bool
 	Desc() string
 	Usage() string
@@ -31,65 +51,157 @@ type FileCommand struct {
 	name     string
 	path     string
 	category string
+	modTime  time.Time    // 用于判断文件是否被替换或修改
+	descMgr  *DescManager // 执行时读取 desc.toml 中的执行限制
 }
 
 func (f *FileCommand) Name() string          { return f.name }
 func (f *FileCommand) Category() string      { return f.category }
 func (f *FileCommand) Path() string          { return f.path }
 func (f *FileCommand) IsBuiltin() bool       { return false }
-func (f *FileCommand) Desc() string          { return "" }
-func (f *FileCommand) Usage() string         { return "" }
-func (f *FileCommand) Args() []string        { return nil }
-func (f *FileCommand) Returns() []string     { return nil }
-func (f *FileCommand) Flags() []string       { return nil }
-func (f *FileCommand) Subcommands() []string { return nil }
-func (f *FileCommand) Execute(args []string, env map[string]string) error {
+func (f *FileCommand) Desc() string          { return f.desc().Desc }
+func (f *FileCommand) Usage() string         { return f.desc().Usage }
+func (f *FileCommand) Args() []string        { return f.desc().Args }
+func (f *FileCommand) Returns() []string     { return f.desc().Returns }
+func (f *FileCommand) Flags() []string       { return f.desc().Flags }
+func (f *FileCommand) Subcommands() []string { return f.desc().Subcommands }
+
+// desc 外部命令的描述来自 desc.toml，未登记时为空
+func (f *FileCommand) desc() CommandDesc {
+	if f.descMgr == nil {
+		return CommandDesc{}
+	}
+	d, _ := f.descMgr.Get(f.name)
+	return d
+}
+func (f *FileCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if schema := f.desc().Schema; schema != nil {
+		if err := schema.check(); err != nil {
+			return fmt.Errorf("desc.toml 中 %s 的 schema 无效: %v", f.name, err)
+		}
+		checked, err := schema.Apply(args)
+		if argErr, ok := err.(*ArgError); ok {
+			argErr.render(stdio.Err, f.Usage())
+			return &exitCodeError{code: 2}
+		}
+		args = checked
+	}
 	cmd := exec.Command(f.path, args[1:]...)
 	cmd.Env = mergeEnv(env)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+	cmd.Stdin = stdio.In
+	cmd.Stdout = stdio.Out
+	cmd.Stderr = stdio.Err
+
+	policy, err := f.policy()
+	if err != nil {
+		return err
+	}
+	if policy == nil {
+		return f.run(cmd, stdio, nil)
+	}
+	if err := policy.prepare(cmd); err != nil {
+		return err
+	}
+	onStart, stop := policy.watch()
+	err = f.run(cmd, stdio, onStart)
+	return policy.explain(err, stop())
+}
+
+// policy 读取 desc.toml 中声明的执行限制
+func (f *FileCommand) policy() (*ExecPolicy, error) {
+	policy, err := f.desc().Policy()
+	if err != nil {
+		return nil, fmt.Errorf("desc.toml 中 %s 的%v", f.name, err)
+	}
+	return policy, nil
+}
+
+// run 启动并等待外部命令，属于作业时在作业的进程组中运行
+func (f *FileCommand) run(cmd *exec.Cmd, stdio *Stdio, onStart func(pid int)) error {
+	if stdio.Job != nil {
+		return stdio.Job.run(cmd, onStart)
+	}
+	if onStart != nil {
+		// 不属于作业时自建进程组，超时可整组终止
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Setpgid = true
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if onStart != nil {
+		onStart(cmd.Process.Pid)
+	}
+	return cmd.Wait()
 }
 
 // Builtin List
-type ListCommand struct{}
+type ListCommand struct {
+	shell *Shell
+}
+
+func NewListCommand(s *Shell) *ListCommand {
+	return &ListCommand{shell: s}
+}
 
 func (l *ListCommand) Name() string          { return "list" }
 func (l *ListCommand) Category() string      { return "sys" }
 func (l *ListCommand) Path() string          { return "" }
 func (l *ListCommand) IsBuiltin() bool       { return true }
 func (l *ListCommand) Desc() string          { return "打印全部命令" }
-func (l *ListCommand) Usage() string         { return "exit" }
+func (l *ListCommand) Usage() string         { return "list" }
 func (l *ListCommand) Args() []string        { return []string{""} }
 func (l *ListCommand) Returns() []string     { return []string{"展示所有命令！"} }
 func (l *ListCommand) Flags() []string       { return nil }
 func (l *ListCommand) Subcommands() []string { return nil }
-func (l *ListCommand) Execute(args []string, env map[string]string) error {
-
+func (l *ListCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	l.shell.List(stdio.Out)
 	return nil
 }
 
+// exitRequest 由 exit 返回，通知 Shell 结束会话；code 为 -1 时沿用上一条命令的退出码
+type exitRequest struct {
+	code int
+}
+
+func (e *exitRequest) Error() string { return "exit" }
+
 // Builtin Exit
 type ExitCommand struct{}
 
-func (e *ExitCommand) Name() string          { return "exit" }
-func (e *ExitCommand) Category() string      { return "sys" }
-func (e *ExitCommand) Path() string          { return "" }
-func (e *ExitCommand) IsBuiltin() bool       { return true }
-func (e *ExitCommand) Desc() string          { return "退出flyos环境" }
-func (e *ExitCommand) Usage() string         { return "exit" }
-func (e *ExitCommand) Args() []string        { return []string{"[]"} }
+func (e *ExitCommand) Name() string     { return "exit" }
+func (e *ExitCommand) Category() string { return "sys" }
+func (e *ExitCommand) Path() string     { return "" }
+func (e *ExitCommand) IsBuiltin() bool  { return true }
+func (e *ExitCommand) Desc() string     { return "退出flyos环境" }
+func (e *ExitCommand) Usage() string    { return "exit [N]" }
+func (e *ExitCommand) Args() []string {
+	return []string{"N 可选，退出码，默认为上一条命令的退出码"}
+}
 func (e *ExitCommand) Returns() []string     { return []string{"退出环境！"} }
 func (e *ExitCommand) Flags() []string       { return nil }
 func (e *ExitCommand) Subcommands() []string { return nil }
-func (e *ExitCommand) Execute(args []string, env map[string]string) error {
-	fmt.Println("👋 Bye!")
-	return nil
+func (e *ExitCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) <= 1 {
+		return &exitRequest{code: -1}
+	}
+	code, err := strconv.Atoi(args[1])
+	if err != nil || code < 0 || code > 255 {
+		return fmt.Errorf("非法退出码: %s", args[1])
+	}
+	return &exitRequest{code: code}
 }
 
 // Builtin Env
-type EnvCommand struct{}
+type EnvCommand struct {
+	shell *Shell
+}
+
+func NewEnvCommand(s *Shell) *EnvCommand {
+	return &EnvCommand{shell: s}
+}
 
 func (e *EnvCommand) Name() string          { return "env" }
 func (e *EnvCommand) Category() string      { return "sys" }
@@ -101,26 +213,30 @@ func (e *EnvCommand) Args() []string        { return []string{"VAR 可选，需
 func (e *EnvCommand) Returns() []string     { return []string{"打印环境变量内容"} }
 func (e *EnvCommand) Flags() []string       { return nil }
 func (e *EnvCommand) Subcommands() []string { return nil }
-func (e *EnvCommand) Execute(args []string, env map[string]string) error {
+func (e *EnvCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
 	allEnv := mergeEnv(env)
-	if len(args) <= 1 {
+	if len(args) > 1 {
+		filter := make(map[string]bool)
+		for _, key := range args[1:] {
+			filter[key] = true
+		}
+		selected := allEnv[:0]
 		for _, v := range allEnv {
-			fmt.Println(v)
+			parts := strings.SplitN(v, "=", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			if filter[parts[0]] {
+				selected = append(selected, v)
+			}
 		}
-		return nil
+		allEnv = selected
 	}
-	filter := make(map[string]bool)
-	for _, key := range args[1:] {
-		filter[key] = true
+	if f := e.shell.OutputFormat(); f != OutputText {
+		return writeEnv(stdio.Out, f, allEnv)
 	}
 	for _, v := range allEnv {
-		parts := strings.SplitN(v, "=", 2)
-		if len(parts) != 2 {
-			continue
-		}
-		if filter[parts[0]] {
-			fmt.Println(v)
-		}
+		fmt.Fprintln(stdio.Out, v)
 	}
 	return nil
 }
@@ -142,42 +258,59 @@ func (h *HelpCommand) IsBuiltin() bool  { return true }
 func (h *HelpCommand) Desc() string {
 	return "🔍 显示命令或分类的帮助信息（支持模糊搜索）"
 }
-func (h *HelpCommand) Usage() string { return "help [COMMAND|CATEGORY|KEYWORD]" }
+func (h *HelpCommand) Usage() string {
+	return "help [COMMAND|CATEGORY [GROUP...]|KEYWORD] | help export [--format man|markdown|html] --dir DIR [--self-described]"
+}
 func (h *HelpCommand) Args() []string {
-	return []string{"命令名、分类名或关键字（可选）"}
+	return []string{"命令名、分类名（其后可跟各级分组）或关键字（可选）"}
+}
+func (h *HelpCommand) Returns() []string { return []string{"打印帮助信息"} }
+func (h *HelpCommand) Flags() []string {
+	return []string{
+		"--format FORMAT  # export 的格式：man、markdown（默认）或 html",
+		"--dir DIR        # export 的输出目录",
+		"--self-described # export 时一并导出 --describe 自描述的外部命令（默认只导出内置命令与 desc.toml）",
+	}
+}
+func (h *HelpCommand) Subcommands() []string {
+	return []string{"export    # 把内置命令与 desc.toml 中的命令导出为文档，不受角色限制，help export 不带选项时仍显示 export 命令的帮助"}
 }
-func (h *HelpCommand) Returns() []string     { return []string{"打印帮助信息"} }
-func (h *HelpCommand) Flags() []string       { return nil }
-func (h *HelpCommand) Subcommands() []string { return nil }
 
-func (h *HelpCommand) Execute(args []string, env map[string]string) error {
+func (h *HelpCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	w := stdio.Out
+	if len(args) > 2 && args[1] == "export" && strings.HasPrefix(args[2], "-") {
+		return h.exportHelp(args[2:], stdio)
+	}
+	if f := h.shell.OutputFormat(); f != OutputText {
+		return h.writeStructured(w, f, args)
+	}
 	// 只输入 help 时
 	builtins := []Command{}
 	if len(args) == 1 {
 		// 1️⃣ 打印所有内置命令
-		fmt.Println("🛠️  内置命令:")
-		for _, cmd := range h.shell.commands {
-			if cmd.IsBuiltin() {
+		fmt.Fprintln(w, "🛠️  内置命令:")
+		for _, cmd := range h.shell.Commands() {
+			if cmd.IsBuiltin() && h.shell.Allowed(cmd) {
 				builtins = append(builtins, cmd)
 			}
 		}
 		sort.Slice(builtins, func(i, j int) bool { return builtins[i].Name() < builtins[j].Name() })
 		for _, c := range builtins {
-			fmt.Printf("  %-10s - %s\n", c.Name(), c.Desc())
+			fmt.Fprintf(w, "  %-10s - %s\n", c.Name(), c.Desc())
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 
 		// 2️⃣ 打印外部命令分类
-		cats := h.descMgr.getAllCategories()
+		cats := h.categories()
 		if len(cats) > 0 {
-			fmt.Println("🗂  外部命令分类:")
+			fmt.Fprintln(w, "🗂  外部命令分类:")
 			sort.Strings(cats)
 			for _, c := range cats {
-				fmt.Println("  " + c)
+				fmt.Fprintln(w, "  "+c)
 			}
-			fmt.Println("\n💡 使用 `help [分类名]` 查看分类内命令")
+			fmt.Fprintln(w, "\n💡 使用 `help [分类名]` 查看分类内命令")
 		} else {
-			fmt.Println("⚠️ 暂无外部命令")
+			fmt.Fprintln(w, "⚠️ 暂无外部命令")
 		}
 
 		return nil
@@ -185,33 +318,35 @@ func (h *HelpCommand) Execute(args []string, env map[string]string) error {
 
 	// 以下为带参数时的原有逻辑
 	target := args[1]
+	permitted := h.shell.permitted(target, h.descMgr)
 
-	// 精确匹配内置命令
-	if cmd, ok := h.shell.commands[target]; ok && cmd.IsBuiltin() {
-		h.descMgr.PrintHelp(target, h.shell)
+	// 精确匹配内置命令、别名与函数
+	if cmd, ok := h.shell.Lookup(target); ok && permitted && selfDescribed(cmd) {
+		h.descMgr.PrintHelp(w, target, h.shell)
 		return nil
 	}
 
 	// 精确匹配外部命令
-	if _, ok := h.descMgr.Get(target); ok {
-		h.descMgr.PrintHelp(target, h.shell)
+	if _, ok := h.descMgr.Get(target); ok && permitted {
+		h.descMgr.PrintHelp(w, target, h.shell)
 		return nil
 	}
 
-	// 匹配外部命令分类
-	for _, cat := range h.descMgr.getAllCategories() {
-		if strings.EqualFold(cat, target) {
-			fmt.Printf("🗂  分类: %s\n\n", cat)
-			if v, ok := h.descMgr.categories.Load(cat); ok {
-				cmds := v.([]string)
-				for _, name := range cmds {
-					if desc, ok := h.descMgr.Get(name); ok {
-						fmt.Printf("  %-20s - %s\n", name, desc.Desc)
-					}
-				}
-			}
+	// 匹配外部命令分类，其后的参数逐层进入分组，如 help system net
+	if cat, ok := h.category(target); ok {
+		n, path, found := h.lookupNode(cat, args[2:])
+		if !found {
+			missing := args[2+len(path)-1]
+			fmt.Fprintf(w, "⚠️ %s 下没有分组 '%s'\n", helpPath(path), missing)
+			printSuggestions(w, suggest(missing, h.groupNames(n)))
 			return nil
 		}
+		var extra []Command
+		if len(path) == 1 {
+			extra = h.selfDescribedIn(cat)
+		}
+		h.printNode(w, path, n, extra)
+		return nil
 	}
 
 	// 模糊匹配关键字（内置 + 外部）
@@ -225,6 +360,9 @@ func (h *HelpCommand) Execute(args []string, env map[string]string) error {
 	h.descMgr.desc.Range(func(k, v any) bool {
 		name := k.(string)
 		desc := v.(CommandDesc)
+		if !h.shell.permitted(name, h.descMgr) {
+			return true
+		}
 		if strings.Contains(strings.ToLower(name), strings.ToLower(target)) ||
 			strings.Contains(strings.ToLower(desc.Desc), strings.ToLower(target)) {
 			matches = append(matches, name)
@@ -233,103 +371,298 @@ func (h *HelpCommand) Execute(args []string, env map[string]string) error {
 	})
 
 	if len(matches) == 0 {
-		fmt.Printf("⚠️ 未找到与 '%s' 相关的命令\n", target)
+		fmt.Fprintf(w, "⚠️ 未找到与 '%s' 相关的命令\n", target)
+		printSuggestions(w, h.suggestFor(target))
 		return nil
 	}
 
-	fmt.Printf("\n🔍 匹配到 %d 个命令:\n", len(matches))
+	fmt.Fprintf(w, "\n🔍 匹配到 %d 个命令:\n", len(matches))
 	for _, m := range matches {
-		if cmd, ok := h.shell.commands[m]; ok {
-			fmt.Printf("  %-20s - %s\n", cmd.Name(), cmd.Desc())
+		if cmd, ok := h.shell.Lookup(m); ok {
+			fmt.Fprintf(w, "  %-20s - %s\n", cmd.Name(), cmd.Desc())
 		} else if desc, ok := h.descMgr.Get(m); ok {
-			fmt.Printf("  %-20s - %s\n", m, desc.Desc)
+			fmt.Fprintf(w, "  %-20s - %s\n", m, desc.Desc)
 		}
 	}
-	fmt.Println("\n💡 使用 `help [命令名]` 查看详细帮助")
+	fmt.Fprintln(w, "\n💡 使用 `help [命令名]` 查看详细帮助")
 	return nil
 }
 
-// CommandDesc
+// selfDescribed 命令自身带有描述（内置命令、别名、函数），帮助信息不依赖 desc.toml
+func selfDescribed(cmd Command) bool {
+	return cmd.IsBuiltin() || cmd.Desc() != ""
+}
+
+// selfDescribedIn 返回分类下自带描述且未在 desc.toml 中登记的外部命令
+func (h *HelpCommand) selfDescribedIn(cat string) []Command {
+	var cmds []Command
+	for _, cmd := range h.shell.Commands() {
+		if _, described := h.descMgr.Get(cmd.Name()); described || cmd.IsBuiltin() || !selfDescribed(cmd) {
+			continue
+		}
+		if strings.EqualFold(cmd.Category(), cat) && h.shell.Allowed(cmd) {
+			cmds = append(cmds, cmd)
+		}
+	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name() < cmds[j].Name() })
+	return cmds
+}
+
+// categories desc.toml 中的分类加上别名、函数所在的分类，只保留含有当前角色可见命令的分类
+func (h *HelpCommand) categories() []string {
+	seen := make(map[string]bool)
+	var cats []string
+	for _, c := range h.descMgr.getAllCategories() {
+		v, _ := h.descMgr.categories.Load(c)
+		names, _ := v.([]string)
+		for _, name := range names {
+			if h.shell.permitted(name, h.descMgr) {
+				seen[c] = true
+				cats = append(cats, c)
+				break
+			}
+		}
+	}
+	for _, cmd := range h.shell.Commands() {
+		if !cmd.IsBuiltin() && selfDescribed(cmd) && !seen[cmd.Category()] && h.shell.Allowed(cmd) {
+			seen[cmd.Category()] = true
+			cats = append(cats, cmd.Category())
+		}
+	}
+	return cats
+}
+
+// CommandDesc desc.toml 中的一条命令描述；json 标签用于 --flyos-describe 自描述协议
 type CommandDesc struct {
-	Category    string   `toml:"-"`
-	Desc        string   `toml:"desc"`
-	Usage       string   `toml:"usage"`
-	Args        []string `toml:"args"`
-	Subcommands []string `toml:"subcommands"`
-	Flags       []string `toml:"flags"`
-	Returns     []string `toml:"returns"`
+	Category    string   `toml:"-" json:"category"`
+	Group       []string `toml:"-" json:"group"` // 分类下的分组路径，desc.toml 中为表的中间层级
+	Desc        string   `toml:"desc" json:"desc"`
+	Usage       string   `toml:"usage" json:"usage"`
+	Args        []string `toml:"args" json:"args"`
+	Subcommands []string `toml:"subcommands" json:"subcommands"`
+	Flags       []string `toml:"flags" json:"flags"`
+	Returns     []string `toml:"returns" json:"returns"`
+
+	// 执行限制，见 ExecPolicy
+	Timeout       interface{} `toml:"timeout" json:"timeout"`                 // 秒数或时长，如 30、"5m"
+	MaxMemory     interface{} `toml:"max_memory" json:"max_memory"`           // 字节数或带单位，如 "512M"
+	MaxCPUSeconds int64       `toml:"max_cpu_seconds" json:"max_cpu_seconds"` // CPU 时间上限（秒）
+	RunAsUser     string      `toml:"run_as_user" json:"run_as_user"`         // 以指定用户运行
+	Nice          int         `toml:"nice" json:"nice"`                       // 调度优先级 -20..19
+
+	Schema *ArgSchema `toml:"schema" json:"schema"` // 参数定义，执行前检查
 }
 
 // DescManager
 type DescManager struct {
-	desc       sync.Map // 命令名 -> CommandDesc
-	categories sync.Map // 分类 -> []string
+	desc       sync.Map               // 命令名 -> CommandDesc，来自 desc.toml
+	auto       sync.Map               // 命令名 -> CommandDesc，来自命令的 --flyos-describe 输出
+	legacy     sync.Map               // 旧写法的完整名（如 hello.sh） -> CommandDesc
+	trusted    sync.Map               // 命令名 -> 分类，来自 /etc/flyos/desc.toml，授权只用这里的分类
+	isCommand  func(name string) bool // 是否为已注册的命令，解析带 . 的命令名
+	categories sync.Map               // 分类 -> []string，包含各级分组中的命令
+	tree       atomic.Pointer[helpNode]
+	path       string // desc.toml 路径，供 desc lint / generate 使用
+
+	cache *describeCache
 }
 
 func NewDescManager() *DescManager {
 	return &DescManager{}
 }
 func (d *DescManager) Load(path string) error {
-	data, err := os.ReadFile(path)
+	d.path = path
+	raw, err := readDescFile(path)
 	if err != nil {
 		return err
 	}
 
+	entries, dups := d.descEntries(raw)
+	d.desc = sync.Map{}
+	d.legacy = sync.Map{}
+	for name, e := range entries {
+		// 首段为分类，末段为命令名，中间各段为帮助树中的分组
+		fullName := e.fullName
+		bytes, _ := toml.Marshal(e.node)
+		var desc CommandDesc
+		if err := toml.Unmarshal(bytes, &desc); err != nil {
+			fmt.Printf("❌ 解析命令 %s 失败: %v\n", strings.Join(fullName, "."), err)
+			continue
+		}
+		desc.Category = fullName[0]
+		if joined := strings.Join(fullName[1:], "."); joined != name {
+			desc.Group = fullName[1 : len(fullName)-1]
+			// 兼容旧写法 [sys.hello.sh]：仍可按 hello.sh 查到，新写法为 [sys."hello.sh"]
+			if len(fullName) > 2 {
+				d.legacy.Store(joined, desc)
+			}
+		}
+		d.desc.Store(name, desc)
+	}
+	d.rebuildCategories()
+
+	logInfo("📄 desc.toml 已加载，共 %d 条📄命令，%d 个🗂分类\n", d.countCommands(), len(d.getAllCategories()))
+	if len(dups) > 0 {
+		return fmt.Errorf("❌ desc.toml 中命令名重复，未加载: %s", strings.Join(dups, "; "))
+	}
+	return nil
+}
+
+// LoadTrusted 读取授权使用的分类。desc.toml 通常在用户目录下，用户可以随意把命令改到
+// 被允许的分类，因此授权只采用 /etc/flyos 中可信的 desc.toml（见 isTrustedLayer）里的分类
+func (d *DescManager) LoadTrusted(path string) {
+	d.trusted = sync.Map{}
+	if !isTrustedLayer(path) {
+		return
+	}
+	raw, err := readDescFile(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+		return
+	}
+	entries, dups := d.descEntries(raw)
+	if len(dups) > 0 {
+		fmt.Fprintf(os.Stderr, "⚠️ %s 中命令名重复，按 default 分类授权: %s\n", path, strings.Join(dups, "; "))
+	}
+	for name, e := range entries {
+		d.trusted.Store(name, e.fullName[0])
+	}
+}
+
+// descEntry desc.toml 中的一个命令条目
+type descEntry struct {
+	fullName []string // 表路径：分类、各级分组、命令名
+	node     map[string]interface{}
+}
+
+// descEntries 按命令名归并 desc.toml 中的条目。表路径 [分类.分组….命令名] 的末段为命令名；
+// 命令名本身带 . 的旧写法（如 [sys.hello.sh]）在整体是已注册的命令、末段不是时视为一个命令名，
+// 与 desc lint 相同。多个条目得到同一命令名时都不采用，dups 中说明冲突的条目
+func (d *DescManager) descEntries(raw map[string]interface{}) (entries map[string]descEntry, dups []string) {
+	isCommand := d.isCommand
+	if isCommand == nil {
+		isCommand = func(string) bool { return false }
+	}
+	byName := make(map[string][]descEntry)
+	walkDesc(raw, func(fullName []string, node map[string]interface{}) {
+		name := fullName[len(fullName)-1]
+		if joined := strings.Join(fullName[1:], "."); len(fullName) > 2 && isCommand(joined) && !isCommand(name) {
+			name = joined
+		}
+		byName[name] = append(byName[name], descEntry{fullName: fullName, node: node})
+	})
+
+	entries = make(map[string]descEntry, len(byName))
+	var names []string
+	for name, list := range byName {
+		if len(list) == 1 {
+			entries[name] = list[0]
+		} else {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keys := make([]string, len(byName[name]))
+		for i, e := range byName[name] {
+			keys[i] = "[" + descKey(e.fullName) + "]"
+		}
+		sort.Strings(keys)
+		dups = append(dups, fmt.Sprintf("%s: %s", name, strings.Join(keys, ", ")))
+	}
+	return entries, dups
+}
+
+// trustedCategory 可信 desc.toml 中登记的分类
+func (d *DescManager) trustedCategory(name string) (string, bool) {
+	v, ok := d.trusted.Load(name)
+	if !ok {
+		return "", false
+	}
+	return v.(string), true
+}
+
+// readDescFile 读取并解析 desc.toml
+func readDescFile(path string) (map[string]interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 	var raw map[string]interface{}
 	if err := toml.Unmarshal(data, &raw); err != nil {
-		return fmt.Errorf("❌ 解析 desc.toml 失败: %v", err)
+		return nil, fmt.Errorf("❌ 解析 desc.toml 失败: %v", err)
 	}
+	return raw, nil
+}
 
-	d.desc = sync.Map{}
-	d.categories = sync.Map{}
-
+// walkDesc 对 desc.toml 中每个带 desc 的表调用 fn，fullName 为表的完整路径；缺少分类的条目跳过，desc lint 会报告
+func walkDesc(raw map[string]interface{}, fn func(fullName []string, node map[string]interface{})) {
 	var walk func(m map[string]interface{}, prefix []string)
 	walk = func(m map[string]interface{}, prefix []string) {
 		for k, v := range m {
-			switch node := v.(type) {
-			case map[string]interface{}:
-				// 判断是否已经是 CommandDesc 结构
-				if _, ok := node["desc"]; ok {
-					fullName := append(prefix, k)
-					category := fullName[0]
-					name := strings.Join(fullName[1:], ".")
-					bytes, _ := toml.Marshal(node)
-					var desc CommandDesc
-					if err := toml.Unmarshal(bytes, &desc); err != nil {
-						fmt.Printf("❌ 解析命令 %s 失败: %v\n", strings.Join(fullName, "."), err)
-						continue
-					}
-					desc.Category = category
-					d.desc.Store(name, desc)
-
-					val, _ := d.categories.LoadOrStore(category, []string{})
-					cmds := append(val.([]string), name)
-					d.categories.Store(category, cmds)
-				} else {
-					// 继续递归
-					walk(node, append(prefix, k))
-				}
-			default:
-				// 忽略非 map 节点
+			node, ok := v.(map[string]interface{})
+			if !ok {
+				continue // 忽略非 map 节点
+			}
+			fullName := append(append([]string(nil), prefix...), k)
+			if _, ok := node["desc"]; !ok {
+				walk(node, fullName)
+				continue
+			}
+			if len(fullName) >= 2 {
+				fn(fullName, node)
 			}
 		}
 	}
-
-	walk(raw, []string{})
-
-	fmt.Printf("📄 desc.toml 已加载，共 %d 条📄命令，%d 个🗂分类\n", d.countCommands(), len(d.getAllCategories()))
-	return nil
+	walk(raw, nil)
 }
 
-// Get
+// Get desc.toml 中的描述优先于命令自描述
 func (d *DescManager) Get(name string) (CommandDesc, bool) {
 	v, ok := d.desc.Load(name)
 	if !ok {
-		return CommandDesc{}, false
+		if v, ok = d.auto.Load(name); !ok {
+			if v, ok = d.legacy.Load(name); !ok {
+				return CommandDesc{}, false
+			}
+		}
 	}
 	return v.(CommandDesc), true
 }
 
+// inFile 是否在 desc.toml 中描述
+func (d *DescManager) inFile(name string) bool {
+	_, ok := d.desc.Load(name)
+	return ok
+}
+
+// rebuildCategories 按 desc.toml 与自描述重建分类索引与帮助树，同名时以 desc.toml 为准
+func (d *DescManager) rebuildCategories() {
+	index := make(map[string][]string)
+	root := newHelpNode("")
+	add := func(name string, desc CommandDesc) {
+		index[desc.Category] = append(index[desc.Category], name)
+		root.add(append([]string{desc.Category}, desc.Group...), name)
+	}
+	d.desc.Range(func(k, v any) bool {
+		add(k.(string), v.(CommandDesc))
+		return true
+	})
+	d.auto.Range(func(k, v any) bool {
+		if !d.inFile(k.(string)) {
+			add(k.(string), v.(CommandDesc))
+		}
+		return true
+	})
+	d.categories = sync.Map{}
+	for cat, names := range index {
+		sort.Strings(names)
+		d.categories.Store(cat, names)
+	}
+	root.sort()
+	d.tree.Store(root)
+}
+
 // countCommands
 func (d *DescManager) countCommands() int {
 	cnt := 0
@@ -350,35 +683,46 @@ func (d *DescManager) getAllCategories() []string {
 	return keys
 }
 
-func (d *DescManager) PrintHelp(name string, shell *Shell) {
+func (d *DescManager) PrintHelp(w io.Writer, name string, shell *Shell) {
+	if f := shell.OutputFormat(); f != OutputText {
+		d.writeCommandHelp(w, f, name, shell)
+		return
+	}
+	if !shell.permitted(name, d) {
+		fmt.Fprintf(w, "⚠️ 未找到命令 %s\n", name)
+		return
+	}
 
-	// 先检查内置
-	if cmd, ok := shell.commands[name]; ok && cmd.IsBuiltin() {
-		fmt.Printf("📄  Command:  %-5s\n", cmd.Name())
-		fmt.Printf("🗂   Category: %-5s\n", cmd.Category())
-		fmt.Printf("📌  Usage:\n      %s\n", cmd.Usage())
+	// 先检查内置与自带描述的命令（别名、函数）
+	if cmd, ok := shell.Lookup(name); ok && selfDescribed(cmd) {
+		fmt.Fprintf(w, "📄  Command:  %-5s\n", cmd.Name())
+		fmt.Fprintf(w, "🗂   Category: %-5s\n", cmd.Category())
+		fmt.Fprintf(w, "📌  Usage:\n      %s\n", cmd.Usage())
 		if len(cmd.Flags()) > 0 {
-			fmt.Println("🏷️  Flags:")
+			fmt.Fprintln(w, "🏷️  Flags:")
 			for _, v := range cmd.Flags() {
-				fmt.Println("      " + v)
+				fmt.Fprintln(w, "      "+v)
 			}
 		}
 		if len(cmd.Subcommands()) > 0 {
-			fmt.Println("🧩  Subcommands:")
+			fmt.Fprintln(w, "🧩  Subcommands:")
 			for _, v := range cmd.Subcommands() {
-				fmt.Println("      " + v)
+				fmt.Fprintln(w, "      "+v)
 			}
 		}
 		if len(cmd.Args()) > 0 {
-			fmt.Println("📥  Args:")
+			fmt.Fprintln(w, "📥  Args:")
 			for _, v := range cmd.Args() {
-				fmt.Println("      " + v)
+				fmt.Fprintln(w, "      "+v)
 			}
 		}
+		if _, ok := cmd.(*FileCommand); ok {
+			d.printSchema(w, name)
+		}
 		if len(cmd.Returns()) > 0 {
-			fmt.Println("📤  Returns:")
+			fmt.Fprintln(w, "📤  Returns:")
 			for _, v := range cmd.Returns() {
-				fmt.Println("      " + v)
+				fmt.Fprintln(w, "      "+v)
 			}
 		}
 		return
@@ -387,35 +731,36 @@ func (d *DescManager) PrintHelp(name string, shell *Shell) {
 	// 外部命令
 	c, ok := d.Get(name)
 	if !ok {
-		fmt.Printf("⚠️ 未找到命令 %s\n", name)
+		fmt.Fprintf(w, "⚠️ 未找到命令 %s\n", name)
 		return
 	}
 
-	fmt.Printf("📄 Command:  %-5s\n", name)
-	fmt.Printf("🗂  Category: %-5s\n", c.Category)
-	fmt.Printf("📌  Usage:\n      %s\n", c.Usage)
+	fmt.Fprintf(w, "📄 Command:  %-5s\n", name)
+	fmt.Fprintf(w, "🗂  Category: %-5s\n", c.Category)
+	fmt.Fprintf(w, "📌  Usage:\n      %s\n", c.Usage)
 	if len(c.Flags) > 0 {
-		fmt.Println("🏷️  Flags:")
+		fmt.Fprintln(w, "🏷️  Flags:")
 		for _, v := range c.Flags {
-			fmt.Println("      " + v)
+			fmt.Fprintln(w, "      "+v)
 		}
 	}
 	if len(c.Subcommands) > 0 {
-		fmt.Println("🧩  Subcommands:")
+		fmt.Fprintln(w, "🧩  Subcommands:")
 		for _, v := range c.Subcommands {
-			fmt.Println("      " + v)
+			fmt.Fprintln(w, "      "+v)
 		}
 	}
 	if len(c.Args) > 0 {
-		fmt.Println("📥  Args:")
+		fmt.Fprintln(w, "📥  Args:")
 		for _, v := range c.Args {
-			fmt.Println("      " + v)
+			fmt.Fprintln(w, "      "+v)
 		}
 	}
+	d.printSchema(w, name)
 	if len(c.Returns) > 0 {
-		fmt.Println("📤  Returns:")
+		fmt.Fprintln(w, "📤  Returns:")
 		for _, v := range c.Returns {
-			fmt.Println("      " + v)
+			fmt.Fprintln(w, "      "+v)
 		}
 	}
 }
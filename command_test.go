@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDescLoadNames(t *testing.T) {
+	homeDir = t.TempDir()
+	path := filepath.Join(homeDir, "desc.toml")
+	write := func(src string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	registered := map[string]bool{"hello.sh": true}
+	d := NewDescManager()
+	d.isCommand = func(name string) bool { return registered[name] }
+
+	// 整体是已注册命令的带 . 路径视为一个命令名，不再与其他条目的末段冲突
+	write(`
+[sys.hello.sh]
+desc = "hello"
+[system.ignore.sh]
+desc = "ignore"
+[net.tools.ping]
+desc = "ping"
+`)
+	if err := d.Load(path); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	for name, want := range map[string]string{"hello.sh": "hello", "sh": "ignore", "ping": "ping"} {
+		if desc, ok := d.Get(name); !ok || desc.Desc != want {
+			t.Errorf("Get(%s) = %+v, %v, want desc %q", name, desc, ok, want)
+		}
+	}
+	if desc, _ := d.Get("ping"); strings.Join(desc.Group, ".") != "tools" {
+		t.Errorf("ping 的分组 = %v, want [tools]", desc.Group)
+	}
+
+	// 末段相同的条目都不采用，并报告冲突
+	delete(registered, "hello.sh")
+	err := d.Load(path)
+	if err == nil || !strings.Contains(err.Error(), "[sys.hello.sh], [system.ignore.sh]") {
+		t.Fatalf("Load: got %v, want duplicate error", err)
+	}
+	if _, ok := d.Get("sh"); ok {
+		t.Error("重复的命令名仍被加载")
+	}
+	if _, ok := d.Get("ping"); !ok {
+		t.Error("其他条目未加载")
+	}
+}
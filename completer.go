@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"strings"
+)
+
+// Completer 为 REPL 提供上下文相关的 Tab 补全
+// 命令名来自 Shell.commands，子命令与参数来自 CommandDesc.Subcommands/Flags。
+// 每次补全都实时读取 Shell 与 DescManager，因此 config.toml / desc.toml 热加载后立即生效。
+type Completer struct {
+	shell *Shell
+	desc  *DescManager
+}
+
+func NewCompleter(shell *Shell, desc *DescManager) *Completer {
+	return &Completer{shell: shell, desc: desc}
+}
+
+// Do 实现 readline.AutoCompleter
+func (c *Completer) Do(line []rune, pos int) ([][]rune, int) {
+	words := strings.Fields(string(line[:pos]))
+	current := ""
+	if pos > 0 && line[pos-1] != ' ' && len(words) > 0 {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+	// 只看最后一个 | && || ; 之后的那条命令
+	afterPipe := false
+	for i := len(words) - 1; i >= 0; i-- {
+		if isCommandSeparator(words[i]) {
+			afterPipe = words[i] == "|"
+			words = words[i+1:]
+			break
+		}
+	}
+
+	var candidates []string
+	switch {
+	case len(words) == 0 && afterPipe:
+		// | 之后可以是命令或输出过滤器
+		candidates = append(c.shell.Names(), filterNames...)
+		sort.Strings(candidates)
+	case len(words) == 0:
+		candidates = c.shell.Names()
+	case words[0] == "help" && len(words) > 1:
+		candidates = c.helpCandidates(words[1:])
+	default:
+		candidates = c.argCandidates(words[0], current)
+	}
+
+	var out [][]rune
+	for _, cand := range candidates {
+		if strings.HasPrefix(cand, current) {
+			out = append(out, []rune(cand[len(current):]+" "))
+		}
+	}
+	return out, len([]rune(current))
+}
+
+// helpCandidates help 分类之后逐层补全分组与命令
+func (c *Completer) helpCandidates(path []string) []string {
+	n, _, ok := c.desc.node(path)
+	if !ok {
+		return nil
+	}
+	keep := func(name string) bool { return c.shell.permitted(name, c.desc) }
+	var cands []string
+	for _, name := range n.childNames() {
+		if n.children[name].visible(keep) > 0 {
+			cands = append(cands, name)
+		}
+	}
+	for _, name := range n.commands {
+		if keep(name) {
+			cands = append(cands, name)
+		}
+	}
+	sort.Strings(cands)
+	return cands
+}
+
+// argCandidates 返回命令 name 之后可补全的子命令与参数
+func (c *Completer) argCandidates(name, current string) []string {
+	// help 后面补全命令名和分类名
+	if name == "help" {
+		cands := append(c.shell.Names(), c.desc.getAllCategories()...)
+		sort.Strings(cands)
+		return cands
+	}
+
+	var subcommands, flags []string
+	if d, ok := c.desc.Get(name); ok {
+		subcommands, flags = d.Subcommands, d.Flags
+	} else if cmd, ok := c.shell.Lookup(name); ok {
+		subcommands, flags = cmd.Subcommands(), cmd.Flags()
+	}
+
+	var cands []string
+	if strings.HasPrefix(current, "-") {
+		for _, f := range flags {
+			cands = append(cands, flagNames(f)...)
+		}
+	} else {
+		for _, s := range subcommands {
+			if fields := strings.Fields(s); len(fields) > 0 {
+				cands = append(cands, fields[0])
+			}
+		}
+	}
+	sort.Strings(cands)
+	return cands
+}
+
+func isCommandSeparator(word string) bool {
+	switch word {
+	case "|", "||", "&&", ";":
+		return true
+	}
+	return false
+}
+
+// flagNames 从 "-h, --help    # Prints help information" 中提取 ["-h", "--help"]
+func flagNames(spec string) []string {
+	if i := strings.Index(spec, "#"); i >= 0 {
+		spec = spec[:i]
+	}
+	var names []string
+	for _, f := range strings.FieldsFunc(spec, func(r rune) bool { return r == ',' || r == ' ' || r == '\t' }) {
+		if !strings.HasPrefix(f, "-") {
+			continue
+		}
+		if i := strings.IndexAny(f, "=<["); i > 0 {
+			f = f[:i]
+		}
+		names = append(names, f)
+	}
+	return names
+}
@@ -0,0 +1,447 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// systemConfigDir 系统级配置目录，优先级最低
+const systemConfigDir = "/etc/flyos"
+
+// systemDescPath 授权使用的命令分类（见 DescManager.LoadTrusted）
+var systemDescPath = filepath.Join(systemConfigDir, "desc.toml")
+
+// 合并规则：
+//   - commands_dirs、excludes 按层追加，重复项只保留第一次出现
+//   - aliases、functions 中的每一项整体覆盖
+//   - 其余表（env、history 等）逐键合并，后加载的值覆盖先加载的值
+//   - rbac、audit 只从系统层读取（见 isTrustedLayer），用户可写的配置层不能放宽授权或关闭审计，其中的设置被忽略
+var (
+	appendKeys     = map[string]bool{"commands_dirs": true, "excludes": true}
+	wholeKeys      = map[string]bool{"aliases": true, "functions": true}
+	systemOnlyKeys = map[string]bool{"rbac": true, "audit": true}
+)
+
+// configLayer 一个配置文件
+type configLayer struct {
+	Path    string   `json:"path"`
+	Loaded  bool     `json:"loaded"`            // false 表示文件不存在，已跳过
+	Ignored []string `json:"ignored,omitempty"` // 因只能由系统层设置而被忽略的键
+}
+
+// LayeredConfig 按层合并后的配置
+type LayeredConfig struct {
+	Config *Config
+	Layers []configLayer
+
+	values map[string]interface{} // 合并后的原始表
+	origin map[string]string      // 扁平键 -> 设置该值的文件
+}
+
+// configDirs 按优先级从低到高：/etc/flyos、~/.flyos、$FLYOS_HOME/.flyos
+func configDirs() []string {
+	dirs := []string{systemConfigDir}
+	if home, err := os.UserHomeDir(); err == nil {
+		dirs = append(dirs, filepath.Join(home, ".flyos"))
+	}
+	dirs = append(dirs, filepath.Join(homeDir, ".flyos"))
+
+	seen := make(map[string]bool)
+	result := dirs[:0]
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if !seen[dir] {
+			seen[dir] = true
+			result = append(result, dir)
+		}
+	}
+	return result
+}
+
+// configPaths 各目录的 config.toml，之后是各目录 conf.d 下按文件名排序的 *.toml
+func configPaths() []string {
+	dirs := configDirs()
+	paths := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		paths = append(paths, filepath.Join(dir, "config.toml"))
+	}
+	for _, dir := range dirs {
+		dropIns, _ := filepath.Glob(filepath.Join(dir, "conf.d", "*.toml"))
+		sort.Strings(dropIns)
+		paths = append(paths, dropIns...)
+	}
+	return paths
+}
+
+// isConfigFile 判断文件是否属于配置层，用于热加载
+func isConfigFile(path string) bool {
+	for _, dir := range configDirs() {
+		if path == filepath.Join(dir, "config.toml") {
+			return true
+		}
+		if filepath.Dir(path) == filepath.Join(dir, "conf.d") && filepath.Ext(path) == ".toml" {
+			return true
+		}
+	}
+	return false
+}
+
+// loadConfig 依次合并全部配置层，不存在的层跳过，解析失败时返回错误
+func loadConfig() (*LayeredConfig, error) {
+	l := &LayeredConfig{
+		values: make(map[string]interface{}),
+		origin: make(map[string]string),
+	}
+	for _, path := range configPaths() {
+		data, err := os.ReadFile(path)
+		if os.IsNotExist(err) {
+			l.Layers = append(l.Layers, configLayer{Path: path})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		var layer map[string]interface{}
+		if err := toml.Unmarshal(data, &layer); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		var ignored []string
+		if !isTrustedLayer(path) {
+			for k, v := range layer {
+				if systemOnlyKeys[k] {
+					ignored = append(ignored, flatKeys(k, v)...)
+					delete(layer, k)
+				}
+			}
+			sort.Strings(ignored)
+		}
+		l.merge(l.values, layer, "", path)
+		l.Layers = append(l.Layers, configLayer{Path: path, Loaded: true, Ignored: ignored})
+	}
+
+	data, err := toml.Marshal(l.values)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := toml.Unmarshal(data, &cfg); err != nil {
+		return nil, err
+	}
+	l.Config = &cfg
+	return l, nil
+}
+
+// isTrustedLayer 配置文件属于 /etc/flyos，且由 root 所有、组与其他用户不可写，
+// 只有这样的文件可以设置 rbac 与 audit
+func isTrustedLayer(path string) bool {
+	if !strings.HasPrefix(filepath.Clean(path), systemConfigDir+string(filepath.Separator)) {
+		return false
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && st.Uid == 0 && fi.Mode().Perm()&0022 == 0
+}
+
+// flatKeys 表展开后的全部扁平键
+func flatKeys(key string, v interface{}) []string {
+	table, ok := v.(map[string]interface{})
+	if !ok || len(table) == 0 {
+		return []string{key}
+	}
+	var keys []string
+	for k, sub := range table {
+		keys = append(keys, flatKeys(joinKey(key, k), sub)...)
+	}
+	return keys
+}
+
+func (l *LayeredConfig) merge(dst, src map[string]interface{}, prefix, file string) {
+	for k, v := range src {
+		key := joinKey(prefix, k)
+		if table, ok := v.(map[string]interface{}); ok && !wholeKeys[prefix] {
+			sub, ok := dst[k].(map[string]interface{})
+			if !ok {
+				l.clearOrigin(key)
+				sub = make(map[string]interface{})
+				dst[k] = sub
+			}
+			l.merge(sub, table, key, file)
+			continue
+		}
+		if list, ok := v.([]interface{}); ok && appendKeys[key] {
+			existing, _ := dst[k].([]interface{})
+			for _, item := range list {
+				if containsValue(existing, item) {
+					continue
+				}
+				l.origin[fmt.Sprintf("%s[%d]", key, len(existing))] = file
+				existing = append(existing, item)
+			}
+			dst[k] = existing
+			continue
+		}
+		l.clearOrigin(key)
+		dst[k] = v
+		l.origin[key] = file
+	}
+}
+
+// clearOrigin 值被整体替换时，删除原值及其子键的来源
+func (l *LayeredConfig) clearOrigin(key string) {
+	for k := range l.origin {
+		if k == key || strings.HasPrefix(k, key+".") || strings.HasPrefix(k, key+"[") {
+			delete(l.origin, k)
+		}
+	}
+}
+
+func joinKey(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+func containsValue(list []interface{}, v interface{}) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+// configValue 扁平化后的一项配置
+type configValue struct {
+	Key    string      `json:"key"`
+	Value  interface{} `json:"value"`
+	Origin string      `json:"origin"`
+}
+
+// flatten 按键排序展开为 key = value；追加型数组逐项展开，以便标注每一项的来源
+func (l *LayeredConfig) flatten() []configValue {
+	var result []configValue
+	var walk func(table map[string]interface{}, prefix string)
+	walk = func(table map[string]interface{}, prefix string) {
+		keys := make([]string, 0, len(table))
+		for k := range table {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			key := joinKey(prefix, k)
+			switch v := table[k].(type) {
+			case map[string]interface{}:
+				if !wholeKeys[prefix] {
+					walk(v, key)
+					continue
+				}
+			case []interface{}:
+				if appendKeys[key] {
+					for i, item := range v {
+						itemKey := fmt.Sprintf("%s[%d]", key, i)
+						result = append(result, configValue{Key: itemKey, Value: item, Origin: l.origin[itemKey]})
+					}
+					continue
+				}
+			}
+			result = append(result, configValue{Key: key, Value: table[k], Origin: l.origin[key]})
+		}
+	}
+	walk(l.values, "")
+	return result
+}
+
+// formatValue 以 TOML 行内写法输出值
+func formatValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return strconv.Quote(val)
+	case []interface{}:
+		items := make([]string, 0, len(val))
+		for _, item := range val {
+			items = append(items, formatValue(item))
+		}
+		return "[" + strings.Join(items, ", ") + "]"
+	case map[string]interface{}:
+		keys := make([]string, 0, len(val))
+		for k := range val {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		items := make([]string, 0, len(keys))
+		for _, k := range keys {
+			items = append(items, k+" = "+formatValue(val[k]))
+		}
+		return "{ " + strings.Join(items, ", ") + " }"
+	case time.Time:
+		return val.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v)
+}
+
+// configOutput config show 的 JSON 输出
+type configOutput struct {
+	Layers []configLayer `json:"layers"`
+	Values []configValue `json:"values,omitempty"`
+}
+
+// Builtin Config
+type ConfigCommand struct {
+	mu      sync.RWMutex
+	shell   *Shell
+	layered *LayeredConfig
+}
+
+func NewConfigCommand(s *Shell, l *LayeredConfig) *ConfigCommand {
+	return &ConfigCommand{shell: s, layered: l}
+}
+
+// Set 配置热加载后更新
+func (c *ConfigCommand) Set(l *LayeredConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.layered = l
+}
+
+func (c *ConfigCommand) Name() string     { return "config" }
+func (c *ConfigCommand) Category() string { return "sys" }
+func (c *ConfigCommand) Path() string     { return "" }
+func (c *ConfigCommand) IsBuiltin() bool  { return true }
+func (c *ConfigCommand) Desc() string     { return "查看分层合并后的配置" }
+func (c *ConfigCommand) Usage() string    { return "config show [--effective] [--origin]" }
+func (c *ConfigCommand) Args() []string {
+	return []string{
+		"按 /etc/flyos、~/.flyos、$FLYOS_HOME/.flyos 的 config.toml，再按各目录 conf.d/*.toml 的顺序合并",
+		"[rbac] 与 [audit] 只从 /etc/flyos 中 root 所有、他人不可写的文件读取，其他层中的设置被忽略并标记为 ⛔",
+	}
+}
+func (c *ConfigCommand) Returns() []string {
+	return []string{"不带选项时列出各配置文件及是否已加载"}
+}
+func (c *ConfigCommand) Flags() []string {
+	return []string{
+		"--effective    # 输出合并后的配置",
+		"--origin       # 标注每一项来自哪个文件",
+	}
+}
+func (c *ConfigCommand) Subcommands() []string {
+	return []string{"show    # 查看配置"}
+}
+func (c *ConfigCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) < 2 || args[1] != "show" {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	var effective, origin bool
+	for _, arg := range args[2:] {
+		switch arg {
+		case "--effective":
+			effective = true
+		case "--origin":
+			origin = true
+		default:
+			return fmt.Errorf("未知选项: %s", arg)
+		}
+	}
+	c.mu.RLock()
+	l := c.layered
+	c.mu.RUnlock()
+	return writeConfig(stdio.Out, c.shell.OutputFormat(), l, effective, origin)
+}
+
+func writeConfig(w io.Writer, format OutputFormat, l *LayeredConfig, effective, origin bool) error {
+	var values []configValue
+	if effective {
+		values = l.flatten()
+		if !origin {
+			for i := range values {
+				values[i].Origin = ""
+			}
+		}
+	}
+	switch format {
+	case OutputJSON:
+		out := configOutput{Layers: l.Layers, Values: values}
+		if effective && values == nil {
+			out.Values = []configValue{}
+		}
+		return writeJSON(w, out)
+	case OutputTable:
+		if !effective {
+			rows := make([][]string, 0, len(l.Layers))
+			for _, layer := range l.Layers {
+				rows = append(rows, []string{layer.Path, strconv.FormatBool(layer.Loaded), strings.Join(layer.Ignored, ", ")})
+			}
+			return writeTable(w, []string{"PATH", "LOADED", "IGNORED"}, rows)
+		}
+		header := []string{"KEY", "VALUE"}
+		if origin {
+			header = append(header, "ORIGIN")
+		}
+		rows := make([][]string, 0, len(values))
+		for _, v := range values {
+			row := []string{v.Key, formatValue(v.Value)}
+			if origin {
+				row = append(row, v.Origin)
+			}
+			rows = append(rows, row)
+		}
+		return writeTable(w, header, rows)
+	}
+
+	if !effective {
+		fmt.Fprintln(w, "📄 配置文件（按加载顺序，后者覆盖前者）:")
+		for _, layer := range l.Layers {
+			mark := "✅"
+			if !layer.Loaded {
+				mark = "➖"
+			}
+			fmt.Fprintf(w, "  %s %s\n", mark, layer.Path)
+			if len(layer.Ignored) > 0 {
+				fmt.Fprintf(w, "     ⛔ 已忽略: %s\n", strings.Join(layer.Ignored, ", "))
+			}
+		}
+		writeIgnoredHint(w, l)
+		return nil
+	}
+	if len(values) == 0 {
+		fmt.Fprintln(w, "⚠️ 未加载任何配置")
+	}
+	for _, v := range values {
+		if origin {
+			fmt.Fprintf(w, "%s = %s    # %s\n", v.Key, formatValue(v.Value), v.Origin)
+		} else {
+			fmt.Fprintf(w, "%s = %s\n", v.Key, formatValue(v.Value))
+		}
+	}
+	for _, layer := range l.Layers {
+		for _, key := range layer.Ignored {
+			fmt.Fprintf(w, "⛔ %s    # 已忽略: %s\n", key, layer.Path)
+		}
+	}
+	writeIgnoredHint(w, l)
+	return nil
+}
+
+// writeIgnoredHint 有被忽略的设置时说明原因
+func writeIgnoredHint(w io.Writer, l *LayeredConfig) {
+	for _, layer := range l.Layers {
+		if len(layer.Ignored) > 0 {
+			fmt.Fprintf(w, "💡 [rbac] 与 [audit] 只从 %s 中 root 所有、他人不可写的文件读取，其他配置层中的设置不生效\n", systemConfigDir)
+			return
+		}
+	}
+}
@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"flyos/pkg/dsl"
+)
+
+// checkTree commit 前检查候选配置：每个 kind 都有执行器、当前角色可以修改有差异的 kind、
+// 配置可以完整地写成 DSL 再读回
+func (s *Shell) checkTree(t dslTree) []string {
+	var problems []string
+	seen := make(map[string]bool)
+	for _, k := range t.keys() {
+		if !seen[k.Kind] && !dsl.Registered(k.Kind) {
+			problems = append(problems, fmt.Sprintf("%s: 没有注册执行器", k.Kind))
+		}
+		seen[k.Kind] = true
+	}
+	denied := make(map[string]bool)
+	for _, c := range diffTrees(s.configDB.Running(), t) {
+		if !denied[c.Key.Kind] && !s.auth.Allowed(c.Key.Kind, dslCategory) {
+			denied[c.Key.Kind] = true
+			problems = append(problems, (&deniedError{name: c.Key.Kind, roles: s.auth.Roles()}).Error())
+		}
+	}
+	back, err := parseTree(t.String())
+	if err != nil {
+		problems = append(problems, fmt.Sprintf("配置无法保存为 DSL: %v", err))
+	} else if changes := diffTrees(t, back); len(changes) > 0 {
+		problems = append(problems, fmt.Sprintf("%s: 属性值无法保存为 DSL", changes[0].Key))
+	}
+	return problems
+}
+
+// treeObject show 与 DSL 查询输出中的一个对象
+type treeObject struct {
+	Kind  string                 `json:"kind"`
+	Name  string                 `json:"name"`
+	Attrs map[string]interface{} `json:"attrs"`
+}
+
+func writeTree(s *Shell, stdio *Stdio, t dslTree) error {
+	objects := make([]treeObject, 0, len(t))
+	for _, k := range t.keys() {
+		objects = append(objects, treeObject{Kind: k.Kind, Name: k.Subtype, Attrs: t[k]})
+	}
+	return writeObjects(stdio.Out, s.OutputFormat(), objects)
+}
+
+// writeObjects 按输出格式写出对象，text 为可被读回的 DSL
+func writeObjects(w io.Writer, format OutputFormat, objects []treeObject) error {
+	switch format {
+	case OutputJSON:
+		return writeJSON(w, objects)
+	case OutputTable:
+		rows := make([][]string, 0, len(objects))
+		for _, o := range objects {
+			var attrs []string
+			for _, name := range sortedAttrs(o.Attrs) {
+				attrs = append(attrs, name+" "+dslValue(o.Attrs[name]))
+			}
+			rows = append(rows, []string{o.Kind, o.Name, strings.Join(attrs, "; ")})
+		}
+		return writeTable(w, []string{"KIND", "NAME", "ATTRS"}, rows)
+	}
+	for _, o := range objects {
+		for _, line := range objectLines(o.Kind, o.Name, o.Attrs) {
+			fmt.Fprintln(w, line)
+		}
+	}
+	return nil
+}
+
+// errNotEditing commit、discard、rollback N 只能在 configure 模式中使用
+var errNotEditing = fmt.Errorf("请先执行 configure 进入配置模式")
+
+// Builtin Configure
+type ConfigureCommand struct {
+	shell *Shell
+}
+
+func NewConfigureCommand(s *Shell) *ConfigureCommand {
+	return &ConfigureCommand{shell: s}
+}
+
+func (c *ConfigureCommand) Name() string     { return "configure" }
+func (c *ConfigureCommand) Category() string { return "sys" }
+func (c *ConfigureCommand) Path() string     { return "" }
+func (c *ConfigureCommand) IsBuiltin() bool  { return true }
+func (c *ConfigureCommand) Desc() string {
+	return "进入配置模式，DSL 语句暂存到候选配置"
+}
+func (c *ConfigureCommand) Usage() string { return "configure" }
+func (c *ConfigureCommand) Args() []string {
+	return []string{
+		"配置模式中 route add ... 等 DSL 语句只修改候选配置，commit 后才生效",
+		"show | compare 查看与运行配置的差异，exit 退出配置模式",
+	}
+}
+func (c *ConfigureCommand) Returns() []string {
+	return []string{"提示符变为 flyos(config)#，有未提交的更改时为 flyos(config*)#"}
+}
+func (c *ConfigureCommand) Flags() []string       { return nil }
+func (c *ConfigureCommand) Subcommands() []string { return nil }
+func (c *ConfigureCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) > 1 {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	if c.shell.configDB.Editing() {
+		fmt.Fprintln(stdio.Out, "⚠️ 已在配置模式中")
+		return nil
+	}
+	dirty := c.shell.configDB.Enter()
+	fmt.Fprintln(stdio.Out, "✅ 进入配置模式")
+	if dirty {
+		fmt.Fprintln(stdio.Out, "⚠️ 候选配置有上次未提交的更改，show | compare 查看，discard 丢弃")
+	}
+	fmt.Fprintln(stdio.Out, "💡 DSL 语句暂存到候选配置，commit 提交，exit 退出配置模式")
+	return nil
+}
+
+// Builtin Show
+type ShowCommand struct {
+	shell *Shell
+}
+
+func NewShowCommand(s *Shell) *ShowCommand {
+	return &ShowCommand{shell: s}
+}
+
+func (c *ShowCommand) Name() string     { return "show" }
+func (c *ShowCommand) Category() string { return "sys" }
+func (c *ShowCommand) Path() string     { return "" }
+func (c *ShowCommand) IsBuiltin() bool  { return true }
+func (c *ShowCommand) Desc() string     { return "显示候选配置或运行配置" }
+func (c *ShowCommand) Usage() string    { return "show [| compare [rollback N]]" }
+func (c *ShowCommand) Args() []string {
+	return []string{
+		"配置模式中显示候选配置，否则显示运行配置",
+		"| compare 显示与运行配置的差异，| compare rollback N 与第 N 次之前的提交比较",
+	}
+}
+func (c *ShowCommand) Returns() []string     { return []string{"DSL 格式的完整配置"} }
+func (c *ShowCommand) Flags() []string       { return nil }
+func (c *ShowCommand) Subcommands() []string { return nil }
+func (c *ShowCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) > 1 {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	return writeTree(c.shell, stdio, c.shell.configDB.Candidate())
+}
+
+// Builtin Commit
+type CommitCommand struct {
+	shell *Shell
+}
+
+func NewCommitCommand(s *Shell) *CommitCommand {
+	return &CommitCommand{shell: s}
+}
+
+func (c *CommitCommand) Name() string     { return "commit" }
+func (c *CommitCommand) Category() string { return "sys" }
+func (c *CommitCommand) Path() string     { return "" }
+func (c *CommitCommand) IsBuiltin() bool  { return true }
+func (c *CommitCommand) Desc() string     { return "检查并提交候选配置" }
+func (c *CommitCommand) Usage() string    { return "commit [check | confirmed [MINUTES]]" }
+func (c *CommitCommand) Args() []string {
+	return []string{
+		"与运行配置的差异按 delete、add/set 交给 DSL 执行器，成功后保存为一次提交",
+		fmt.Sprintf("MINUTES commit confirmed 的确认期限，默认 %d 分钟", defaultConfirmMinutes),
+	}
+}
+func (c *CommitCommand) Returns() []string {
+	return []string{"检查失败时退出码为 1，不做任何修改"}
+}
+func (c *CommitCommand) Flags() []string { return nil }
+func (c *CommitCommand) Subcommands() []string {
+	return []string{
+		"check        # 只检查候选配置，不提交",
+		"confirmed    # 提交后开始计时，期限内没有再次 commit 则自动回滚，会话结束后仍按期限回滚",
+	}
+}
+func (c *CommitCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	check := len(args) == 2 && args[1] == "check"
+	confirmed := len(args) >= 2 && args[1] == "confirmed"
+	minutes := defaultConfirmMinutes
+	switch {
+	case len(args) == 1 || check:
+	case confirmed && len(args) == 2:
+	case confirmed && len(args) == 3:
+		n, err := strconv.Atoi(args[2])
+		if err != nil || n < 1 || n > maxConfirmMinutes {
+			return fmt.Errorf("非法分钟数: %s（1-%d）", args[2], maxConfirmMinutes)
+		}
+		minutes = n
+	default:
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	db := c.shell.configDB
+	if !db.Editing() {
+		return errNotEditing
+	}
+	db.applyMu.Lock()
+	defer db.applyMu.Unlock()
+	// 运行配置可能已被其他会话提交更改，检查与应用前重新读取
+	db.reload()
+	candidate := db.Candidate()
+	if problems := c.shell.checkTree(candidate); len(problems) > 0 {
+		for _, p := range problems {
+			fmt.Fprintf(stdio.Err, "❌ %s\n", p)
+		}
+		fmt.Fprintln(stdio.Err, "⛔ 配置检查失败，未提交")
+		return &exitCodeError{code: 1}
+	}
+	if check {
+		fmt.Fprintln(stdio.Out, "✅ 配置检查通过")
+		return nil
+	}
+	before := db.Running()
+	info, n, err := c.shell.applyTree(candidate, c.shell.audit.user)
+	if err != nil {
+		fmt.Fprintf(stdio.Err, "💥 %v\n", err)
+		return &exitCodeError{code: 1}
+	}
+	if n > 0 {
+		fmt.Fprintf(stdio.Out, "✅ 提交完成（commit %d，%d 处更改）\n", info.Seq, n)
+	}
+
+	pending := c.shell.confirm.Pending()
+	if !confirmed {
+		// 普通 commit 同时确认等待中的 commit confirmed
+		if seq := c.shell.confirmCommit(); seq != 0 {
+			fmt.Fprintf(stdio.Out, "✅ 已确认 commit %d，取消自动回滚\n", seq)
+		} else if n == 0 {
+			fmt.Fprintln(stdio.Out, "✅ 没有需要提交的更改")
+		}
+		return nil
+	}
+	seq := info.Seq
+	if n == 0 {
+		if pending == nil {
+			fmt.Fprintln(stdio.Out, "✅ 没有需要提交的更改")
+			return nil
+		}
+		seq = pending.Commit
+	}
+	if err := c.shell.armConfirm(seq, time.Duration(minutes)*time.Minute, before); err != nil {
+		return err
+	}
+	st := c.shell.confirm.Pending()
+	fmt.Fprintf(stdio.Out, "⏳ %s 前再次 commit 确认，否则自动回滚到 commit confirmed 之前的配置\n", st.Deadline.Format("15:04:05"))
+	return nil
+}
+
+// Builtin Discard
+type DiscardCommand struct {
+	shell *Shell
+}
+
+func NewDiscardCommand(s *Shell) *DiscardCommand {
+	return &DiscardCommand{shell: s}
+}
+
+func (c *DiscardCommand) Name() string          { return "discard" }
+func (c *DiscardCommand) Category() string      { return "sys" }
+func (c *DiscardCommand) Path() string          { return "" }
+func (c *DiscardCommand) IsBuiltin() bool       { return true }
+func (c *DiscardCommand) Desc() string          { return "丢弃候选配置中未提交的更改" }
+func (c *DiscardCommand) Usage() string         { return "discard" }
+func (c *DiscardCommand) Args() []string        { return nil }
+func (c *DiscardCommand) Returns() []string     { return []string{"候选配置恢复为运行配置"} }
+func (c *DiscardCommand) Flags() []string       { return nil }
+func (c *DiscardCommand) Subcommands() []string { return nil }
+func (c *DiscardCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) > 1 {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	if !c.shell.configDB.Editing() {
+		return errNotEditing
+	}
+	c.shell.configDB.Discard()
+	fmt.Fprintln(stdio.Out, "🔄 已丢弃未提交的更改")
+	return nil
+}
+
+// Builtin Rollback
+type RollbackCommand struct {
+	shell *Shell
+}
+
+func NewRollbackCommand(s *Shell) *RollbackCommand {
+	return &RollbackCommand{shell: s}
+}
+
+func (c *RollbackCommand) Name() string     { return "rollback" }
+func (c *RollbackCommand) Category() string { return "sys" }
+func (c *RollbackCommand) Path() string     { return "" }
+func (c *RollbackCommand) IsBuiltin() bool  { return true }
+func (c *RollbackCommand) Desc() string     { return "列出提交历史或载入之前的提交" }
+func (c *RollbackCommand) Usage() string    { return "rollback [N]" }
+func (c *RollbackCommand) Args() []string {
+	return []string{
+		"不带参数时列出保存的提交，0 为当前运行配置",
+		fmt.Sprintf("N 把第 N 次之前的提交载入候选配置，commit 后生效；最多保留 %d 次", maxRollbacks),
+	}
+}
+func (c *RollbackCommand) Returns() []string     { return []string{"提交列表或载入结果"} }
+func (c *RollbackCommand) Flags() []string       { return nil }
+func (c *RollbackCommand) Subcommands() []string { return nil }
+func (c *RollbackCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	db := c.shell.configDB
+	switch len(args) {
+	case 1:
+		commits, err := db.Commits()
+		if err != nil {
+			return err
+		}
+		return writeCommits(stdio, c.shell.OutputFormat(), commits)
+	case 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n < 0 {
+			return fmt.Errorf("非法编号: %s", args[1])
+		}
+		if !db.Editing() {
+			return errNotEditing
+		}
+		if err := db.Load(n); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdio.Out, "🔄 已载入 rollback %d，show | compare 查看差异，commit 生效\n", n)
+		return nil
+	}
+	return fmt.Errorf("用法: %s", c.Usage())
+}
+
+func writeCommits(stdio *Stdio, format OutputFormat, commits []commitInfo) error {
+	switch format {
+	case OutputJSON:
+		if commits == nil {
+			commits = []commitInfo{}
+		}
+		return writeJSON(stdio.Out, commits)
+	case OutputTable:
+		rows := make([][]string, 0, len(commits))
+		for _, ci := range commits {
+			rows = append(rows, []string{strconv.Itoa(ci.Rollback), strconv.Itoa(ci.Seq), ci.User, ci.Time.Format(time.RFC3339)})
+		}
+		return writeTable(stdio.Out, []string{"ROLLBACK", "COMMIT", "USER", "TIME"}, rows)
+	}
+	if len(commits) == 0 {
+		fmt.Fprintln(stdio.Out, "<无提交>")
+		return nil
+	}
+	for _, ci := range commits {
+		fmt.Fprintf(stdio.Out, "%3d  %s  %-10s commit %d\n", ci.Rollback, ci.Time.Format("2006-01-02 15:04:05"), ci.User, ci.Seq)
+	}
+	return nil
+}
+
+// compareFilter show | compare [rollback N]：丢弃 show 的输出，换成候选配置（配置模式外为运行配置）与比较对象的差异
+func (s *Shell) compareFilter(args []string) (lineFilter, error) {
+	n := 0
+	if len(args) > 0 {
+		var err error
+		if len(args) != 2 || args[0] != "rollback" {
+			return nil, fmt.Errorf("用法: | %s", filterUsage["compare"])
+		}
+		if n, err = strconv.Atoi(args[1]); err != nil || n < 0 {
+			return nil, fmt.Errorf("compare: 非法编号: %s", args[1])
+		}
+	}
+	base, err := s.configDB.Rollback(n)
+	if err != nil {
+		return nil, fmt.Errorf("compare: %v", err)
+	}
+	return func([]string) ([]string, error) {
+		return compareLines(diffTrees(base, s.configDB.Candidate())), nil
+	}, nil
+}
+
+// configPrompt 配置模式中的提示符，有未提交的更改时带 *
+func (s *Shell) configPrompt() string {
+	switch {
+	case !s.configDB.Editing():
+		return "flyos> "
+	case s.configDB.Dirty():
+		return "flyos(config*)# "
+	}
+	return "flyos(config)# "
+}
+
+// leaveConfigure configure 模式中的 exit 只退出配置模式
+func (s *Shell) leaveConfigure(stdio *Stdio) bool {
+	if !s.configDB.Leave() {
+		return false
+	}
+	if s.configDB.Dirty() {
+		fmt.Fprintln(stdio.Err, "⚠️ 候选配置有未提交的更改，再次 configure 可继续编辑")
+	}
+	fmt.Fprintln(stdio.Out, "👋 退出配置模式")
+	return true
+}
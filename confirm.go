@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"flyos/pkg/dsl"
+	"flyos/pkg/module"
+)
+
+// commit confirmed N：提交立即生效，N 分钟内没有再次 commit 时把运行配置恢复为提交前的状态。
+// 等待确认的状态保存在 configDBDir/confirm.json，读写时持有 confirm.lock 上的 flock，
+// 多个 flyos 会话与看门狗进程之间互斥。发起的会话到期时回滚；会话结束或断开后由
+// 后台的看门狗进程（flyos --confirm-watchdog）在期限后回滚，期限内其他会话仍可确认
+
+// runtime EventBus 上发布的事件
+const (
+	eventConfirmArmed     = "commit.confirm.armed"
+	eventConfirmConfirmed = "commit.confirm.confirmed"
+	eventConfirmRollback  = "commit.confirm.rollback"
+)
+
+const (
+	defaultConfirmMinutes = 10
+	maxConfirmMinutes     = 65535
+
+	// watchdogGrace 看门狗在期限之后再等待的时间，会话仍在时由会话自己回滚并提示
+	watchdogGrace = 10 * time.Second
+	// watchdogPoll 看门狗检查状态的最长间隔，确认后尽快退出
+	watchdogPoll = 5 * time.Second
+)
+
+// confirmState 等待确认的提交
+type confirmState struct {
+	Commit   int       `json:"commit"`   // 最近一次 commit confirmed 的提交号
+	Minutes  int       `json:"minutes"`  // 超时分钟数
+	Deadline time.Time `json:"deadline"` // 到期时间
+	Restore  string    `json:"restore"`  // 第一次 commit confirmed 之前的运行配置
+	Session  string    `json:"session"`  // 发起的会话，即审计日志中的 session
+}
+
+// ConfirmTimer 自动回滚计时器，同一时间最多一个等待确认的提交；状态以文件为准，
+// 本进程只保存到期时触发回滚检查的定时器
+type ConfirmTimer struct {
+	mu    sync.Mutex
+	path  string
+	timer *time.Timer
+}
+
+func NewConfirmTimer(path string) *ConfirmTimer {
+	return &ConfirmTimer{path: path}
+}
+
+// withLock 持有 confirm.lock 上的排他 flock 执行 fn
+func (c *ConfirmTimer) withLock(fn func() error) error {
+	if err := makeSharedDir(filepath.Dir(c.path)); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(filepath.Dir(c.path), "confirm.lock"), os.O_CREATE|os.O_RDWR, sharedFileMode)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	shareFile(f.Name())
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return fn()
+}
+
+// Pending 等待确认的提交，没有时返回 nil
+func (c *ConfirmTimer) Pending() *confirmState {
+	var st *confirmState
+	_ = c.withLock(func() error {
+		st = c.load()
+		return nil
+	})
+	return st
+}
+
+// load 读取状态文件，调用方持有锁
+func (c *ConfirmTimer) load() *confirmState {
+	data, err := os.ReadFile(c.path)
+	if err != nil {
+		return nil
+	}
+	var st confirmState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil
+	}
+	return &st
+}
+
+// save 写入状态文件，调用方持有锁
+func (c *ConfirmTimer) save(st confirmState) error {
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.path, data, sharedFileMode); err != nil {
+		return err
+	}
+	shareFile(c.path)
+	return nil
+}
+
+// remove 删除状态文件，调用方持有锁
+func (c *ConfirmTimer) remove() {
+	os.Remove(c.path)
+}
+
+// schedule 在 deadline 调用 fire，已有定时器时重新计时
+func (c *ConfirmTimer) schedule(deadline time.Time, fire func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+	c.timer = time.AfterFunc(time.Until(deadline), fire)
+}
+
+// stop 停止本进程的定时器
+func (c *ConfirmTimer) stop() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+}
+
+// publish 在 runtime EventBus 上发布事件，并写入审计日志
+func (s *Shell) publish(typ string, data map[string]interface{}) {
+	if s.events != nil {
+		s.events.Publish(module.Event{Type: typ, Data: data})
+	}
+	argv := []string{"event", typ}
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := data[k]
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339)
+		}
+		argv = append(argv, fmt.Sprintf("%s=%v", k, v))
+	}
+	s.audit.Record(argv, "", 0, time.Now())
+}
+
+// applyTree 把与运行配置的差异交给执行器并保存为一次提交，返回提交与更改数
+func (s *Shell) applyTree(t dslTree, user string) (commitInfo, int, error) {
+	db := s.configDB
+	changes := diffTrees(db.Running(), t)
+	if len(changes) == 0 {
+		return commitInfo{}, 0, nil
+	}
+	if err := dsl.ExecuteAll(changeCommands(changes)); err != nil {
+		return commitInfo{}, 0, fmt.Errorf("应用配置失败，部分更改可能已生效: %v", err)
+	}
+	info, err := db.Commit(t, user)
+	if err != nil {
+		return commitInfo{}, 0, fmt.Errorf("保存提交失败: %v", err)
+	}
+	return info, len(changes), nil
+}
+
+// armConfirm commit confirmed 之后开始或重新计时；restore 只在第一次计时时记录
+func (s *Shell) armConfirm(commit int, d time.Duration, restore dslTree) error {
+	st := confirmState{
+		Commit:   commit,
+		Minutes:  int(d / time.Minute),
+		Deadline: time.Now().Add(d),
+		Restore:  restore.String(),
+		Session:  s.audit.session,
+	}
+	err := s.confirm.withLock(func() error {
+		if old := s.confirm.load(); old != nil {
+			st.Restore = old.Restore
+		}
+		return s.confirm.save(st)
+	})
+	if err != nil {
+		return fmt.Errorf("保存确认状态失败: %v", err)
+	}
+	s.confirm.schedule(st.Deadline, func() { s.rollbackUnconfirmed("超时") })
+	spawnWatchdog()
+	s.publish(eventConfirmArmed, map[string]interface{}{
+		"commit":   st.Commit,
+		"minutes":  st.Minutes,
+		"deadline": st.Deadline,
+		"user":     s.audit.user,
+	})
+	return nil
+}
+
+// confirmCommit 普通 commit 确认等待中的提交，返回被确认的提交号，没有时为 0
+func (s *Shell) confirmCommit() int {
+	var st *confirmState
+	_ = s.confirm.withLock(func() error {
+		st = s.confirm.load()
+		s.confirm.remove()
+		return nil
+	})
+	s.confirm.stop()
+	if st == nil {
+		return 0
+	}
+	s.publish(eventConfirmConfirmed, map[string]interface{}{
+		"commit": st.Commit,
+		"user":   s.audit.user,
+	})
+	return st.Commit
+}
+
+// rollbackUnconfirmed 通过执行器把运行配置恢复为 commit confirmed 之前的状态，并保存为一次新的提交；
+// 状态已被确认时什么也不做，期限被其他会话延后时重新计时
+func (s *Shell) rollbackUnconfirmed(reason string) {
+	s.configDB.applyMu.Lock()
+	defer s.configDB.applyMu.Unlock()
+	var st *confirmState
+	_ = s.confirm.withLock(func() error {
+		st = s.confirm.load()
+		if st == nil {
+			return nil
+		}
+		if time.Now().Before(st.Deadline) {
+			s.confirm.schedule(st.Deadline, func() { s.rollbackUnconfirmed("超时") })
+			st = nil
+			return nil
+		}
+		s.confirm.remove()
+		return nil
+	})
+	if st == nil {
+		return
+	}
+	s.confirm.stop()
+
+	restore, err := parseTree(st.Restore)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n💥 commit %d 自动回滚失败: 无法解析保存的配置: %v\n", st.Commit, err)
+		return
+	}
+	// 看门狗中的运行配置可能是启动时读取的，回滚前重新读取最新的提交
+	s.configDB.reload()
+	info, n, err := s.applyTree(restore, "rollback")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "\n💥 commit %d 自动回滚失败: %v\n", st.Commit, err)
+		return
+	}
+	if n > 0 {
+		fmt.Fprintf(os.Stderr, "\n🔄 commit %d 未确认（%s），已自动回滚（commit %d）\n", st.Commit, reason, info.Seq)
+	} else {
+		fmt.Fprintf(os.Stderr, "\n🔄 commit %d 未确认（%s），运行配置已与提交前相同\n", st.Commit, reason)
+	}
+	s.publish(eventConfirmRollback, map[string]interface{}{
+		"commit":   st.Commit,
+		"rollback": info.Seq,
+		"reason":   reason,
+	})
+}
+
+// resumeConfirm 启动时接着计时上次留下的等待确认的提交，已经到期的立即回滚
+func (s *Shell) resumeConfirm() {
+	st := s.confirm.Pending()
+	if st == nil {
+		return
+	}
+	if time.Now().After(st.Deadline) {
+		s.rollbackUnconfirmed("上次会话结束前未确认")
+		return
+	}
+	s.confirm.schedule(st.Deadline, func() { s.rollbackUnconfirmed("超时") })
+	spawnWatchdog()
+	fmt.Fprintf(os.Stderr, "⚠️ commit %d 等待确认，%s 前执行 configure 与 commit 确认，否则自动回滚\n", st.Commit, st.Deadline.Format("15:04:05"))
+}
+
+// endSession 会话结束时停止本进程的计时；未确认的提交不回滚，到期后由看门狗或下次启动的会话回滚
+func (s *Shell) endSession() {
+	s.confirm.stop()
+	if st := s.confirm.Pending(); st != nil && st.Session == s.audit.session {
+		fmt.Fprintf(os.Stderr, "⚠️ commit %d 仍等待确认，%s 前未确认将自动回滚\n", st.Commit, st.Deadline.Format("15:04:05"))
+	}
+}
+
+// spawnWatchdog 启动看门狗进程，测试中替换为空函数
+var spawnWatchdog = func() {
+	exe, err := os.Executable()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 启动回滚看门狗失败: %v\n", err)
+		return
+	}
+	cmd := exec.Command(exe, "--confirm-watchdog")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 启动回滚看门狗失败: %v\n", err)
+		return
+	}
+	go cmd.Wait()
+}
+
+// confirmWatchdog 看门狗：等到期限之后 watchdogGrace 仍未确认时回滚，没有等待确认的提交时退出；
+// 通过 confirm.watchdog 上的 flock 保证只有一个看门狗在运行
+func (s *Shell) confirmWatchdog() int {
+	dir := filepath.Dir(s.confirm.path)
+	if err := makeSharedDir(dir); err != nil {
+		return 1
+	}
+	f, err := os.OpenFile(filepath.Join(dir, "confirm.watchdog"), os.O_CREATE|os.O_RDWR, sharedFileMode)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+	shareFile(f.Name())
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		return 0
+	}
+	for {
+		st := s.confirm.Pending()
+		if st == nil {
+			return 0
+		}
+		wait := time.Until(st.Deadline.Add(watchdogGrace))
+		if wait <= 0 {
+			s.rollbackUnconfirmed("超时，发起的会话已结束")
+			continue
+		}
+		if wait > watchdogPoll {
+			wait = watchdogPoll
+		}
+		time.Sleep(wait)
+	}
+}
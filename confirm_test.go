@@ -0,0 +1,153 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"flyos/pkg/module"
+	"flyos/pkg/runtime/eventbus"
+)
+
+// newConfirmShell 在临时目录中创建带运行配置的 Shell，返回收到的事件类型
+func newConfirmShell(t *testing.T, running string) (*Shell, chan string) {
+	t.Helper()
+	homeDir = t.TempDir()
+	configDBDir = filepath.Join(homeDir, "commits")
+	spawnWatchdog = func() {}
+
+	s := NewShell(map[string]string{})
+	s.events = eventbus.New()
+	events := make(chan string, 16)
+	s.events.Subscribe("test", func(e module.Event) { events <- e.Type })
+
+	if running != "" {
+		tree, err := parseTree(running)
+		if err != nil {
+			t.Fatalf("parseTree: %v", err)
+		}
+		if _, err := s.configDB.Commit(tree, "test"); err != nil {
+			t.Fatalf("Commit: %v", err)
+		}
+		s.configDB.reload()
+	}
+	return s, events
+}
+
+// commitConfirmed 与 commit confirmed 相同：应用 src 并开始计时
+func commitConfirmed(t *testing.T, s *Shell, src string, d time.Duration) int {
+	t.Helper()
+	tree, err := parseTree(src)
+	if err != nil {
+		t.Fatalf("parseTree: %v", err)
+	}
+	before := s.configDB.Running()
+	info, _, err := s.applyTree(tree, "test")
+	if err != nil {
+		t.Fatalf("applyTree: %v", err)
+	}
+	if err := s.armConfirm(info.Seq, d, before); err != nil {
+		t.Fatalf("armConfirm: %v", err)
+	}
+	return info.Seq
+}
+
+func waitEvent(t *testing.T, events chan string, want string) {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		select {
+		case got := <-events:
+			if got == want {
+				return
+			}
+		case <-deadline:
+			t.Fatalf("未收到事件 %s", want)
+		}
+	}
+}
+
+func TestConfirmCommit(t *testing.T) {
+	s, events := newConfirmShell(t, "route add a { via 1 }")
+	seq := commitConfirmed(t, s, "route add a { via 2 }", time.Hour)
+	waitEvent(t, events, eventConfirmArmed)
+
+	st := s.confirm.Pending()
+	if st == nil || st.Commit != seq || st.Session != s.audit.session {
+		t.Fatalf("Pending = %+v, want commit %d", st, seq)
+	}
+	if got := s.confirmCommit(); got != seq {
+		t.Errorf("confirmCommit = %d, want %d", got, seq)
+	}
+	waitEvent(t, events, eventConfirmConfirmed)
+	if s.confirm.Pending() != nil {
+		t.Error("确认后仍有等待确认的提交")
+	}
+	if got := s.configDB.Running().String(); got != "route add a {\n    via 2;\n}\n" {
+		t.Errorf("确认后运行配置 = %q", got)
+	}
+}
+
+func TestConfirmExpire(t *testing.T) {
+	s, events := newConfirmShell(t, "route add a { via 1 }")
+	commitConfirmed(t, s, "route add a { via 2 }", 100*time.Millisecond)
+	// 再次 commit confirmed 重新计时，但回滚目标仍是第一次之前的配置
+	commitConfirmed(t, s, "route add a { via 3 }", 100*time.Millisecond)
+	waitEvent(t, events, eventConfirmRollback)
+
+	if s.confirm.Pending() != nil {
+		t.Error("回滚后仍有等待确认的提交")
+	}
+	if got := s.configDB.Running().String(); got != "route add a {\n    via 1;\n}\n" {
+		t.Errorf("回滚后运行配置 = %q", got)
+	}
+	commits, err := s.configDB.Commits()
+	if err != nil || len(commits) != 4 || commits[0].User != "rollback" {
+		t.Errorf("回滚应保存为新的提交: %+v, %v", commits, err)
+	}
+}
+
+func TestConfirmResumeAndSessionEnd(t *testing.T) {
+	s, events := newConfirmShell(t, "route add a { via 1 }")
+	commitConfirmed(t, s, "route add a { via 2 }", time.Hour)
+
+	// 其他会话结束时不回滚本会话发起的提交
+	other := NewShell(map[string]string{})
+	other.events = eventbus.New()
+	other.endSession()
+	if s.confirm.Pending() == nil {
+		t.Fatal("其他会话结束时回滚了提交")
+	}
+
+	// 已到期的状态在启动时立即回滚
+	err := s.confirm.withLock(func() error {
+		st := s.confirm.load()
+		st.Deadline = time.Now().Add(-time.Second)
+		return s.confirm.save(*st)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	other.resumeConfirm()
+	if other.confirm.Pending() != nil {
+		t.Error("resumeConfirm 没有回滚已到期的提交")
+	}
+	if got := other.configDB.Running().String(); got != "route add a {\n    via 1;\n}\n" {
+		t.Errorf("回滚后运行配置 = %q", got)
+	}
+
+	// 本会话结束时不回滚，提交继续等待确认，到期由看门狗回滚
+	seq := commitConfirmed(t, s, "route add a { via 4 }", time.Hour)
+	s.endSession()
+	if st := s.confirm.Pending(); st == nil || st.Commit != seq {
+		t.Fatalf("会话结束后等待确认的提交 = %+v, want commit %d", st, seq)
+	}
+	if got := s.configDB.Running().String(); got != "route add a {\n    via 4;\n}\n" {
+		t.Errorf("会话结束后运行配置 = %q", got)
+	}
+	for len(events) > 0 {
+		if e := <-events; e == eventConfirmRollback {
+			t.Error("会话结束时回滚了提交")
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// 自描述协议：commands_dirs 中的命令以 --flyos-describe 调用时，向标准输出打印一个与
+// CommandDesc 字段一致的 JSON 对象并以 0 退出，例如
+//
+//	{"desc": "查看接口状态", "usage": "ifstat [IFACE]", "args": ["IFACE  # 接口名"]}
+//
+// 为避免误执行不支持该协议的脚本，只调用文件内容中出现 --flyos-describe 的命令。
+// 命令可以随意声明自己，因此只采用说明性的字段（见 selfDescribedFields）；分类决定授权，
+// 执行限制（timeout、max_memory、run_as_user、nice 等）约束命令本身，都只能由 desc.toml 设置
+const (
+	describeArg     = "--flyos-describe"
+	describeTimeout = 3 * time.Second
+	describeWorkers = 8
+	describeScanMax = 4 << 20 // 只在文件前 4M 中查找协议标记
+)
+
+// describeEntry 缓存中的一条结果，文件的 mtime 与大小不变时直接复用
+type describeEntry struct {
+	ModTime time.Time    `json:"mod_time"`
+	Size    int64        `json:"size"`
+	Desc    *CommandDesc `json:"desc,omitempty"` // nil 表示不支持自描述
+}
+
+// describeCache 自描述结果的缓存，保存在 ~/.flyos/describe-cache.json
+type describeCache struct {
+	mu      sync.Mutex
+	path    string
+	loaded  bool
+	entries map[string]describeEntry // 命令路径 -> 结果
+}
+
+func (d *DescManager) describeCache() *describeCache {
+	if d.cache == nil {
+		d.cache = &describeCache{path: filepath.Join(homeDir, ".flyos", "describe-cache.json")}
+	}
+	return d.cache
+}
+
+// selfDescribe 获取 files 中各命令的自描述，结果替换上一次的自描述
+func (d *DescManager) selfDescribe(files map[string]*FileCommand) {
+	cache := d.describeCache()
+	cache.load()
+
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		sem  = make(chan struct{}, describeWorkers)
+		auto = make(map[string]CommandDesc)
+		seen = make(map[string]describeEntry)
+	)
+	for name, f := range files {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(name, path string) {
+			defer func() { <-sem; wg.Done() }()
+			entry, ok := cache.describe(path)
+			if !ok {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			seen[path] = entry
+			if entry.Desc != nil {
+				auto[name] = selfDescribedFields(*entry.Desc)
+			}
+		}(name, f.path)
+	}
+	wg.Wait()
+	cache.save(seen)
+
+	d.auto = sync.Map{}
+	for name, desc := range auto {
+		d.auto.Store(name, desc)
+	}
+	d.rebuildCategories()
+	if len(auto) > 0 {
+		logInfo("🔄 已加载 %d 个🧾自描述命令\n", len(auto))
+	}
+}
+
+// selfDescribed 命令是否提供了自描述
+func (d *DescManager) selfDescribed(name string) bool {
+	_, ok := d.auto.Load(name)
+	return ok
+}
+
+// clearSelfDescribed 关闭 self_describe 后丢弃已有的自描述
+func (d *DescManager) clearSelfDescribed() {
+	empty := true
+	d.auto.Range(func(_, _ any) bool {
+		empty = false
+		return false
+	})
+	if !empty {
+		d.auto = sync.Map{}
+		d.rebuildCategories()
+	}
+}
+
+func (c *describeCache) load() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loaded {
+		return
+	}
+	c.loaded = true
+	c.entries = make(map[string]describeEntry)
+	if data, err := os.ReadFile(c.path); err == nil {
+		_ = json.Unmarshal(data, &c.entries)
+	}
+}
+
+// save 只保留本次出现的命令，写入失败时仅在内存中缓存
+func (c *describeCache) save(entries map[string]describeEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries = entries
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return
+	}
+	_ = os.WriteFile(c.path, data, 0644)
+}
+
+// describe 返回命令的自描述结果，文件无法读取时 ok 为 false
+func (c *describeCache) describe(path string) (entry describeEntry, ok bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return describeEntry{}, false
+	}
+	c.mu.Lock()
+	cached, hit := c.entries[path]
+	c.mu.Unlock()
+	if hit && cached.ModTime.Equal(info.ModTime()) && cached.Size == info.Size() {
+		return cached, true
+	}
+
+	entry = describeEntry{ModTime: info.ModTime(), Size: info.Size()}
+	if !mentionsProtocol(path) {
+		return entry, true
+	}
+	desc, err := runDescribe(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %s %s 无效: %v\n", path, describeArg, err)
+		return entry, true
+	}
+	entry.Desc = desc
+	return entry, true
+}
+
+// mentionsProtocol 文件中是否出现协议参数
+func mentionsProtocol(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, describeScanMax))
+	if err != nil {
+		return false
+	}
+	return bytes.Contains(data, []byte(describeArg))
+}
+
+// runDescribe 调用命令并解析输出，desc 为空时视为无效
+func runDescribe(path string) (*CommandDesc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), describeTimeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, path, describeArg)
+	cmd.Env = baseEnv
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	var desc CommandDesc
+	if err := json.Unmarshal(stdout.Bytes(), &desc); err != nil {
+		return nil, err
+	}
+	if desc.Desc == "" {
+		return nil, fmt.Errorf("缺少 desc")
+	}
+	desc = selfDescribedFields(desc)
+	return &desc, nil
+}
+
+// selfDescribedFields 自描述中可以采用的字段，分类固定为 default，忽略分组与执行限制
+func selfDescribedFields(d CommandDesc) CommandDesc {
+	return CommandDesc{
+		Category:    "default",
+		Desc:        d.Desc,
+		Usage:       d.Usage,
+		Args:        d.Args,
+		Subcommands: d.Subcommands,
+		Flags:       d.Flags,
+		Returns:     d.Returns,
+		Schema:      d.Schema,
+	}
+}
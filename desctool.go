@@ -0,0 +1,530 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// descFields desc.toml 命令条目中允许的字段及其检查
+var descFields = map[string]func(v interface{}) error{
+	"desc":        checkString,
+	"usage":       checkString,
+	"args":        checkStrings,
+	"subcommands": checkStrings,
+	"flags":       checkStrings,
+	"returns":     checkStrings,
+	"timeout": func(v interface{}) error {
+		_, err := parseTimeout(v)
+		return err
+	},
+	"max_memory": func(v interface{}) error {
+		_, err := parseSize(v)
+		return err
+	},
+	"max_cpu_seconds": func(v interface{}) error {
+		if n, ok := v.(int64); !ok || n < 0 {
+			return fmt.Errorf("应为非负整数")
+		}
+		return nil
+	},
+	"run_as_user": checkString,
+	"schema":      checkSchema,
+	"nice": func(v interface{}) error {
+		if n, ok := v.(int64); !ok || n < -20 || n > 19 {
+			return fmt.Errorf("应为 -20 到 19 之间的整数")
+		}
+		return nil
+	},
+}
+
+func checkString(v interface{}) error {
+	if _, ok := v.(string); !ok {
+		return fmt.Errorf("应为字符串")
+	}
+	return nil
+}
+
+func checkStrings(v interface{}) error {
+	list, ok := v.([]interface{})
+	if !ok {
+		return fmt.Errorf("应为字符串数组")
+	}
+	for _, item := range list {
+		if _, ok := item.(string); !ok {
+			return fmt.Errorf("应为字符串数组")
+		}
+	}
+	return nil
+}
+
+// descIssue desc lint 发现的一个问题
+type descIssue struct {
+	Kind     string `json:"kind"`     // malformed / duplicate / dotted / orphan / undocumented
+	Severity string `json:"severity"` // error / warning
+	Name     string `json:"name"`     // desc.toml 中的键或命令名
+	Message  string `json:"message"`
+}
+
+var issueOrder = map[string]int{"malformed": 0, "duplicate": 1, "dotted": 2, "orphan": 3, "undocumented": 4}
+
+// lintDesc 检查 desc.toml：字段格式、跨分类重名、没有对应命令的描述、没有描述的外部命令
+func lintDesc(path string, shell *Shell, d *DescManager) ([]descIssue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := toml.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析 %s 失败: %v", path, err)
+	}
+
+	var issues []descIssue
+	malformed := func(key, format string, a ...any) {
+		issues = append(issues, descIssue{Kind: "malformed", Severity: "error", Name: key, Message: fmt.Sprintf(format, a...)})
+	}
+	categories := make(map[string][]string) // 命令名 -> 所在分类
+
+	var walk func(m map[string]interface{}, prefix []string)
+	walk = func(m map[string]interface{}, prefix []string) {
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			full := append(append([]string(nil), prefix...), k)
+			key := strings.Join(full, ".")
+			node, ok := m[k].(map[string]interface{})
+			if !ok {
+				malformed(key, "不属于任何命令条目（所在的表缺少 desc？）")
+				continue
+			}
+			if _, ok := node["desc"]; !ok {
+				// 只含子表的为分类或命令名中的层级，含有字段的是缺少 desc 的命令条目
+				children := make(map[string]interface{})
+				for field, v := range node {
+					if sub, ok := v.(map[string]interface{}); ok {
+						children[field] = sub
+					}
+				}
+				switch {
+				case len(node) == 0:
+					malformed(key, "空表")
+				case len(children) < len(node):
+					malformed(key, "缺少 desc，该条目不会被加载")
+				}
+				walk(children, full)
+				continue
+			}
+			if len(full) < 2 {
+				malformed(key, "命令缺少分类，应写作 [分类.%s]", k)
+				continue
+			}
+			fields := make([]string, 0, len(node))
+			for field := range node {
+				fields = append(fields, field)
+			}
+			sort.Strings(fields)
+			for _, field := range fields {
+				check, known := descFields[field]
+				if !known {
+					malformed(key, "未知字段 %s", field)
+					continue
+				}
+				if err := check(node[field]); err != nil {
+					malformed(key, "%s %v", field, err)
+				}
+			}
+			// 末段为命令名，中间各段为分组；命令名本身带 . 时应加引号
+			name := k
+			if joined := strings.Join(full[1:], "."); len(full) > 2 {
+				_, leaf := shell.Lookup(name)
+				if _, ok := shell.Lookup(joined); ok && !leaf {
+					issues = append(issues, descIssue{Kind: "dotted", Severity: "warning", Name: joined,
+						Message: fmt.Sprintf("命令名中的 . 会被当作分组，应写作 [%s]", descKey([]string{full[0], joined}))})
+					name = joined
+				}
+			}
+			categories[name] = append(categories[name], full[0])
+		}
+	}
+	walk(raw, nil)
+
+	for name, cats := range categories {
+		if len(cats) > 1 {
+			issues = append(issues, descIssue{Kind: "duplicate", Severity: "error", Name: name,
+				Message: fmt.Sprintf("在多个分类中重复描述: %s，这些条目都不会被加载", strings.Join(cats, ", "))})
+		}
+		if _, ok := shell.Lookup(name); !ok {
+			issues = append(issues, descIssue{Kind: "orphan", Severity: "warning", Name: name,
+				Message: "commands_dirs 中没有该命令"})
+		}
+	}
+	shell.mu.RLock()
+	for name, cmd := range shell.commands {
+		if _, ok := cmd.(*FileCommand); ok && categories[name] == nil && !d.selfDescribed(name) {
+			issues = append(issues, descIssue{Kind: "undocumented", Severity: "warning", Name: name,
+				Message: "没有描述: " + cmd.Path()})
+		}
+	}
+	shell.mu.RUnlock()
+
+	sort.SliceStable(issues, func(i, j int) bool {
+		if issues[i].Kind != issues[j].Kind {
+			return issueOrder[issues[i].Kind] < issueOrder[issues[j].Kind]
+		}
+		return issues[i].Name < issues[j].Name
+	})
+	return issues, nil
+}
+
+// lintOutput desc lint 的 JSON 输出
+type lintOutput struct {
+	Path   string      `json:"path"`
+	Issues []descIssue `json:"issues"`
+}
+
+func writeLint(w io.Writer, format OutputFormat, path string, issues []descIssue) error {
+	switch format {
+	case OutputJSON:
+		if issues == nil {
+			issues = []descIssue{}
+		}
+		return writeJSON(w, lintOutput{Path: path, Issues: issues})
+	case OutputTable:
+		rows := make([][]string, 0, len(issues))
+		for _, issue := range issues {
+			rows = append(rows, []string{issue.Severity, issue.Kind, issue.Name, issue.Message})
+		}
+		return writeTable(w, []string{"SEVERITY", "KIND", "NAME", "MESSAGE"}, rows)
+	}
+	if len(issues) == 0 {
+		fmt.Fprintf(w, "✅ %s 检查通过\n", path)
+		return nil
+	}
+	var errs, warns int
+	for _, issue := range issues {
+		mark := "⚠️"
+		if issue.Severity == "error" {
+			mark = "❌"
+			errs++
+		} else {
+			warns++
+		}
+		fmt.Fprintf(w, "%s %-12s %-20s %s\n", mark, issue.Kind, issue.Name, issue.Message)
+	}
+	fmt.Fprintf(w, "\n📄 %s: %d 个错误，%d 个警告\n", path, errs, warns)
+	return nil
+}
+
+// helpTimeout desc generate 等待 --help 输出的最长时间
+const helpTimeout = 5 * time.Second
+
+var helpColumns = regexp.MustCompile(`\s{2,}`)
+
+// parseHelp 从 --help 的输出中提取描述、用法、参数、选项与子命令
+func parseHelp(name, text string) CommandDesc {
+	var d CommandDesc
+	section := ""
+	for _, line := range strings.Split(text, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" {
+			continue
+		}
+		lower := strings.ToLower(trimmed)
+		if strings.HasPrefix(lower, "usage:") {
+			if rest := strings.TrimSpace(trimmed[len("usage:"):]); rest != "" {
+				d.Usage = rest
+				section = ""
+			} else {
+				section = "usage"
+			}
+			continue
+		}
+		// 不缩进、以冒号结尾的行为小节标题
+		if strings.HasSuffix(trimmed, ":") && line == trimmed {
+			switch strings.TrimSuffix(lower, ":") {
+			case "options", "flags", "global options":
+				section = "flags"
+			case "commands", "subcommands", "available commands":
+				section = "subcommands"
+			case "arguments", "args", "positional arguments":
+				section = "args"
+			default:
+				section = ""
+			}
+			continue
+		}
+		switch section {
+		case "usage":
+			if d.Usage == "" {
+				d.Usage = trimmed
+			}
+		case "flags":
+			d.Flags = append(d.Flags, helpEntry(trimmed))
+		case "subcommands":
+			d.Subcommands = append(d.Subcommands, helpEntry(trimmed))
+		case "args":
+			d.Args = append(d.Args, helpEntry(trimmed))
+		default:
+			if strings.HasPrefix(trimmed, "-") {
+				d.Flags = append(d.Flags, helpEntry(trimmed))
+			} else if d.Desc == "" {
+				d.Desc = trimmed
+			}
+		}
+	}
+	if d.Desc == "" {
+		d.Desc = "TODO: 补充 " + name + " 的描述"
+	}
+	if d.Usage == "" {
+		d.Usage = name + " [ARGS...]"
+	}
+	return d
+}
+
+// helpEntry "-h, --help   Print help" 转为 desc.toml 的写法 "-h, --help    # Print help"
+func helpEntry(line string) string {
+	parts := helpColumns.Split(line, 2)
+	if len(parts) < 2 {
+		return line
+	}
+	return parts[0] + "    # " + parts[1]
+}
+
+// renderDesc 生成 desc.toml 条目
+func renderDesc(category, name string, d CommandDesc) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s 命令（desc generate 根据 --help 生成）\n", name)
+	fmt.Fprintf(&b, "[%s]\n", descKey([]string{category, name}))
+	fmt.Fprintf(&b, "desc = %s\n", strconv.Quote(d.Desc))
+	fmt.Fprintf(&b, "usage = %s\n", strconv.Quote(d.Usage))
+	for _, f := range []struct {
+		key    string
+		values []string
+	}{{"subcommands", d.Subcommands}, {"args", d.Args}, {"flags", d.Flags}, {"returns", d.Returns}} {
+		if len(f.values) == 0 {
+			continue
+		}
+		fmt.Fprintf(&b, "%s = [\n", f.key)
+		for _, v := range f.values {
+			fmt.Fprintf(&b, "    %s,\n", strconv.Quote(v))
+		}
+		b.WriteString("]\n")
+	}
+	return b.String()
+}
+
+var bareKey = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// descKey 组成 TOML 表名，非裸键的部分加引号，如 hello.sh 写作 "hello.sh"，不会被当作分组
+func descKey(parts []string) string {
+	quoted := make([]string, len(parts))
+	for i, p := range parts {
+		if bareKey.MatchString(p) {
+			quoted[i] = p
+		} else {
+			quoted[i] = strconv.Quote(p)
+		}
+	}
+	return strings.Join(quoted, ".")
+}
+
+// appendDesc 追加条目到 desc.toml，沿用文件原有的换行风格
+func appendDesc(path, entry string) error {
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	newline := "\n"
+	if bytes.Contains(data, []byte("\r\n")) {
+		newline = "\r\n"
+		entry = strings.ReplaceAll(entry, "\n", "\r\n")
+	}
+	var b bytes.Buffer
+	b.Write(data)
+	if len(data) > 0 {
+		if !bytes.HasSuffix(data, []byte(newline)) {
+			b.WriteString(newline)
+		}
+		b.WriteString(newline)
+	}
+	b.WriteString(entry)
+	return os.WriteFile(path, b.Bytes(), 0644)
+}
+
+// Builtin Desc
+type DescCommand struct {
+	shell   *Shell
+	descMgr *DescManager
+}
+
+func NewDescCommand(s *Shell, d *DescManager) *DescCommand {
+	return &DescCommand{shell: s, descMgr: d}
+}
+
+func (c *DescCommand) Name() string     { return "desc" }
+func (c *DescCommand) Category() string { return "sys" }
+func (c *DescCommand) Path() string     { return "" }
+func (c *DescCommand) IsBuiltin() bool  { return true }
+func (c *DescCommand) Desc() string     { return "检查 desc.toml，或根据 --help 生成命令描述" }
+func (c *DescCommand) Usage() string {
+	return "desc lint | desc generate COMMAND [--category CATEGORY] [--dry-run]"
+}
+func (c *DescCommand) Args() []string {
+	return []string{"COMMAND    # commands_dirs 中的外部命令"}
+}
+func (c *DescCommand) Returns() []string {
+	return []string{"lint 存在错误时返回 1；generate 将条目追加到 desc.toml"}
+}
+func (c *DescCommand) Flags() []string {
+	return []string{
+		"--category CATEGORY    # 生成条目的分类，默认为命令当前分类",
+		"--dry-run              # 只打印生成的条目，不写入 desc.toml",
+	}
+}
+func (c *DescCommand) Subcommands() []string {
+	return []string{
+		"lint        # 检查格式错误、重名、孤立描述与未描述的命令",
+		"generate    # 运行 COMMAND --help 生成描述骨架",
+	}
+}
+func (c *DescCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) < 2 {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	switch args[1] {
+	case "lint":
+		issues, err := lintDesc(c.descMgr.path, c.shell, c.descMgr)
+		if err != nil {
+			return err
+		}
+		if err := writeLint(stdio.Out, c.shell.OutputFormat(), c.descMgr.path, issues); err != nil {
+			return err
+		}
+		for _, issue := range issues {
+			if issue.Severity == "error" {
+				return &exitCodeError{code: 1}
+			}
+		}
+		return nil
+	case "generate":
+		return c.generate(args[2:], env, stdio)
+	}
+	return fmt.Errorf("用法: %s", c.Usage())
+}
+
+func (c *DescCommand) generate(args []string, env map[string]string, stdio *Stdio) error {
+	var name, category string
+	dryRun := false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--category":
+			if i+1 >= len(args) {
+				return fmt.Errorf("--category 缺少参数")
+			}
+			i++
+			category = args[i]
+		case "--dry-run":
+			dryRun = true
+		default:
+			if name != "" {
+				return fmt.Errorf("用法: %s", c.Usage())
+			}
+			name = args[i]
+		}
+	}
+	if name == "" {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	cmd, ok := c.shell.Lookup(name)
+	file, isFile := cmd.(*FileCommand)
+	if !ok || !isFile {
+		return fmt.Errorf("%s 不是 commands_dirs 中的外部命令", name)
+	}
+	if c.descMgr.inFile(name) && !dryRun {
+		return fmt.Errorf("%s 已在 desc.toml 中描述，请直接修改", name)
+	}
+	if category == "" {
+		category = file.Category()
+	}
+
+	// 运行 --help 与直接执行该命令相同：按角色授权、写入审计日志、遵守 desc.toml 中的执行限制
+	start := time.Now()
+	argv := []string{name, "--help"}
+	if !c.shell.Allowed(file) {
+		fmt.Fprintf(stdio.Err, "⛔ %v\n", &deniedError{name: name, roles: c.shell.auth.Roles()})
+		c.shell.audit.Record(argv, file.Path(), 126, start)
+		return &exitCodeError{code: 126}
+	}
+	out, err := runHelp(file, env)
+	c.shell.audit.Record(argv, file.Path(), exitStatus(err), start)
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return err
+	}
+	if len(bytes.TrimSpace(out)) == 0 {
+		if err != nil {
+			return fmt.Errorf("运行 %s --help 失败: %v", name, err)
+		}
+		return fmt.Errorf("%s --help 没有输出", name)
+	}
+
+	entry := renderDesc(category, name, parseHelp(name, string(out)))
+	if dryRun {
+		fmt.Fprint(stdio.Out, entry)
+		return nil
+	}
+	if err := appendDesc(c.descMgr.path, entry); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdio.Out, "✅ 已写入 %s:\n\n%s", c.descMgr.path, entry)
+	return c.descMgr.Load(c.descMgr.path)
+}
+
+// runHelp 运行 COMMAND --help，返回合并的标准输出与标准错误
+func runHelp(file *FileCommand, env map[string]string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), helpTimeout)
+	defer cancel()
+	help := exec.CommandContext(ctx, file.Path(), "--help")
+	help.Env = mergeEnv(env)
+	var out bytes.Buffer
+	help.Stdout = &out
+	help.Stderr = &out
+
+	policy, err := file.policy()
+	if err != nil {
+		return nil, err
+	}
+	if policy == nil {
+		err := help.Run()
+		return out.Bytes(), err
+	}
+	if err := policy.prepare(help); err != nil {
+		return nil, err
+	}
+	// 超时整组终止
+	if help.SysProcAttr == nil {
+		help.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	help.SysProcAttr.Setpgid = true
+	onStart, stop := policy.watch()
+	if err = help.Start(); err == nil {
+		onStart(help.Process.Pid)
+		err = help.Wait()
+	}
+	return out.Bytes(), policy.explain(err, stop())
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"flyos/pkg/dsl"
+)
+
+// 以已注册的 DSL kind 开头的输入交给 pkg/dsl 解析执行，如
+//
+//	route add default { via 10.0.0.1; dev eth0 }
+//	acls sync {
+//	    web { port 80 }
+//	}
+//	route list bgp where prefix ~ "^10\."
+//
+// 第二个词必须是 DSL 动词，同名的外部命令（如 route -n）仍按命令执行
+var dslVerbs = map[string]bool{
+	"add": true, "set": true, "delete": true, "sync": true,
+	"list": true, "show": true, "get": true,
+}
+
+// dslCategory DSL 语句在 RBAC 中的分类，命令名为 kind
+const dslCategory = "dsl"
+
+// isDSLLine 命令行是否以 DSL 语句开头，sync 的 kind 可以是复数形式（routes sync）
+func isDSLLine(line string) bool {
+	fields := strings.Fields(strings.SplitN(line, "{", 2)[0])
+	if len(fields) < 2 {
+		return false
+	}
+	kind, verb := strings.ToLower(fields[0]), strings.ToLower(fields[1])
+	if !dslVerbs[verb] {
+		return false
+	}
+	return dsl.Registered(kind) || (verb == "sync" && strings.HasSuffix(kind, "s") && dsl.Registered(kind[:len(kind)-1]))
+}
+
+// dslDepth 未闭合的 { 个数，忽略字符串与 # / // 注释中的括号
+func dslDepth(src string) int {
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		switch {
+		case src[i] == '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+			}
+		case src[i] == '#' || (src[i] == '/' && i+1 < len(src) && src[i+1] == '/'):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case src[i] == '{':
+			depth++
+		case src[i] == '}':
+			depth--
+		}
+	}
+	return depth
+}
+
+// dslTail 花括号与字符串之外第一个 | 或输出重定向的位置，没有时返回 -1，
+// 如 route list | match bgp、route show static > routes.txt
+func dslTail(src string) int {
+	depth := 0
+	for i := 0; i < len(src); i++ {
+		switch {
+		case src[i] == '"':
+			for i++; i < len(src) && src[i] != '"'; i++ {
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+				}
+			}
+		case src[i] == '#' || (src[i] == '/' && i+1 < len(src) && src[i+1] == '/'):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case src[i] == '{':
+			depth++
+		case src[i] == '}':
+			depth--
+		case depth == 0 && src[i] == '|':
+			return i
+		case depth == 0 && src[i] == '>':
+			// 2> 与 1> 的文件描述符属于重定向
+			if i > 0 && (src[i-1] == '1' || src[i-1] == '2') && (i == 1 || src[i-2] == ' ' || src[i-2] == '\t') {
+				return i - 1
+			}
+			return i
+		}
+	}
+	return -1
+}
+
+// RunDSL 解析并执行一段 DSL，name 与 firstLine 用于错误定位（REPL 中 name 为空）；
+// 语句之后可以接与命令行相同的管道、过滤器与重定向。
+// 语法错误返回 2，无权执行返回 126，执行失败返回 1
+func (s *Shell) RunDSL(src, name string, firstLine int) int {
+	i := dslTail(src)
+	if i < 0 {
+		return s.runDSL(src, name, firstLine, defaultStdio())
+	}
+	stmt, tail := src[:i], src[i:]
+	// 管道与重定向按命令行解析，DSL 语句作为管道的第一条命令，以 kind 作为显示的命令名
+	words := strings.Fields(stmt)
+	items, err := parseLine(words[0]+" "+tail, s.isCommand)
+	if err == nil && (len(items) != 1 || items[0].background) {
+		err = errors.New("DSL 语句之后只能是管道、过滤器与重定向")
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ %v\n", err)
+		s.setStatus(2)
+		return 2
+	}
+	first := items[0].pipe.cmds[0]
+	first.words = words
+	first.run = func(st *Stdio) int { return s.runDSL(stmt, name, firstLine, st) }
+	code := s.runJob(items[0], defaultStdio())
+	s.setStatus(code)
+	return code
+}
+
+// runDSL 执行不含管道的 DSL，输出写到 stdio
+func (s *Shell) runDSL(src, name string, firstLine int, stdio *Stdio) int {
+	start := time.Now()
+	cmds, err := dsl.NewParser(src).Parse()
+	if err != nil {
+		var errs dsl.ParseErrors
+		if errors.As(err, &errs) && len(errs) > 0 {
+			renderParseErrors(stdio.Err, src, name, firstLine, errs)
+		} else {
+			fmt.Fprintf(stdio.Err, "❌ %v\n", err)
+		}
+		s.setStatus(2)
+		return 2
+	}
+
+	argv := func(c dsl.Command) []string {
+		args := []string{c.Kind, c.Verb}
+		if c.Subtype != "" {
+			args = append(args, c.Subtype)
+		}
+		if c.Name != "" {
+			args = append(args, c.Name)
+		}
+		for i, w := range c.Where {
+			if i == 0 {
+				args = append(args, "where")
+			} else {
+				args = append(args, "and")
+			}
+			args = append(args, w.String())
+		}
+		return args
+	}
+	// 先检查全部语句的权限，避免只执行了一部分
+	for _, c := range cmds {
+		if !s.auth.Allowed(c.Kind, dslCategory) {
+			fmt.Fprintf(stdio.Err, "⛔ %v\n", &deniedError{name: c.Kind, roles: s.auth.Roles()})
+			s.audit.Record(argv(c), "", 126, start)
+			s.setStatus(126)
+			return 126
+		}
+	}
+	// list/show/get 在修改语句之后按顺序查询模块状态
+	var writes, queries []dsl.Command
+	for _, c := range cmds {
+		if dsl.IsQuery(c.Verb) {
+			queries = append(queries, c)
+		} else {
+			writes = append(writes, c)
+		}
+	}
+	code := 0
+	switch {
+	case len(writes) == 0:
+	case s.configDB.Editing():
+		// 配置模式中只修改候选配置，commit 时生效
+		if err := s.configDB.Stage(writes); err != nil {
+			fmt.Fprintf(stdio.Err, "⚠️ %v\n", err)
+			code = 1
+		}
+	default:
+		if err := dsl.ExecuteAllTo(stdio.Out, writes); err != nil {
+			fmt.Fprintf(stdio.Err, "💥 执行失败: %v\n", err)
+			code = 1
+		}
+	}
+	for i := 0; i < len(queries) && code == 0; i++ {
+		if err := s.runQuery(&queries[i], stdio.Out); err != nil {
+			fmt.Fprintf(stdio.Err, "💥 查询失败: %v\n", err)
+			code = 1
+		}
+	}
+	for _, c := range cmds {
+		s.audit.Record(argv(c), "", code, start)
+	}
+	s.setStatus(code)
+	if code != 0 && s.Errexit() {
+		s.mu.Lock()
+		s.exited = true
+		s.mu.Unlock()
+	}
+	return code
+}
+
+// runQuery 通过 kind 的状态模块查询对象，按输出格式写到 w
+func (s *Shell) runQuery(c *dsl.Command, w io.Writer) error {
+	specs, err := dsl.Query(c)
+	if err != nil {
+		return err
+	}
+	objects := make([]treeObject, 0, len(specs))
+	for _, spec := range specs {
+		attrs := make(map[string]interface{}, len(spec))
+		for k, v := range spec {
+			attrs[k] = v
+		}
+		name, _ := attrs["name"].(string)
+		// 以 prefix 等属性为标识的对象按输入时的形式显示，如 route add static { prefix ... }；
+		// 以 name 属性为标识时保留该属性
+		sub, typed := attrs["subtype"].(string)
+		delete(attrs, "subtype")
+		if !typed || attrs["prefix"] == name {
+			delete(attrs, "name")
+		}
+		if typed {
+			name = sub
+		}
+		objects = append(objects, treeObject{Kind: strings.ToLower(c.Kind), Name: name, Attrs: attrs})
+	}
+	return writeObjects(w, s.OutputFormat(), objects)
+}
+
+// renderParseErrors 打印第一个错误的位置与源码行，并在出错的 token 下方标出 ^，其余错误只计数；
+// firstLine 为 src 第一行在脚本中的行号
+func renderParseErrors(w io.Writer, src, name string, firstLine int, errs dsl.ParseErrors) {
+	e := errs[0]
+	loc := fmt.Sprintf("%d:%d", firstLine+e.Line-1, e.Col)
+	if name != "" {
+		loc = name + ":" + loc
+	}
+	fmt.Fprintf(w, "❌ %s: %s\n", loc, e.Msg)
+	if line, marker := e.Caret(src); marker != "" {
+		fmt.Fprintf(w, "   %s\n   %s\n", line, marker)
+	}
+	if len(errs) > 1 {
+		fmt.Fprintf(w, "   (还有 %d 个错误)\n", len(errs)-1)
+	}
+}
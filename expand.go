@@ -0,0 +1,128 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// expandWord 对原始单词做展开并去除引号
+// 单引号内原样保留；双引号与无引号部分支持反斜杠转义与 $ 展开
+// params 为函数的位置参数 $0..$n，不在函数内时为 nil
+func (s *Shell) expandWord(raw string, params []string) string {
+	var b strings.Builder
+	rs := []rune(raw)
+	for i := 0; i < len(rs); i++ {
+		switch ch := rs[i]; ch {
+		case '\\':
+			if i+1 < len(rs) {
+				i++
+				b.WriteRune(rs[i])
+			}
+		case '\'':
+			for i++; i < len(rs) && rs[i] != '\''; i++ {
+				b.WriteRune(rs[i])
+			}
+		case '"':
+			for i++; i < len(rs) && rs[i] != '"'; i++ {
+				switch rs[i] {
+				case '\\':
+					// 双引号内只有 \" \\ \$ 是转义
+					if i+1 < len(rs) && strings.ContainsRune(`"\$`, rs[i+1]) {
+						i++
+					}
+					b.WriteRune(rs[i])
+				case '$':
+					i = s.expandDollar(rs, i, &b, params)
+				default:
+					b.WriteRune(rs[i])
+				}
+			}
+		case '$':
+			i = s.expandDollar(rs, i, &b, params)
+		default:
+			b.WriteRune(ch)
+		}
+	}
+	return b.String()
+}
+
+// expandDollar 展开 rs[i] 处以 $ 开头的引用，返回最后消费的下标
+// 支持 $?、$NAME、${NAME}、${NAME:-default}、位置参数 $0..$9 $# $@ $* 与命令替换 $(...)
+func (s *Shell) expandDollar(rs []rune, i int, b *strings.Builder, params []string) int {
+	if i+1 >= len(rs) {
+		b.WriteRune('$')
+		return i
+	}
+	switch next := rs[i+1]; {
+	case next == '?':
+		b.WriteString(strconv.Itoa(s.LastStatus()))
+		return i + 1
+	case next >= '0' && next <= '9':
+		b.WriteString(positional(params, int(next-'0')))
+		return i + 1
+	case next == '#':
+		n := 0
+		if len(params) > 1 {
+			n = len(params) - 1
+		}
+		b.WriteString(strconv.Itoa(n))
+		return i + 1
+	case next == '@' || next == '*':
+		if len(params) > 1 {
+			b.WriteString(strings.Join(params[1:], " "))
+		}
+		return i + 1
+	case next == '(' || next == '{':
+		end, err := closingDollar(rs, i)
+		if err != nil {
+			// 词法阶段已校验，这里按字面输出
+			b.WriteString(string(rs[i:]))
+			return len(rs) - 1
+		}
+		inner := string(rs[i+2 : end])
+		if next == '(' {
+			b.WriteString(s.capture(inner, params))
+		} else {
+			b.WriteString(s.expandBraced(inner, params))
+		}
+		return end
+	case next == '_' || unicode.IsLetter(next):
+		j := i + 1
+		for j < len(rs) && (rs[j] == '_' || unicode.IsLetter(rs[j]) || unicode.IsDigit(rs[j])) {
+			j++
+		}
+		v, _ := s.LookupVar(string(rs[i+1 : j]))
+		b.WriteString(v)
+		return j - 1
+	}
+	b.WriteRune('$')
+	return i
+}
+
+// expandBraced 展开 ${...} 内部：NAME 或 NAME:-default，变量未设置或为空时使用默认值
+// NAME 为数字时取位置参数，如 ${10}
+func (s *Shell) expandBraced(inner string, params []string) string {
+	name, def, hasDefault := strings.Cut(inner, ":-")
+	var v string
+	if n, err := strconv.Atoi(name); err == nil && n >= 0 {
+		v = positional(params, n)
+	} else {
+		v, _ = s.LookupVar(name)
+	}
+	if v == "" && hasDefault {
+		return s.expandWord(def, params)
+	}
+	return v
+}
+
+// positional 返回第 n 个位置参数，$0 在函数外为 flyos
+func positional(params []string, n int) string {
+	if n < len(params) {
+		return params[n]
+	}
+	if n == 0 {
+		return "flyos"
+	}
+	return ""
+}
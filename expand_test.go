@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestExpandWord(t *testing.T) {
+	homeDir = t.TempDir()
+	os.Unsetenv("FLYOS_TEST_UNSET")
+	t.Setenv("FLYOS_TEST_OS", "from-os")
+
+	s := NewShell(map[string]string{})
+	s.SetVar("X", "1", false)
+	s.SetVar("NAME", "a b", false)
+	s.SetVar("EMPTY", "", false)
+	s.setStatus(3)
+	if _, err := os.Stat("/bin/echo"); err == nil {
+		s.Register(&FileCommand{name: "echo", path: "/bin/echo", category: "default", descMgr: NewDescManager()})
+	}
+	params := []string{"fn", "p1", "p2"}
+
+	tests := []struct {
+		raw, want string
+	}{
+		{"$X", "1"},
+		{"${X}", "1"},
+		{"$X.txt", "1.txt"},
+		{"${X}y", "1y"},
+		{"$Xy", ""}, // 变量名是 Xy
+		{"pre$X", "pre1"},
+		{"$NAME", "a b"},
+		{"$FLYOS_TEST_OS", "from-os"},
+		// 未设置的变量展开为空，:- 给出默认值
+		{"$FLYOS_TEST_UNSET", ""},
+		{"<${FLYOS_TEST_UNSET}>", "<>"},
+		{"${FLYOS_TEST_UNSET:-dflt}", "dflt"},
+		{"${EMPTY:-dflt}", "dflt"},
+		{"${X:-dflt}", "1"},
+		{"${FLYOS_TEST_UNSET:-$X}", "1"},
+		// 引号中的展开
+		{`"$X"`, "1"},
+		{`"${X}-$NAME"`, "1-a b"},
+		{`"$FLYOS_TEST_UNSET"`, ""},
+		{`'$X'`, "$X"},
+		{`"'$X'"`, "'1'"},
+		{`"\$X"`, "$X"},
+		{`\$X`, "$X"},
+		{`"a\b"`, `a\b`},
+		// 特殊参数
+		{"$?", "3"},
+		{"$#", "2"},
+		{"$1-$2-$3", "p1-p2-"},
+		{"${2}", "p2"},
+		{"$0", "fn"},
+		{"$@", "p1 p2"},
+		{"$", "$"},
+		{"a$", "a$"},
+		{"$-", "$-"},
+	}
+	for _, tt := range tests {
+		if got := s.expandWord(tt.raw, params); got != tt.want {
+			t.Errorf("expandWord(%s) = %q, want %q", tt.raw, got, tt.want)
+		}
+	}
+
+	if got := s.expandWord("$0", nil); got != "flyos" {
+		t.Errorf("$0 outside function = %q, want flyos", got)
+	}
+
+	// 命令替换，去掉结尾换行
+	if _, ok := s.Lookup("echo"); ok {
+		for raw, want := range map[string]string{
+			"$(echo $X)":        "1",
+			`"$(echo "$NAME")"`: "a b",
+			"x$(echo y)z":       "xyz",
+		} {
+			if got := s.expandWord(raw, params); got != want {
+				t.Errorf("expandWord(%s) = %q, want %q", raw, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chzyer/readline"
+)
+
+// 管道末尾的 Junos 风格输出过滤器，如 help | match net | count。
+// 带过滤器的管道先把输出收集到缓冲区，命令结束后再依次过滤
+var filterArgs = map[string][2]int{ // 过滤器 -> 参数个数的下限与上限
+	"match":   {1, 1},
+	"except":  {1, 1},
+	"count":   {0, 0},
+	"last":    {1, 1},
+	"no-more": {0, 0},
+	"save":    {1, 1},
+	"compare": {0, 2},
+}
+
+// filterNames 过滤器名称，供补全使用
+var filterNames = []string{"compare", "count", "except", "last", "match", "no-more", "save"}
+
+// outputFilter 一个过滤器，args 保留原始单词，执行前再展开
+type outputFilter struct {
+	name string
+	args []string
+}
+
+func (f outputFilter) String() string {
+	return strings.Join(append([]string{f.name}, f.args...), " ")
+}
+
+// splitFilters 把管道末尾连续的过滤器从命令中分离出来；第一条命令总是命令，
+// 过滤器之后不能再出现普通命令。与已注册命令同名的一段按命令执行，如 commands_dirs 中的 count
+func (p *pipeline) splitFilters(isCommand func(string) bool) error {
+	isFilter := func(name string) bool {
+		_, ok := filterArgs[name]
+		return ok && (isCommand == nil || !isCommand(name))
+	}
+	n := len(p.cmds)
+	for n > 1 && isFilter(p.cmds[n-1].words[0]) {
+		n--
+	}
+	for _, c := range p.cmds[n:] {
+		name, args := c.words[0], c.words[1:]
+		limit := filterArgs[name]
+		if len(c.redirs) > 0 {
+			return fmt.Errorf("语法错误: 过滤器 %s 不支持重定向", name)
+		}
+		if len(args) < limit[0] || len(args) > limit[1] {
+			return fmt.Errorf("语法错误: 用法 | %s", filterUsage[name])
+		}
+		if name == "compare" && (len(p.filters) > 0 || n != 1 || p.cmds[0].words[0] != "show") {
+			return fmt.Errorf("语法错误: compare 只能紧跟在 show 之后")
+		}
+		p.filters = append(p.filters, outputFilter{name: name, args: args})
+	}
+	p.cmds = p.cmds[:n]
+	for _, c := range p.cmds[1:] {
+		if isFilter(c.words[0]) {
+			return fmt.Errorf("语法错误: 过滤器 %s 之后只能跟过滤器", c.words[0])
+		}
+	}
+	return nil
+}
+
+var filterUsage = map[string]string{
+	"match":   "match <正则>",
+	"except":  "except <正则>",
+	"count":   "count",
+	"last":    "last N",
+	"no-more": "no-more",
+	"save":    "save <文件>",
+	"compare": "compare [rollback N]",
+}
+
+// noMore 管道中是否含有 no-more
+func (p *pipeline) noMore() bool {
+	for _, f := range p.filters {
+		if f.name == "no-more" {
+			return true
+		}
+	}
+	return false
+}
+
+// lineFilter 展开参数后的过滤器，输入输出均为按行切分的文本
+type lineFilter func(lines []string) ([]string, error)
+
+// prepareFilters 展开参数并检查正则、行数，命令执行之前发现错误
+func (s *Shell) prepareFilters(filters []outputFilter, params []string) ([]lineFilter, error) {
+	var result []lineFilter
+	for _, f := range filters {
+		args := make([]string, len(f.args))
+		for i, a := range f.args {
+			args[i] = s.expandWord(a, params)
+		}
+		switch f.name {
+		case "match", "except":
+			re, err := regexp.Compile(args[0])
+			if err != nil {
+				return nil, fmt.Errorf("%s: 正则无效: %v", f.name, err)
+			}
+			keep := f.name == "match"
+			result = append(result, func(lines []string) ([]string, error) {
+				var out []string
+				for _, line := range lines {
+					if re.MatchString(line) == keep {
+						out = append(out, line)
+					}
+				}
+				return out, nil
+			})
+		case "count":
+			result = append(result, func(lines []string) ([]string, error) {
+				return []string{fmt.Sprintf("共 %d 行", len(lines))}, nil
+			})
+		case "last":
+			n, err := strconv.Atoi(args[0])
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("last: 非法行数: %s", args[0])
+			}
+			result = append(result, func(lines []string) ([]string, error) {
+				if len(lines) > n {
+					lines = lines[len(lines)-n:]
+				}
+				return lines, nil
+			})
+		case "compare":
+			compare, err := s.compareFilter(args)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, compare)
+		case "save":
+			path := args[0]
+			result = append(result, func(lines []string) ([]string, error) {
+				// 输出中可能有配置密钥，只允许本人读取
+				if err := os.WriteFile(path, []byte(joinLines(lines)), 0600); err != nil {
+					return nil, fmt.Errorf("save: %v", err)
+				}
+				return []string{fmt.Sprintf("📄 已保存 %d 行到 %s", len(lines), path)}, nil
+			})
+		}
+	}
+	return result, nil
+}
+
+// splitLines 按行切分，忽略末尾的换行
+func splitLines(data []byte) []string {
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil
+	}
+	return strings.Split(text, "\n")
+}
+
+func joinLines(lines []string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+// lockedBuffer 并发安全的缓冲区，外部命令的 stdout 与 2>&1 后的 stderr 可能同时写入
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+// runFiltered 收集管道输出，命令结束后依次经过过滤器写到原来的 stdout
+func (s *Shell) runFiltered(p *pipeline, stdio *Stdio, run func(*Stdio) int) int {
+	filters, err := s.prepareFilters(p.filters, stdio.Params)
+	if err != nil {
+		fmt.Fprintf(stdio.Err, "⚠️ %v\n", err)
+		return 2
+	}
+	var buf lockedBuffer
+	st := *stdio
+	st.Out = &buf
+	status := run(&st)
+
+	lines := splitLines(buf.Bytes())
+	for _, f := range filters {
+		if lines, err = f(lines); err != nil {
+			fmt.Fprintf(stdio.Err, "⚠️ %v\n", err)
+			return 1
+		}
+	}
+	io.WriteString(stdio.Out, joinLines(lines))
+	return status
+}
+
+// shouldPage 交互终端中，前台的内置命令或带过滤器的管道输出到终端时分页显示；
+// 外部命令的输出可能是持续的，不收集
+func (s *Shell) shouldPage(item chainItem, stdio *Stdio) bool {
+	if !s.interactive || item.background || stdio.Job != nil || stdio.Out != os.Stdout || item.pipe.noMore() {
+		return false
+	}
+	if len(item.pipe.filters) > 0 {
+		return true
+	}
+	for _, c := range item.pipe.cmds {
+		cmd, ok := s.Lookup(c.words[0])
+		if !ok || !cmd.IsBuiltin() {
+			return false
+		}
+	}
+	return true
+}
+
+// pageOutput 超过一屏时逐屏显示：空格下一屏，回车下一行，q 退出
+func pageOutput(w *os.File, data []byte) {
+	_, height, err := readline.GetSize(int(w.Fd()))
+	lines := splitLines(data)
+	if err != nil || height < 3 || len(lines) < height {
+		w.Write(data)
+		return
+	}
+	fd := int(os.Stdin.Fd())
+	state, err := readline.MakeRaw(fd)
+	if err != nil {
+		w.Write(data)
+		return
+	}
+	defer readline.Restore(fd, state)
+
+	// readline.MakeRaw 保留 OPOST，\n 仍会换行并回到行首
+	show := func(from, n int) int {
+		for i := from; i < from+n && i < len(lines); i++ {
+			fmt.Fprintln(w, lines[i])
+		}
+		return from + n
+	}
+	pos := show(0, height-1)
+	key := make([]byte, 1)
+	for pos < len(lines) {
+		fmt.Fprintf(w, "\x1b[7m---(更多 %d%%)---\x1b[0m", pos*100/len(lines))
+		if _, err := os.Stdin.Read(key); err != nil {
+			key[0] = 'q'
+		}
+		fmt.Fprint(w, "\r\x1b[K")
+		switch key[0] {
+		case ' ', 'f':
+			pos = show(pos, height-1)
+		case '\r', '\n', 'j':
+			pos = show(pos, 1)
+		case 'q', 'Q', 3: // 3 为 Ctrl-C
+			return
+		}
+	}
+}
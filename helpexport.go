@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 导出的文档不含生成时间与命令路径，相同的命令与描述总是生成相同的文件，便于提交到仓库
+var exportFormats = map[string]func(dir string, cats []string, docs map[string][]helpDoc) ([]string, error){
+	"man":      exportMan,
+	"markdown": exportMarkdown,
+	"html":     exportHTML,
+}
+
+// helpDoc 导出文档中的一条命令
+type helpDoc struct {
+	commandHelp
+	Group []string
+}
+
+// docSection 文档中的一节，如 Flags
+type docSection struct {
+	Title string
+	Lines []string
+}
+
+func (d helpDoc) sections() []docSection {
+	var schema []string
+	if d.Schema != nil {
+		schema = d.Schema.describe()
+	}
+	all := []docSection{
+		{"Usage", []string{d.Usage}},
+		{"Args", d.Args},
+		{"Flags", d.Flags},
+		{"Subcommands", d.Subcommands},
+		{"Schema", schema},
+		{"Returns", d.Returns},
+	}
+	var result []docSection
+	for _, s := range all {
+		if len(s.Lines) > 0 && !(len(s.Lines) == 1 && s.Lines[0] == "") {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+func (d helpDoc) kind() string {
+	if d.Builtin {
+		return "内置命令"
+	}
+	return "外部命令"
+}
+
+// exportDocs 收集全部内置命令与 desc.toml 中的命令，按分类分组，分类内按分组路径、命令名排序。
+// 不经过 RBAC，也不取外部命令运行时的自描述，同一份程序与 desc.toml 无论由谁导出结果都相同；
+// withAuto 为真时另外导出 --describe 缓存中的命令
+func (h *HelpCommand) exportDocs(withAuto bool) ([]string, map[string][]helpDoc) {
+	docs := make(map[string][]helpDoc)
+	add := func(doc helpDoc) {
+		doc.Flags, doc.Subcommands, doc.Args, doc.Returns = nonNil(doc.Flags), nonNil(doc.Subcommands), nonNil(doc.Args), nonNil(doc.Returns)
+		docs[doc.Category] = append(docs[doc.Category], doc)
+	}
+
+	seen := make(map[string]bool)
+	for _, cmd := range h.shell.Commands() {
+		if !cmd.IsBuiltin() {
+			continue
+		}
+		seen[cmd.Name()] = true
+		doc := helpDoc{commandHelp: commandHelp{
+			Kind: "command", Name: cmd.Name(), Category: cmd.Category(), Builtin: true,
+			Desc: cmd.Desc(), Usage: cmd.Usage(),
+			Flags: cmd.Flags(), Subcommands: cmd.Subcommands(), Args: cmd.Args(), Returns: cmd.Returns(),
+		}}
+		if desc, ok := h.descMgr.desc.Load(cmd.Name()); ok && doc.Desc == "" {
+			doc.Desc = desc.(CommandDesc).Desc
+		}
+		add(doc)
+	}
+
+	sources := []*sync.Map{&h.descMgr.desc}
+	if withAuto {
+		sources = append(sources, &h.descMgr.auto)
+	}
+	for _, m := range sources {
+		m.Range(func(k, v any) bool {
+			name, desc := k.(string), v.(CommandDesc)
+			if seen[name] {
+				return true
+			}
+			seen[name] = true
+			add(helpDoc{commandHelp: commandHelp{
+				Kind: "command", Name: name, Category: desc.Category, Desc: desc.Desc, Usage: desc.Usage,
+				Flags: desc.Flags, Subcommands: desc.Subcommands, Args: desc.Args, Returns: desc.Returns,
+				Schema: desc.Schema,
+			}, Group: desc.Group})
+			return true
+		})
+	}
+
+	cats := make([]string, 0, len(docs))
+	for cat, list := range docs {
+		cats = append(cats, cat)
+		sort.Slice(list, func(i, j int) bool {
+			gi, gj := strings.Join(list[i].Group, "/"), strings.Join(list[j].Group, "/")
+			if gi != gj {
+				return gi < gj
+			}
+			return list[i].Name < list[j].Name
+		})
+	}
+	sort.Strings(cats)
+	return cats, docs
+}
+
+// exportHelp help export --format FORMAT --dir DIR [--self-described]
+func (h *HelpCommand) exportHelp(args []string, stdio *Stdio) error {
+	format, dir, withAuto := "markdown", "", false
+	for i := 0; i < len(args); i++ {
+		switch args[i] {
+		case "--self-described":
+			withAuto = true
+		case "--format", "--dir":
+			if i+1 >= len(args) {
+				return fmt.Errorf("选项 %s 缺少值", args[i])
+			}
+			if args[i] == "--format" {
+				format = args[i+1]
+			} else {
+				dir = args[i+1]
+			}
+			i++
+		default:
+			return fmt.Errorf("未知选项: %s", args[i])
+		}
+	}
+	export, ok := exportFormats[format]
+	if !ok {
+		return fmt.Errorf("不支持的格式 %q，可选 man、markdown、html", format)
+	}
+	if dir == "" {
+		return fmt.Errorf("用法: help export --format man|markdown|html --dir DIR [--self-described]")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	cats, docs := h.exportDocs(withAuto)
+	files, err := export(dir, cats, docs)
+	if err != nil {
+		return err
+	}
+
+	switch h.shell.OutputFormat() {
+	case OutputJSON:
+		return writeJSON(stdio.Out, exportOutput{Format: format, Dir: dir, Files: nonNil(files)})
+	case OutputTable:
+		rows := make([][]string, 0, len(files))
+		for _, f := range files {
+			rows = append(rows, []string{f})
+		}
+		return writeTable(stdio.Out, []string{"FILE"}, rows)
+	}
+	count := 0
+	for _, list := range docs {
+		count += len(list)
+	}
+	fmt.Fprintf(stdio.Out, "✅ 已导出 %d 个命令（%d 个分类）到 %s，共 %d 个文件\n", count, len(cats), dir, len(files))
+	return nil
+}
+
+// exportOutput help export 的 JSON 输出
+type exportOutput struct {
+	Format string   `json:"format"`
+	Dir    string   `json:"dir"`
+	Files  []string `json:"files"`
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^\p{L}\p{N}._-]`)
+
+// docFileName 把命令名或分类名转为文件名
+func docFileName(name, ext string) string {
+	name = unsafeFileChars.ReplaceAllString(name, "_")
+	if name == "" || name == "." || name == ".." {
+		name = "_"
+	}
+	return name + ext
+}
+
+// writeDocFile 内容未变时不改写文件，保持 mtime 不变
+func writeDocFile(dir, name string, data []byte, files *[]string) error {
+	path := filepath.Join(dir, name)
+	*files = append(*files, path)
+	if old, err := os.ReadFile(path); err == nil && bytes.Equal(old, data) {
+		return nil
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// ---------- man ----------
+
+// manEscape 转义 roff 的反斜杠与行首控制字符
+func manEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\e`)
+	if strings.HasPrefix(s, ".") || strings.HasPrefix(s, "'") {
+		s = `\&` + s
+	}
+	return s
+}
+
+var manTitles = map[string]string{
+	"Usage": "SYNOPSIS", "Args": "ARGUMENTS", "Flags": "OPTIONS",
+	"Subcommands": "SUBCOMMANDS", "Schema": "SCHEMA", "Returns": "RETURN VALUE",
+}
+
+// exportMan 每个命令一页 NAME.1，另有索引页 flyos.1 按分类列出全部命令
+func exportMan(dir string, cats []string, docs map[string][]helpDoc) ([]string, error) {
+	var files []string
+	var index bytes.Buffer
+	fmt.Fprintln(&index, `.TH "FLYOS" "1" "" "flyos" "FlyOS 命令参考"`)
+	fmt.Fprintln(&index, ".SH NAME")
+	fmt.Fprintln(&index, `flyos \- FlyOS 命令参考`)
+	fmt.Fprintln(&index, ".SH COMMANDS")
+	for _, cat := range cats {
+		fmt.Fprintf(&index, ".SS %s\n", manEscape(cat))
+		for _, doc := range docs[cat] {
+			fmt.Fprintf(&index, ".TP\n.BR %s (1)\n%s\n", manEscape(doc.Name), manEscape(doc.Desc))
+
+			var b bytes.Buffer
+			fmt.Fprintf(&b, ".TH \"%s\" \"1\" \"\" \"flyos\" \"FlyOS 命令参考\"\n", manEscape(strings.ToUpper(doc.Name)))
+			fmt.Fprintf(&b, ".SH NAME\n%s \\- %s\n", manEscape(doc.Name), manEscape(doc.Desc))
+			fmt.Fprintf(&b, ".SH CATEGORY\n%s（%s）\n", manEscape(helpPath(append([]string{cat}, doc.Group...))), doc.kind())
+			for _, s := range doc.sections() {
+				fmt.Fprintf(&b, ".SH %s\n.nf\n", manTitles[s.Title])
+				for _, line := range s.Lines {
+					fmt.Fprintln(&b, manEscape(line))
+				}
+				fmt.Fprintln(&b, ".fi")
+			}
+			fmt.Fprintln(&b, ".SH SEE ALSO\n.BR flyos (1)")
+			if err := writeDocFile(dir, docFileName(doc.Name, ".1"), b.Bytes(), &files); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if err := writeDocFile(dir, "flyos.1", index.Bytes(), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// ---------- markdown ----------
+
+// exportMarkdown 每个分类一个 CATEGORY.md，另有索引 index.md
+func exportMarkdown(dir string, cats []string, docs map[string][]helpDoc) ([]string, error) {
+	var files []string
+	var index bytes.Buffer
+	fmt.Fprintln(&index, "# FlyOS 命令参考")
+	fmt.Fprintln(&index)
+	for _, cat := range cats {
+		fmt.Fprintf(&index, "- [%s](%s)（%d 个命令）\n", cat, docFileName(cat, ".md"), len(docs[cat]))
+
+		var b bytes.Buffer
+		fmt.Fprintf(&b, "# %s\n", cat)
+		for _, doc := range docs[cat] {
+			writeMarkdownDoc(&b, cat, doc)
+		}
+		if err := writeDocFile(dir, docFileName(cat, ".md"), b.Bytes(), &files); err != nil {
+			return nil, err
+		}
+	}
+	if err := writeDocFile(dir, "index.md", index.Bytes(), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func writeMarkdownDoc(w io.Writer, cat string, doc helpDoc) {
+	fmt.Fprintf(w, "\n## %s\n\n%s\n\n", doc.Name, doc.Desc)
+	fmt.Fprintf(w, "- 类型: %s\n", doc.kind())
+	if len(doc.Group) > 0 {
+		fmt.Fprintf(w, "- 分组: %s\n", helpPath(append([]string{cat}, doc.Group...)))
+	}
+	for _, s := range doc.sections() {
+		fmt.Fprintf(w, "\n### %s\n\n```text\n", s.Title)
+		for _, line := range s.Lines {
+			fmt.Fprintln(w, line)
+		}
+		fmt.Fprintln(w, "```")
+	}
+}
+
+// ---------- html ----------
+
+const htmlHead = `<!DOCTYPE html>
+<html lang="zh-CN">
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+</head>
+<body>
+`
+
+// exportHTML 每个分类一个 CATEGORY.html，另有索引 index.html
+func exportHTML(dir string, cats []string, docs map[string][]helpDoc) ([]string, error) {
+	esc := html.EscapeString
+	var files []string
+	var index bytes.Buffer
+	fmt.Fprintf(&index, htmlHead, "FlyOS 命令参考")
+	fmt.Fprintln(&index, "<h1>FlyOS 命令参考</h1>\n<ul>")
+	for _, cat := range cats {
+		file := docFileName(cat, ".html")
+		fmt.Fprintf(&index, "<li><a href=\"%s\">%s</a>（%d 个命令）</li>\n", esc(file), esc(cat), len(docs[cat]))
+
+		var b bytes.Buffer
+		fmt.Fprintf(&b, htmlHead, esc(cat))
+		fmt.Fprintf(&b, "<p><a href=\"index.html\">FlyOS 命令参考</a></p>\n<h1>%s</h1>\n", esc(cat))
+		for _, doc := range docs[cat] {
+			fmt.Fprintf(&b, "<h2 id=\"%s\">%s</h2>\n<p>%s</p>\n", esc(doc.Name), esc(doc.Name), esc(doc.Desc))
+			fmt.Fprintf(&b, "<ul>\n<li>类型: %s</li>\n", doc.kind())
+			if len(doc.Group) > 0 {
+				fmt.Fprintf(&b, "<li>分组: %s</li>\n", esc(helpPath(append([]string{cat}, doc.Group...))))
+			}
+			fmt.Fprintln(&b, "</ul>")
+			for _, s := range doc.sections() {
+				fmt.Fprintf(&b, "<h3>%s</h3>\n<pre>%s</pre>\n", s.Title, esc(strings.Join(s.Lines, "\n")))
+			}
+		}
+		fmt.Fprintln(&b, "</body>\n</html>")
+		if err := writeDocFile(dir, file, b.Bytes(), &files); err != nil {
+			return nil, err
+		}
+	}
+	fmt.Fprintln(&index, "</ul>\n</body>\n</html>")
+	if err := writeDocFile(dir, "index.html", index.Bytes(), &files); err != nil {
+		return nil, err
+	}
+	return files, nil
+}
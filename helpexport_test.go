@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "重新生成 testdata 下的 golden 文件")
+
+const exportDesc = `[net.ping]
+desc = "探测主机是否可达"
+usage = "ping HOST [-c COUNT]"
+args = ["HOST  # 目标主机"]
+flags = ["-c COUNT  # 发送次数"]
+
+[net.ping.schema]
+positional = [{ name = "HOST", type = "ip", required = true }]
+flags = [{ name = "--count", short = "-c", type = "int" }]
+
+[net.route.show]
+desc = "显示路由表"
+usage = "show [TABLE]"
+
+[sys.reboot]
+desc = "重启系统"
+returns = ["不返回"]
+`
+
+// TestHelpExportGolden 导出结果只取决于内置命令与 desc.toml，与角色、--describe 缓存无关
+func TestHelpExportGolden(t *testing.T) {
+	homeDir = t.TempDir()
+	s := NewShell(map[string]string{})
+	d := NewDescManager()
+	s.Register(NewHelpCommand(d, s))
+	s.Register(NewSetCommand(s))
+	s.Register(&ListCommand{shell: s})
+
+	path := filepath.Join(homeDir, "desc.toml")
+	if err := os.WriteFile(path, []byte(exportDesc), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	d.auto.Store("selfdesc", CommandDesc{Category: "default", Desc: "自描述的外部命令"})
+
+	export := func(t *testing.T, withAuto bool) map[string]string {
+		dir := t.TempDir()
+		cats, docs := (&HelpCommand{descMgr: d, shell: s}).exportDocs(withAuto)
+		files, err := exportMarkdown(dir, cats, docs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out := make(map[string]string)
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out[filepath.Base(f)] = string(data)
+		}
+		return out
+	}
+
+	got := export(t, false)
+	golden := filepath.Join("testdata", "help_export")
+	if *updateGolden {
+		if err := os.MkdirAll(golden, 0755); err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range got {
+			if err := os.WriteFile(filepath.Join(golden, name), []byte(data), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	entries, err := os.ReadDir(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != len(got) {
+		t.Errorf("导出 %d 个文件, golden 有 %d 个", len(got), len(entries))
+	}
+	for _, e := range entries {
+		want, err := os.ReadFile(filepath.Join(golden, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got[e.Name()] != string(want) {
+			t.Errorf("%s 与 golden 不一致:\n%s", e.Name(), got[e.Name()])
+		}
+	}
+
+	t.Run("rbac", func(t *testing.T) {
+		s.auth.Configure(RBACConfig{
+			DefaultRole: "viewer",
+			Roles:       map[string]RoleConfig{"viewer": {AllowCategories: []string{"net"}}},
+		})
+		defer s.auth.Configure(RBACConfig{})
+		for name, data := range export(t, false) {
+			if got[name] != data {
+				t.Errorf("启用 RBAC 后 %s 发生变化", name)
+			}
+		}
+	})
+
+	t.Run("self-described", func(t *testing.T) {
+		if _, ok := got["default.md"]; ok {
+			t.Error("未指定 --self-described 时导出了 --describe 缓存")
+		}
+		if _, ok := export(t, true)["default.md"]; !ok {
+			t.Error("--self-described 未导出 --describe 缓存")
+		}
+	})
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// helpNode 帮助树中的一层：desc.toml 中 [system.net.ping] 表示分类 system 下分组 net 中的命令 ping
+type helpNode struct {
+	name     string
+	children map[string]*helpNode
+	commands []string // 直接位于本层的命令
+}
+
+func newHelpNode(name string) *helpNode {
+	return &helpNode{name: name, children: make(map[string]*helpNode)}
+}
+
+// add 沿 path 建立各层并把命令放在最后一层
+func (n *helpNode) add(path []string, name string) {
+	for _, p := range path {
+		child, ok := n.children[p]
+		if !ok {
+			child = newHelpNode(p)
+			n.children[p] = child
+		}
+		n = child
+	}
+	n.commands = append(n.commands, name)
+}
+
+func (n *helpNode) sort() {
+	sort.Strings(n.commands)
+	for _, child := range n.children {
+		child.sort()
+	}
+}
+
+// child 按名称查找下一层，大小写不敏感
+func (n *helpNode) child(name string) (*helpNode, bool) {
+	if child, ok := n.children[name]; ok {
+		return child, true
+	}
+	for k, child := range n.children {
+		if strings.EqualFold(k, name) {
+			return child, true
+		}
+	}
+	return nil, false
+}
+
+// childNames 下一层分组名，已排序
+func (n *helpNode) childNames() []string {
+	names := make([]string, 0, len(n.children))
+	for k := range n.children {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// visible 本层及以下 keep 返回 true 的命令数
+func (n *helpNode) visible(keep func(string) bool) int {
+	count := 0
+	for _, name := range n.commands {
+		if keep(name) {
+			count++
+		}
+	}
+	for _, child := range n.children {
+		count += child.visible(keep)
+	}
+	return count
+}
+
+// node 沿 path 逐层查找，返回最后到达的一层及沿途各层的实际名称；
+// 未走完 path 时 ok 为 false，可据此提示缺少的是哪一层
+func (d *DescManager) node(path []string) (n *helpNode, names []string, ok bool) {
+	n = d.tree.Load()
+	if n == nil {
+		return nil, nil, false
+	}
+	for _, p := range path {
+		child, found := n.child(p)
+		if !found {
+			return n, names, false
+		}
+		n = child
+		names = append(names, child.name)
+	}
+	return n, names, true
+}
+
+// category 按名称查找当前角色可见的分类，大小写不敏感
+func (h *HelpCommand) category(name string) (string, bool) {
+	for _, cat := range h.categories() {
+		if strings.EqualFold(cat, name) {
+			return cat, true
+		}
+	}
+	return "", false
+}
+
+// lookupNode help CATEGORY [GROUP...] 逐层查找；只含别名、函数的分类不在帮助树中，视为空的一层
+func (h *HelpCommand) lookupNode(cat string, groups []string) (n *helpNode, names []string, ok bool) {
+	n, names, ok = h.descMgr.node(append([]string{cat}, groups...))
+	if len(names) == 0 {
+		return newHelpNode(cat), []string{cat}, len(groups) == 0
+	}
+	return n, names, ok
+}
+
+// groupNames 本层中含有可见命令的分组
+func (h *HelpCommand) groupNames(n *helpNode) []string {
+	keep := func(name string) bool { return h.shell.permitted(name, h.descMgr) }
+	var names []string
+	for _, name := range n.childNames() {
+		if n.children[name].visible(keep) > 0 {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// suggestFor 未找到命令或分类时，从可见的命令名与分类中给出相近的候选
+func (h *HelpCommand) suggestFor(target string) []string {
+	candidates := append(h.shell.Names(), h.categories()...)
+	h.descMgr.desc.Range(func(k, _ any) bool {
+		if h.shell.permitted(k.(string), h.descMgr) {
+			candidates = append(candidates, k.(string))
+		}
+		return true
+	})
+	return suggest(target, candidates)
+}
+
+// helpPath help 中显示的层级路径，如 system / net
+func helpPath(path []string) string {
+	return strings.Join(path, " / ")
+}
+
+// printNode help CATEGORY [GROUP...] 的文本输出：先列子分组，再列本层命令
+func (h *HelpCommand) printNode(w io.Writer, path []string, n *helpNode, extra []Command) {
+	keep := func(name string) bool { return h.shell.permitted(name, h.descMgr) }
+	fmt.Fprintf(w, "🗂  分类: %s\n\n", helpPath(path))
+	groups := h.groupNames(n)
+	for _, name := range groups {
+		fmt.Fprintf(w, "  %-20s - %d 个命令\n", "📁 "+name+"/", n.children[name].visible(keep))
+	}
+	for _, name := range n.commands {
+		if desc, ok := h.descMgr.Get(name); ok && keep(name) {
+			fmt.Fprintf(w, "  %-20s - %s\n", name, desc.Desc)
+		}
+	}
+	for _, cmd := range extra {
+		fmt.Fprintf(w, "  %-20s - %s\n", cmd.Name(), cmd.Desc())
+	}
+	if len(groups) > 0 {
+		fmt.Fprintf(w, "\n💡 使用 `help %s <分组>` 进入下一层\n", strings.Join(path, " "))
+	}
+}
+
+// maxSuggestions 最多给出的候选数
+const maxSuggestions = 3
+
+// suggest 按编辑距离从 candidates 中挑出与 target 相近的名称，最相近的在前；
+// 允许的距离随长度增加（1 到 3），以 target 开头的名称也算相近
+func suggest(target string, candidates []string) []string {
+	type scored struct {
+		name string
+		dist int
+	}
+	lower := strings.ToLower(target)
+	limit := len([]rune(target)) / 3
+	if limit < 1 {
+		limit = 1
+	}
+	if limit > 3 {
+		limit = 3
+	}
+	var found []scored
+	seen := make(map[string]bool)
+	for _, c := range candidates {
+		if seen[c] || c == target {
+			continue
+		}
+		seen[c] = true
+		cl := strings.ToLower(c)
+		dist := levenshtein(lower, cl)
+		if dist <= limit || (len(lower) >= 2 && strings.HasPrefix(cl, lower)) {
+			found = append(found, scored{c, dist})
+		}
+	}
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].dist != found[j].dist {
+			return found[i].dist < found[j].dist
+		}
+		return found[i].name < found[j].name
+	})
+	if len(found) > maxSuggestions {
+		found = found[:maxSuggestions]
+	}
+	result := make([]string, 0, len(found))
+	for _, s := range found {
+		result = append(result, s.name)
+	}
+	return result
+}
+
+// levenshtein 按字符（而非字节）计算编辑距离，相邻两字符互换（如 hlep）计为一次编辑
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev2 := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if v := cur[j-1] + 1; v < cur[j] {
+				cur[j] = v
+			}
+			if v := prev[j-1] + cost; v < cur[j] {
+				cur[j] = v
+			}
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] {
+				if v := prev2[j-2] + 1; v < cur[j] {
+					cur[j] = v
+				}
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(rb)]
+}
+
+func printSuggestions(w io.Writer, names []string) {
+	if len(names) > 0 {
+		fmt.Fprintf(w, "💡 你是不是要找: %s\n", strings.Join(names, "、"))
+	}
+}
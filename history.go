@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// HistoryConfig config.toml 中的 [history]
+type HistoryConfig struct {
+	File       string   `toml:"file"`        // 默认 ~/.flyos/history，开头的 ~ 展开为用户主目录
+	MaxEntries int      `toml:"max_entries"` // 超过后丢弃最早的记录
+	Ignore     []string `toml:"ignore"`      // 正则，匹配的命令行不记录，用于过滤含密钥的命令
+	IgnoreDups bool     `toml:"ignore_dups"` // 与上一条相同时不记录
+}
+
+const defaultHistoryMax = 1000
+
+// History 按用户保存的 REPL 历史，一行一条命令，文件权限为 0600
+type History struct {
+	mu         sync.Mutex
+	path       string
+	max        int
+	ignore     []*regexp.Regexp
+	ignoreDups bool
+	entries    []string
+	warned     bool // 已提示过写入失败，同一文件只提示一次
+}
+
+func NewHistory() *History {
+	return &History{max: defaultHistoryMax}
+}
+
+// Configure 应用配置并重新读取历史文件，正则无效时保留原配置
+func (h *History) Configure(cfg HistoryConfig) error {
+	ignore := make([]*regexp.Regexp, 0, len(cfg.Ignore))
+	for _, pattern := range cfg.Ignore {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return fmt.Errorf("history.ignore 无效: %v", err)
+		}
+		ignore = append(ignore, re)
+	}
+	file := expandHome(cfg.File)
+	if file == "" {
+		file = filepath.Join(homeDir, ".flyos", "history")
+	}
+	max := cfg.MaxEntries
+	if max <= 0 {
+		max = defaultHistoryMax
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ignore, h.ignoreDups, h.max = ignore, cfg.IgnoreDups, max
+	if file != h.path {
+		h.path, h.warned = file, false
+		h.entries = readHistory(file)
+	}
+	if len(h.entries) > h.max {
+		h.entries = h.entries[len(h.entries)-h.max:]
+		h.rewrite()
+	}
+	return nil
+}
+
+// expandHome 把开头的 ~ 或 ~/ 展开为用户主目录
+func expandHome(path string) string {
+	if path == "~" {
+		return homeDir
+	}
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		return filepath.Join(homeDir, rest)
+	}
+	return path
+}
+
+func readHistory(path string) []string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var entries []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+	for sc.Scan() {
+		if line := sc.Text(); line != "" {
+			entries = append(entries, line)
+		}
+	}
+	return entries
+}
+
+// Limit 最多保留的条数
+func (h *History) Limit() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.max
+}
+
+// Entries 全部记录的副本，第 i 项的编号为 i+1
+func (h *History) Entries() []string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]string(nil), h.entries...)
+}
+
+// ignored 命令行是否匹配 ignore 中的任一正则
+func (h *History) ignored(line string) bool {
+	for _, re := range h.ignore {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Add 记录一条命令，返回是否已记录；被忽略的命令也不应进入 readline 的内存历史
+func (h *History) Add(line string) bool {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.ContainsAny(line, "\r\n") {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.ignored(line) {
+		return false
+	}
+	if h.ignoreDups && len(h.entries) > 0 && h.entries[len(h.entries)-1] == line {
+		return false
+	}
+	h.entries = append(h.entries, line)
+	h.appendLine(line)
+	if len(h.entries) > h.max {
+		// 以文件为准截断，保留同一用户其他会话追加的记录
+		if entries := readHistory(h.path); len(entries) > 0 {
+			h.entries = entries
+		}
+		if len(h.entries) > h.max {
+			h.entries = h.entries[len(h.entries)-h.max:]
+		}
+		h.rewrite()
+	}
+	return true
+}
+
+// report 提示写入历史文件失败，之后的失败不再提示，记录只保留在内存中
+func (h *History) report(err error) {
+	if err == nil || h.warned {
+		return
+	}
+	h.warned = true
+	fmt.Fprintf(os.Stderr, "⚠️ 写入历史文件失败，本会话的历史只保存在内存中: %v\n", err)
+}
+
+// openHistory 打开历史文件，所在目录不存在时先创建
+func openHistory(path string, flag int) (*os.File, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	return os.OpenFile(path, flag, 0600)
+}
+
+// appendLine 追加到历史文件
+func (h *History) appendLine(line string) {
+	if h.path == "" {
+		return
+	}
+	f, err := openHistory(h.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND)
+	if err != nil {
+		h.report(err)
+		return
+	}
+	_, err = fmt.Fprintln(f, line)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	h.report(err)
+}
+
+// rewrite 截断后整体写回，先写临时文件再改名
+func (h *History) rewrite() {
+	if h.path == "" {
+		return
+	}
+	tmp := h.path + ".tmp"
+	f, err := openHistory(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC)
+	if err != nil {
+		h.report(err)
+		return
+	}
+	w := bufio.NewWriter(f)
+	for _, line := range h.entries {
+		fmt.Fprintln(w, line)
+	}
+	err = w.Flush()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, h.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		h.report(err)
+	}
+}
+
+// Clear 清空历史
+func (h *History) Clear() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = nil
+	h.rewrite()
+}
+
+// Expand 展开命令行中位于词首的 !!、!n、!-n、!prefix，单引号内不展开；
+// 返回展开后的命令行及是否发生了展开
+func (h *History) Expand(line string) (string, bool, error) {
+	if !strings.Contains(line, "!") {
+		return line, false, nil
+	}
+	entries := h.Entries()
+	var b strings.Builder
+	expanded := false
+	single := false
+	runes := []rune(line)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		switch {
+		case single:
+			single = r != '\''
+		case r == '\'':
+			single = true
+		case r == '\\' && i+1 < len(runes):
+			b.WriteRune(r)
+			i++
+			r = runes[i]
+		case r == '!' && i+1 < len(runes) && (i == 0 || unicode.IsSpace(runes[i-1])):
+			end := i + 1
+			for end < len(runes) && !unicode.IsSpace(runes[end]) {
+				end++
+			}
+			event := string(runes[i+1 : end])
+			if event == "" || event == "=" {
+				break
+			}
+			found, err := historyEvent(entries, event)
+			if err != nil {
+				return "", false, err
+			}
+			b.WriteString(found)
+			expanded = true
+			i = end - 1
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String(), expanded, nil
+}
+
+// historyEvent 查找一个事件：! 为上一条，数字为编号，负数为倒数第几条，其余为最近一条以它开头的命令
+func historyEvent(entries []string, event string) (string, error) {
+	switch {
+	case event == "!":
+		if len(entries) == 0 {
+			return "", fmt.Errorf("!!: 没有历史命令")
+		}
+		return entries[len(entries)-1], nil
+	case isHistoryNumber(event):
+		n, _ := strconv.Atoi(event)
+		if n < 0 {
+			n = len(entries) + 1 + n
+		}
+		if n < 1 || n > len(entries) {
+			return "", fmt.Errorf("!%s: 历史记录中没有该编号", event)
+		}
+		return entries[n-1], nil
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		if strings.HasPrefix(entries[i], event) {
+			return entries[i], nil
+		}
+	}
+	return "", fmt.Errorf("!%s: 未找到以 %s 开头的历史命令", event, event)
+}
+
+func isHistoryNumber(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// historyItem history 的一条输出
+type historyItem struct {
+	Num  int    `json:"num"`
+	Line string `json:"line"`
+}
+
+func writeHistory(w io.Writer, format OutputFormat, items []historyItem) error {
+	switch format {
+	case OutputJSON:
+		if items == nil {
+			items = []historyItem{}
+		}
+		return writeJSON(w, items)
+	case OutputTable:
+		rows := make([][]string, 0, len(items))
+		for _, it := range items {
+			rows = append(rows, []string{strconv.Itoa(it.Num), it.Line})
+		}
+		return writeTable(w, []string{"NUM", "LINE"}, rows)
+	}
+	for _, it := range items {
+		fmt.Fprintf(w, "%5d  %s\n", it.Num, it.Line)
+	}
+	return nil
+}
+
+// Builtin History
+type HistoryCommand struct {
+	shell *Shell
+}
+
+func NewHistoryCommand(s *Shell) *HistoryCommand {
+	return &HistoryCommand{shell: s}
+}
+
+func (c *HistoryCommand) Name() string     { return "history" }
+func (c *HistoryCommand) Category() string { return "sys" }
+func (c *HistoryCommand) Path() string     { return "" }
+func (c *HistoryCommand) IsBuiltin() bool  { return true }
+func (c *HistoryCommand) Desc() string     { return "查看、搜索或清空命令历史" }
+func (c *HistoryCommand) Usage() string {
+	return "history [N] | history search KEYWORD [-n N] | history clear"
+}
+func (c *HistoryCommand) Args() []string {
+	return []string{
+		"N 可选，只显示最后 N 条",
+		"在 REPL 中 !! 为上一条命令，!n 为编号 n 的命令，!-n 为倒数第 n 条，!prefix 为最近一条以 prefix 开头的命令",
+	}
+}
+func (c *HistoryCommand) Returns() []string {
+	return []string{"按编号列出历史命令"}
+}
+func (c *HistoryCommand) Flags() []string {
+	return []string{"-n N    # search 只显示最后 N 条匹配"}
+}
+func (c *HistoryCommand) Subcommands() []string {
+	return []string{
+		"search    # 按关键字搜索（不区分大小写）",
+		"clear     # 清空历史",
+	}
+}
+func (c *HistoryCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	limit := 0
+	var keyword string
+	search := false
+	switch {
+	case len(args) == 1:
+	case args[1] == "clear" && len(args) == 2:
+		c.shell.history.Clear()
+		fmt.Fprintln(stdio.Out, "✅ 已清空历史")
+		return nil
+	case args[1] == "search":
+		rest := args[2:]
+		for len(rest) > 0 {
+			if rest[0] == "-n" {
+				if len(rest) < 2 {
+					return fmt.Errorf("-n 缺少参数")
+				}
+				n, err := strconv.Atoi(rest[1])
+				if err != nil || n <= 0 {
+					return fmt.Errorf("非法数量: %s", rest[1])
+				}
+				limit = n
+				rest = rest[2:]
+				continue
+			}
+			if keyword != "" {
+				keyword += " "
+			}
+			keyword += rest[0]
+			rest = rest[1:]
+		}
+		if keyword == "" {
+			return fmt.Errorf("用法: %s", c.Usage())
+		}
+		search = true
+	case len(args) == 2:
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("用法: %s", c.Usage())
+		}
+		limit = n
+	default:
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+
+	var items []historyItem
+	lower := strings.ToLower(keyword)
+	for i, line := range c.shell.history.Entries() {
+		if search && !strings.Contains(strings.ToLower(line), lower) {
+			continue
+		}
+		items = append(items, historyItem{Num: i + 1, Line: line})
+	}
+	if limit > 0 && len(items) > limit {
+		items = items[len(items)-limit:]
+	}
+	return writeHistory(stdio.Out, c.shell.OutputFormat(), items)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHistoryFile(t *testing.T) {
+	homeDir = t.TempDir()
+	h := NewHistory()
+	if err := h.Configure(HistoryConfig{File: "~/a/b/history"}); err != nil {
+		t.Fatal(err)
+	}
+	h.Add("echo hi")
+	data, err := os.ReadFile(filepath.Join(homeDir, "a", "b", "history"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "echo hi\n" {
+		t.Errorf("历史文件内容 = %q", data)
+	}
+
+	// 所在目录无法创建时只提示一次，记录仍保留在内存中
+	blocker := filepath.Join(homeDir, "blocker")
+	if err := os.WriteFile(blocker, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Configure(HistoryConfig{File: filepath.Join(blocker, "history")}); err != nil {
+		t.Fatal(err)
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr := os.Stderr
+	os.Stderr = w
+	h.Add("one")
+	h.Add("two")
+	os.Stderr = stderr
+	w.Close()
+	out, _ := io.ReadAll(r)
+	if n := strings.Count(string(out), "写入历史文件失败"); n != 1 {
+		t.Errorf("提示了 %d 次写入失败:\n%s", n, out)
+	}
+	if got := h.Entries(); len(got) != 2 || got[1] != "two" {
+		t.Errorf("Entries = %q", got)
+	}
+}
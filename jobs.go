@@ -0,0 +1,754 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// JobState 作业状态
+type JobState int
+
+const (
+	JobRunning JobState = iota
+	JobStopped
+	JobDone
+)
+
+func (s JobState) String() string {
+	switch s {
+	case JobRunning:
+		return "运行中"
+	case JobStopped:
+		return "已停止"
+	default:
+		return "已完成"
+	}
+}
+
+// exitCodeError 外部命令或作业以非零状态结束
+type exitCodeError struct {
+	code   int
+	rusage *syscall.Rusage // 作业中的外部命令结束时的资源用量，其他情况为 nil
+}
+
+func (e *exitCodeError) Error() string { return fmt.Sprintf("exit status %d", e.code) }
+
+// Job 一条管道对应一个作业，其中的外部命令共享同一个进程组
+type Job struct {
+	ID      int
+	Cmdline string
+
+	mu         sync.Mutex
+	cond       *sync.Cond
+	startMu    sync.Mutex   // 串行启动进程，保证加入同一进程组
+	pgid       int          // 进程组，首个外部命令启动后确定
+	procs      map[int]bool // pid -> 是否已停止
+	state      JobState
+	status     int
+	foreground bool
+	terminal   bool // 前台作业是否接管终端
+	notified   bool
+}
+
+func newJob(id int, cmdline string, foreground, terminal bool) *Job {
+	j := &Job{
+		ID:         id,
+		Cmdline:    cmdline,
+		procs:      make(map[int]bool),
+		foreground: foreground,
+		terminal:   terminal,
+	}
+	j.cond = sync.NewCond(&j.mu)
+	return j
+}
+
+// Pgid 返回作业进程组，尚未启动外部命令时为 0
+func (j *Job) Pgid() int {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.pgid
+}
+
+// State 返回作业状态与退出码
+func (j *Job) State() (JobState, int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.state, j.status
+}
+
+// run 在作业进程组中启动外部命令并等待其结束，onStart 不为 nil 时在进程启动后调用
+func (j *Job) run(cmd *exec.Cmd, onStart func(pid int)) error {
+	closeFiles, err := fileStdio(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeFiles()
+
+	j.startMu.Lock()
+	pgid := j.Pgid()
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+	cmd.SysProcAttr.Pgid = pgid
+	if pgid == 0 && j.terminal {
+		// 子进程在 exec 前把自己的进程组设为终端前台，Ctrl-C / Ctrl-Z 直接送达
+		cmd.SysProcAttr.Foreground = true
+		cmd.SysProcAttr.Ctty = int(os.Stdin.Fd())
+	}
+	if err := cmd.Start(); err != nil {
+		j.startMu.Unlock()
+		return err
+	}
+	pid := cmd.Process.Pid
+	j.mu.Lock()
+	if j.pgid == 0 {
+		j.pgid = pid
+	}
+	j.procs[pid] = false
+	j.mu.Unlock()
+	j.startMu.Unlock()
+	if onStart != nil {
+		onStart(pid)
+	}
+
+	ws, ru := j.waitProcess(pid)
+	cmd.Process.Release()
+	switch {
+	case ws.Exited() && ws.ExitStatus() == 0:
+		return nil
+	case ws.Exited():
+		return &exitCodeError{code: ws.ExitStatus(), rusage: ru}
+	case ws.Signaled():
+		return &exitCodeError{code: 128 + int(ws.Signal()), rusage: ru}
+	}
+	return &exitCodeError{code: 1, rusage: ru}
+}
+
+// waitProcess 等待进程结束，期间记录停止 / 继续事件；返回结束状态与资源用量
+func (j *Job) waitProcess(pid int) (syscall.WaitStatus, *syscall.Rusage) {
+	for {
+		var (
+			ws syscall.WaitStatus
+			ru syscall.Rusage
+		)
+		_, err := syscall.Wait4(pid, &ws, syscall.WUNTRACED|syscall.WCONTINUED, &ru)
+		if err == syscall.EINTR {
+			continue
+		}
+		j.mu.Lock()
+		switch {
+		case err == nil && ws.Stopped():
+			j.procs[pid] = true
+		case err == nil && ws.Continued():
+			j.procs[pid] = false
+		default:
+			delete(j.procs, pid)
+			if len(j.procs) == 0 {
+				// 进程组已空，后续命令（如函数的下一行）自建新的进程组
+				j.pgid = 0
+			}
+			j.updateLocked()
+			j.mu.Unlock()
+			return ws, &ru
+		}
+		j.updateLocked()
+		j.mu.Unlock()
+	}
+}
+
+// updateLocked 所有存活进程都停止时作业进入停止状态
+func (j *Job) updateLocked() {
+	if j.state == JobDone {
+		return
+	}
+	stopped := len(j.procs) > 0
+	for _, s := range j.procs {
+		stopped = stopped && s
+	}
+	if stopped {
+		j.state = JobStopped
+	} else {
+		j.state = JobRunning
+	}
+	j.cond.Broadcast()
+}
+
+// finish 管道全部结束后由作业协程调用
+func (j *Job) finish(status int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.state = JobDone
+	j.status = status
+	j.cond.Broadcast()
+}
+
+// wait 阻塞到作业结束或停止
+func (j *Job) wait() (JobState, int) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for j.state == JobRunning {
+		j.cond.Wait()
+	}
+	return j.state, j.status
+}
+
+// signal 向整个进程组发送信号
+func (j *Job) signal(sig syscall.Signal) error {
+	pgid := j.Pgid()
+	if pgid == 0 {
+		return fmt.Errorf("作业 %%%d 没有外部进程", j.ID)
+	}
+	return syscall.Kill(-pgid, sig)
+}
+
+// resume 发送 SIGCONT 继续运行
+func (j *Job) resume(foreground bool) error {
+	j.mu.Lock()
+	j.foreground = foreground
+	for pid := range j.procs {
+		j.procs[pid] = false
+	}
+	if j.state == JobStopped {
+		j.state = JobRunning
+	}
+	j.mu.Unlock()
+	if j.Pgid() == 0 {
+		return nil
+	}
+	return j.signal(syscall.SIGCONT)
+}
+
+// fileStdio 外部命令的输入输出不是文件时用管道中转，
+// 这样进程结束后由 wait4 回收即可，不依赖 exec.Cmd.Wait 的拷贝协程
+func fileStdio(cmd *exec.Cmd) (func(), error) {
+	var (
+		closers []io.Closer
+		copies  sync.WaitGroup
+	)
+	done := func() {
+		for _, c := range closers {
+			c.Close()
+		}
+		copies.Wait()
+	}
+	if cmd.Stdin != nil {
+		if _, ok := cmd.Stdin.(*os.File); !ok {
+			pr, pw, err := os.Pipe()
+			if err != nil {
+				return done, err
+			}
+			src := cmd.Stdin
+			go func() {
+				io.Copy(pw, src)
+				pw.Close()
+			}()
+			cmd.Stdin = pr
+			closers = append(closers, pr)
+		}
+	}
+	for _, out := range []*io.Writer{&cmd.Stdout, &cmd.Stderr} {
+		if *out == nil {
+			continue
+		}
+		if _, ok := (*out).(*os.File); ok {
+			continue
+		}
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return done, err
+		}
+		dst := *out
+		copies.Add(1)
+		go func() {
+			defer copies.Done()
+			io.Copy(dst, pr)
+			pr.Close()
+		}()
+		*out = pw
+		closers = append(closers, pw)
+	}
+	return done, nil
+}
+
+// JobTable 作业表
+type JobTable struct {
+	mu   sync.Mutex
+	jobs map[int]*Job
+}
+
+func NewJobTable() *JobTable {
+	return &JobTable{jobs: make(map[int]*Job)}
+}
+
+// add 新建作业，编号为当前最大编号 +1
+func (t *JobTable) add(cmdline string, foreground, terminal bool) *Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	id := 1
+	for existing := range t.jobs {
+		if existing >= id {
+			id = existing + 1
+		}
+	}
+	j := newJob(id, cmdline, foreground, terminal)
+	t.jobs[id] = j
+	return j
+}
+
+func (t *JobTable) remove(j *Job) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.jobs, j.ID)
+}
+
+// list 按编号返回全部作业
+func (t *JobTable) list() []*Job {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	jobs := make([]*Job, 0, len(t.jobs))
+	for _, j := range t.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].ID < jobs[b].ID })
+	return jobs
+}
+
+// foregroundJob 返回正在前台运行的作业
+func (t *JobTable) foregroundJob() *Job {
+	for _, j := range t.list() {
+		j.mu.Lock()
+		fg := j.foreground && j.state == JobRunning
+		j.mu.Unlock()
+		if fg {
+			return j
+		}
+	}
+	return nil
+}
+
+// find 解析 %n / %% / %+ / %-，spec 为空时取最近的后台作业
+func (t *JobTable) find(spec string) (*Job, error) {
+	var candidates []*Job
+	for _, j := range t.list() {
+		j.mu.Lock()
+		bg := !j.foreground
+		j.mu.Unlock()
+		if bg {
+			candidates = append(candidates, j)
+		}
+	}
+	switch spec {
+	case "", "%", "%%", "%+":
+		if len(candidates) == 0 {
+			return nil, errors.New("没有当前作业")
+		}
+		return candidates[len(candidates)-1], nil
+	case "%-":
+		if len(candidates) < 2 {
+			return nil, errors.New("没有上一个作业")
+		}
+		return candidates[len(candidates)-2], nil
+	}
+	id, err := strconv.Atoi(strings.TrimPrefix(spec, "%"))
+	if err != nil {
+		return nil, fmt.Errorf("非法作业号: %s", spec)
+	}
+	t.mu.Lock()
+	j, ok := t.jobs[id]
+	t.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("作业 %%%d 不存在", id)
+	}
+	return j, nil
+}
+
+// notifyDone 打印并移除已结束的后台作业，REPL 在每次提示符前调用
+func (t *JobTable) notifyDone(w io.Writer) {
+	for _, j := range t.list() {
+		j.mu.Lock()
+		done := j.state == JobDone && !j.foreground && !j.notified
+		status := j.status
+		j.mu.Unlock()
+		if !done {
+			continue
+		}
+		if status == 0 {
+			fmt.Fprintf(w, "[%d]+ 已完成              %s\n", j.ID, j.Cmdline)
+		} else {
+			fmt.Fprintf(w, "[%d]+ 已退出 %-3d          %s\n", j.ID, status, j.Cmdline)
+		}
+		t.remove(j)
+	}
+}
+
+// runJob 以作业方式执行一段管道，后台作业立即返回 0
+// 别名与函数体中的前台命令直接归入调用者所在的作业
+func (s *Shell) runJob(item chainItem, stdio *Stdio) int {
+	if s.shouldPage(item, stdio) {
+		// 作业结束、收回终端之后再分页，分页时需要读取按键
+		var buf lockedBuffer
+		st := *stdio
+		st.Out = &buf
+		status := s.runJob(item, &st)
+		pageOutput(os.Stdout, buf.Bytes())
+		return status
+	}
+	if stdio.Job != nil && !item.background {
+		return s.runPipeline(item.pipe, stdio)
+	}
+	job := s.jobs.add(item.pipe.String(), !item.background, !item.background && s.interactive)
+	st := *stdio
+	st.Job = job
+	if item.background {
+		// 后台作业不读取终端
+		if devnull, err := os.Open(os.DevNull); err == nil {
+			defer devnull.Close()
+			st.In = devnull
+		}
+	}
+
+	started := make(chan struct{})
+	go func() {
+		close(started)
+		job.finish(s.runPipeline(item.pipe, &st))
+	}()
+	<-started
+	if item.background {
+		fmt.Fprintf(stdio.Err, "[%d] %s\n", job.ID, job.Cmdline)
+		return 0
+	}
+	return s.waitForeground(job)
+}
+
+// waitForeground 等待前台作业结束或被停止，之后收回终端
+func (s *Shell) waitForeground(job *Job) int {
+	state, status := job.wait()
+	if s.interactive {
+		reclaimTerminal()
+	}
+	if state == JobStopped {
+		job.mu.Lock()
+		job.foreground = false
+		job.mu.Unlock()
+		fmt.Fprintf(os.Stderr, "\n[%d]+ 已停止              %s\n", job.ID, job.Cmdline)
+		return 128 + int(syscall.SIGTSTP)
+	}
+	s.jobs.remove(job)
+	return status
+}
+
+// reclaimTerminal 把终端前台进程组交还给 flyos
+func reclaimTerminal() {
+	// 后台进程组调用 TIOCSPGRP 会收到 SIGTTOU，调用期间忽略它
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	setForegroundPgrp(syscall.Getpgrp())
+}
+
+func setForegroundPgrp(pgid int) {
+	syscall.Syscall(syscall.SYS_IOCTL, os.Stdin.Fd(), uintptr(syscall.TIOCSPGRP), uintptr(unsafe.Pointer(&pgid)))
+}
+
+// forwardSignals flyos 收到的 Ctrl-C / Ctrl-\ / Ctrl-Z 转发给前台作业，
+// 接管终端的作业会直接从终端收到这些信号
+func (s *Shell) forwardSignals() {
+	ch := make(chan os.Signal, 8)
+	signal.Notify(ch, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTSTP)
+	go func() {
+		for sig := range ch {
+			if job := s.jobs.foregroundJob(); job != nil && job.Pgid() != 0 {
+				job.signal(sig.(syscall.Signal))
+				continue
+			}
+			if sig == syscall.SIGINT && !s.interactive {
+				os.Exit(130)
+			}
+		}
+	}()
+}
+
+// parseJobArgs 将 %n 参数解析为作业，未指定时取当前作业
+func (s *Shell) parseJobArgs(args []string) ([]*Job, error) {
+	if len(args) == 0 {
+		j, err := s.jobs.find("")
+		if err != nil {
+			return nil, err
+		}
+		return []*Job{j}, nil
+	}
+	var jobs []*Job
+	for _, a := range args {
+		j, err := s.jobs.find(a)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+	return jobs, nil
+}
+
+// Builtin Jobs
+type JobsCommand struct {
+	shell *Shell
+}
+
+func NewJobsCommand(s *Shell) *JobsCommand {
+	return &JobsCommand{shell: s}
+}
+
+func (c *JobsCommand) Name() string          { return "jobs" }
+func (c *JobsCommand) Category() string      { return "sys" }
+func (c *JobsCommand) Path() string          { return "" }
+func (c *JobsCommand) IsBuiltin() bool       { return true }
+func (c *JobsCommand) Desc() string          { return "列出后台与已停止的作业" }
+func (c *JobsCommand) Usage() string         { return "jobs [-l]" }
+func (c *JobsCommand) Args() []string        { return nil }
+func (c *JobsCommand) Returns() []string     { return []string{"作业号、状态与命令行"} }
+func (c *JobsCommand) Flags() []string       { return []string{"-l    # 同时显示进程组号"} }
+func (c *JobsCommand) Subcommands() []string { return nil }
+func (c *JobsCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	long := len(args) > 1 && args[1] == "-l"
+	for _, j := range c.shell.jobs.list() {
+		if j == stdio.Job {
+			continue
+		}
+		j.mu.Lock()
+		fg, state, status := j.foreground, j.state, j.status
+		j.mu.Unlock()
+		if fg {
+			continue
+		}
+		label := state.String()
+		if state == JobDone && status != 0 {
+			label = fmt.Sprintf("已退出 %d", status)
+		}
+		if long {
+			fmt.Fprintf(stdio.Out, "[%d] %-8d %-12s %s\n", j.ID, j.Pgid(), label, j.Cmdline)
+		} else {
+			fmt.Fprintf(stdio.Out, "[%d] %-12s %s\n", j.ID, label, j.Cmdline)
+		}
+		if state == JobDone {
+			c.shell.jobs.remove(j)
+		}
+	}
+	return nil
+}
+
+// Builtin Fg
+type FgCommand struct {
+	shell *Shell
+}
+
+func NewFgCommand(s *Shell) *FgCommand {
+	return &FgCommand{shell: s}
+}
+
+func (c *FgCommand) Name() string          { return "fg" }
+func (c *FgCommand) Category() string      { return "sys" }
+func (c *FgCommand) Path() string          { return "" }
+func (c *FgCommand) IsBuiltin() bool       { return true }
+func (c *FgCommand) Desc() string          { return "将作业切换到前台继续运行" }
+func (c *FgCommand) Usage() string         { return "fg [%n]" }
+func (c *FgCommand) Args() []string        { return []string{"%n 可选，作业号，默认为当前作业"} }
+func (c *FgCommand) Returns() []string     { return []string{"作业的退出码"} }
+func (c *FgCommand) Flags() []string       { return nil }
+func (c *FgCommand) Subcommands() []string { return nil }
+func (c *FgCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	jobs, err := c.shell.parseJobArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	job := jobs[0]
+	fmt.Fprintln(stdio.Err, job.Cmdline)
+	if c.shell.interactive && job.Pgid() != 0 {
+		setForegroundPgrp(job.Pgid())
+	}
+	if err := job.resume(true); err != nil {
+		return err
+	}
+	if status := c.shell.waitForeground(job); status != 0 {
+		return &exitCodeError{code: status}
+	}
+	return nil
+}
+
+// Builtin Bg
+type BgCommand struct {
+	shell *Shell
+}
+
+func NewBgCommand(s *Shell) *BgCommand {
+	return &BgCommand{shell: s}
+}
+
+func (c *BgCommand) Name() string          { return "bg" }
+func (c *BgCommand) Category() string      { return "sys" }
+func (c *BgCommand) Path() string          { return "" }
+func (c *BgCommand) IsBuiltin() bool       { return true }
+func (c *BgCommand) Desc() string          { return "让已停止的作业在后台继续运行" }
+func (c *BgCommand) Usage() string         { return "bg [%n...]" }
+func (c *BgCommand) Args() []string        { return []string{"%n 可选，作业号，默认为当前作业"} }
+func (c *BgCommand) Returns() []string     { return []string{"作业在后台继续运行"} }
+func (c *BgCommand) Flags() []string       { return nil }
+func (c *BgCommand) Subcommands() []string { return nil }
+func (c *BgCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	jobs, err := c.shell.parseJobArgs(args[1:])
+	if err != nil {
+		return err
+	}
+	for _, job := range jobs {
+		if err := job.resume(false); err != nil {
+			return err
+		}
+		fmt.Fprintf(stdio.Err, "[%d]+ %s &\n", job.ID, job.Cmdline)
+	}
+	return nil
+}
+
+// Builtin Kill
+type KillCommand struct {
+	shell *Shell
+}
+
+func NewKillCommand(s *Shell) *KillCommand {
+	return &KillCommand{shell: s}
+}
+
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"KILL": syscall.SIGKILL,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+	"TERM": syscall.SIGTERM,
+	"CONT": syscall.SIGCONT,
+	"STOP": syscall.SIGSTOP,
+	"TSTP": syscall.SIGTSTP,
+}
+
+// parseSignal 解析 -9 / -KILL / -SIGKILL
+func parseSignal(s string) (syscall.Signal, error) {
+	s = strings.TrimPrefix(strings.ToUpper(s), "SIG")
+	if n, err := strconv.Atoi(s); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	if sig, ok := signalNames[s]; ok {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("未知信号: %s", s)
+}
+
+func (c *KillCommand) Name() string      { return "kill" }
+func (c *KillCommand) Category() string  { return "sys" }
+func (c *KillCommand) Path() string      { return "" }
+func (c *KillCommand) IsBuiltin() bool   { return true }
+func (c *KillCommand) Desc() string      { return "向作业的进程组或指定进程发送信号" }
+func (c *KillCommand) Usage() string     { return "kill [-SIGNAL] %n|PID..." }
+func (c *KillCommand) Args() []string    { return []string{"%n 作业号，或 PID 进程号"} }
+func (c *KillCommand) Returns() []string { return []string{"发送信号，默认 TERM"} }
+func (c *KillCommand) Flags() []string {
+	return []string{"-SIGNAL    # 信号名或编号，如 -9、-KILL、-STOP"}
+}
+func (c *KillCommand) Subcommands() []string { return nil }
+func (c *KillCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	sig := syscall.SIGTERM
+	targets := args[1:]
+	if len(targets) > 0 && strings.HasPrefix(targets[0], "-") {
+		var err error
+		if sig, err = parseSignal(targets[0][1:]); err != nil {
+			return err
+		}
+		targets = targets[1:]
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	for _, t := range targets {
+		if strings.HasPrefix(t, "%") {
+			job, err := c.shell.jobs.find(t)
+			if err != nil {
+				return err
+			}
+			if err := job.signal(sig); err != nil {
+				return err
+			}
+			// 已停止的作业需要继续运行才能处理信号，对运行中的进程发送 SIGCONT 无副作用
+			if sig != syscall.SIGSTOP && sig != syscall.SIGTSTP && sig != syscall.SIGCONT {
+				job.resume(false)
+			}
+			continue
+		}
+		pid, err := strconv.Atoi(t)
+		if err != nil {
+			return fmt.Errorf("非法参数: %s", t)
+		}
+		if err := syscall.Kill(pid, sig); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Builtin Wait
+type WaitCommand struct {
+	shell *Shell
+}
+
+func NewWaitCommand(s *Shell) *WaitCommand {
+	return &WaitCommand{shell: s}
+}
+
+func (c *WaitCommand) Name() string          { return "wait" }
+func (c *WaitCommand) Category() string      { return "sys" }
+func (c *WaitCommand) Path() string          { return "" }
+func (c *WaitCommand) IsBuiltin() bool       { return true }
+func (c *WaitCommand) Desc() string          { return "等待后台作业结束" }
+func (c *WaitCommand) Usage() string         { return "wait [%n...]" }
+func (c *WaitCommand) Args() []string        { return []string{"%n 可选，默认等待全部后台作业"} }
+func (c *WaitCommand) Returns() []string     { return []string{"最后一个作业的退出码"} }
+func (c *WaitCommand) Flags() []string       { return nil }
+func (c *WaitCommand) Subcommands() []string { return nil }
+func (c *WaitCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	var jobs []*Job
+	if len(args) > 1 {
+		var err error
+		if jobs, err = c.shell.parseJobArgs(args[1:]); err != nil {
+			return err
+		}
+	} else {
+		for _, j := range c.shell.jobs.list() {
+			j.mu.Lock()
+			bg := !j.foreground
+			j.mu.Unlock()
+			if bg && j != stdio.Job {
+				jobs = append(jobs, j)
+			}
+		}
+	}
+	status := 0
+	for _, j := range jobs {
+		var state JobState
+		state, status = j.wait()
+		if state == JobStopped {
+			status = 128 + int(syscall.SIGTSTP)
+			continue
+		}
+		c.shell.jobs.remove(j)
+	}
+	if status != 0 {
+		return &exitCodeError{code: status}
+	}
+	return nil
+}
@@ -3,16 +3,21 @@ package main
 
 import (
 	"context"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"sort"
 	"strings"
+	"syscall"
 	"time"
 
+	"flyos/pkg/runtime/eventbus"
+
 	"github.com/chzyer/readline"
 	"github.com/fsnotify/fsnotify"
-	"github.com/pelletier/go-toml/v2"
 )
 
 // env merge
@@ -44,11 +49,33 @@ func init() {
 	}
 }
 
+// quiet 为 true 时（脚本模式）不打印启动与加载提示，保持标准输出干净
+var quiet bool
+
+func logInfo(format string, a ...any) {
+	if !quiet {
+		fmt.Printf(format, a...)
+	}
+}
+
+// mergeEnv 以进程环境为基础，custom 中的同名变量覆盖原值
 func mergeEnv(custom map[string]string) []string {
-	env := make([]string, len(baseEnv))
-	copy(env, baseEnv)
-	for k, v := range custom {
-		env = append(env, k+"="+v)
+	env := make([]string, 0, len(baseEnv)+len(custom))
+	for _, kv := range baseEnv {
+		if k, _, ok := strings.Cut(kv, "="); ok {
+			if _, override := custom[k]; override {
+				continue
+			}
+		}
+		env = append(env, kv)
+	}
+	keys := make([]string, 0, len(custom))
+	for k := range custom {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+custom[k])
 	}
 	return env
 }
@@ -57,7 +84,13 @@ func mergeEnv(custom map[string]string) []string {
 type Config struct {
 	CommandsDirs []string               `toml:"commands_dirs"`
 	Excludes     []string               `toml:"excludes"`
-	Env          map[string]interface{} `toml:"env"` // 允许值为 string 或 []string
+	Env          map[string]interface{} `toml:"env"`           // 允许值为 string 或 []string
+	Aliases      map[string]interface{} `toml:"aliases"`       // 别名，见 NormalizeAliases
+	Functions    map[string]interface{} `toml:"functions"`     // 用户函数，见 NormalizeFunctions
+	Audit        AuditConfig            `toml:"audit"`         // 审计日志
+	RBAC         RBACConfig             `toml:"rbac"`          // 基于角色的命令授权
+	SelfDescribe bool                   `toml:"self_describe"` // 加载时以 --flyos-describe 获取命令描述
+	History      HistoryConfig          `toml:"history"`       // REPL 命令历史
 }
 
 func (c *Config) NormalizeEnv() map[string]string {
@@ -87,19 +120,6 @@ func (c *Config) NormalizeEnv() map[string]string {
 	return result
 }
 
-// Config
-func parseConfig(cfgPath string) (*Config, error) {
-	data, err := os.ReadFile(cfgPath)
-	if err != nil {
-		return nil, err
-	}
-	var cfg Config
-	if err := toml.Unmarshal(data, &cfg); err != nil {
-		return nil, err
-	}
-	return &cfg, nil
-}
-
 // REPL
 type REPL struct {
 	shell *Shell
@@ -108,106 +128,262 @@ type REPL struct {
 }
 
 func NewREPL(shell *Shell, desc *DescManager) (*REPL, error) {
+	// 历史由 History 读写文件并过滤，readline 只保存在内存中供上下键与 Ctrl-R 使用
 	l, err := readline.NewEx(&readline.Config{
-		Prompt:      "flyos> ",
-		HistoryFile: "/tmp/flyos_history",
+		Prompt:                 "flyos> ",
+		HistoryLimit:           shell.history.Limit(),
+		DisableAutoSaveHistory: true,
+		AutoComplete:           NewCompleter(shell, desc),
 	})
 	if err != nil {
 		return nil, err
 	}
+	for _, line := range shell.history.Entries() {
+		_ = l.SaveHistory(line)
+	}
 	return &REPL{shell: shell, desc: desc, rl: l}, nil
 }
 
 func (r *REPL) Loop() {
 	defer r.rl.Close()
 	for {
+		r.shell.jobs.notifyDone(os.Stdout)
+		r.rl.SetPrompt(r.shell.configPrompt())
 		line, err := r.rl.Readline()
 		if err != nil {
 			break
 		}
-		args := strings.Fields(strings.TrimSpace(line))
-		if len(args) == 0 {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		expanded, ok, err := r.shell.history.Expand(line)
+		if err != nil {
+			fmt.Printf("⚠️ %v\n", err)
 			continue
 		}
-		switch args[0] {
-		case "exit":
+		if ok {
+			// 与 bash 一样先回显展开后的命令
+			fmt.Println(expanded)
+			line = expanded
+		}
+		block := isDSLLine(line)
+		if block {
+			src, ok := r.readBlock(line)
+			if !ok {
+				continue
+			}
+			line = src
+		}
+		// 跨行的 DSL 块不进入历史，History.Add 拒绝含换行的输入
+		if r.shell.history.Add(line) {
+			_ = r.rl.SaveHistory(strings.TrimSpace(line))
+		}
+		if block {
+			r.shell.RunDSL(line, "", 1)
+		} else {
+			r.shell.RunLine(line)
+		}
+		if r.shell.Exited() {
 			fmt.Println("👋 Bye!")
 			return
-		case "list":
-			r.shell.List()
-		default:
-			r.shell.RunCommand(args)
 		}
 	}
 }
 
+// readBlock 读取 DSL 续行直到花括号配平，Ctrl-C 或 Ctrl-D 放弃整段输入
+func (r *REPL) readBlock(first string) (string, bool) {
+	src := first + "\n"
+	if dslDepth(src) <= 0 {
+		return src, true
+	}
+	r.rl.SetPrompt("  ...> ")
+	for dslDepth(src) > 0 {
+		line, err := r.rl.Readline()
+		if err != nil {
+			fmt.Println("⚠️ 已放弃未完成的 DSL 输入")
+			return "", false
+		}
+		src += line + "\n"
+	}
+	return src, true
+}
+
+// loadDesc 读取用户与系统的 desc.toml，用户的文件不存在时不提示
+func loadDesc(desc *DescManager, path string) {
+	if err := desc.Load(path); err != nil && !os.IsNotExist(err) {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	desc.LoadTrusted(systemDescPath)
+}
+
 // Main
 func main() {
+	command := flag.String("c", "", "执行命令字符串后退出")
+	script := flag.String("f", "", "执行脚本文件后退出，- 表示从标准输入读取")
+	output := flag.String("output", "", "list、help、env 的输出格式：text、table 或 json")
+	watchdog := flag.Bool("confirm-watchdog", false, "内部使用：在后台等待 commit confirmed 到期并回滚")
+	flag.Parse()
+	quiet = *command != "" || *script != "" || *watchdog
+
 	flyosDir := filepath.Join(homeDir, ".flyos")
-	cfgPath := filepath.Join(flyosDir, "config.toml")
 	descPath := filepath.Join(flyosDir, "desc.toml")
 
-	cfg, err := parseConfig(cfgPath)
+	// 分层配置：缺少的层跳过，全部缺失时使用空配置
+	layered, err := loadConfig()
 	if err != nil {
-		fmt.Printf("❌ 启动失败: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "❌ 启动失败: %v\n", err)
+		os.Exit(1)
 	}
+	cfg := layered.Config
 	if err := os.MkdirAll(flyosDir, 0755); err != nil {
-		fmt.Printf("创建配置目录失败: %v\n", err)
-		return
+		fmt.Fprintf(os.Stderr, "创建配置目录失败: %v\n", err)
+		os.Exit(1)
 	}
 
 	envMap := cfg.NormalizeEnv()
 	shell := NewShell(envMap)
-	shell.env["USER"] = "fly"
-	shell.env["VERSION"] = "1.0.0"
+	shell.events = eventbus.Default()
+	shell.SetVar("USER", "fly", true)
+	shell.SetVar("VERSION", "1.0.0", true)
+	if *output != "" {
+		if _, err := ParseOutputFormat(*output); err != nil {
+			fmt.Fprintf(os.Stderr, "❌ 启动失败: %v\n", err)
+			os.Exit(2)
+		}
+		shell.SetVar(outputVar, *output, false)
+	}
 	// 内置命令注册
-	shell.Register(&EnvCommand{})
+	shell.Register(NewEnvCommand(shell))
 	shell.Register(&ExitCommand{})
-	shell.Register(&ListCommand{})
+	shell.Register(NewListCommand(shell))
+	shell.Register(NewSetCommand(shell))
+	shell.Register(NewUnsetCommand(shell))
+	shell.Register(NewExportCommand(shell))
+	shell.Register(NewJobsCommand(shell))
+	shell.Register(NewFgCommand(shell))
+	shell.Register(NewBgCommand(shell))
+	shell.Register(NewKillCommand(shell))
+	shell.Register(NewWaitCommand(shell))
+	shell.Register(NewAuditCommand(shell))
+	shell.Register(NewHistoryCommand(shell))
+	shell.Register(NewConfigureCommand(shell))
+	shell.Register(NewShowCommand(shell))
+	shell.Register(NewCommitCommand(shell))
+	shell.Register(NewDiscardCommand(shell))
+	shell.Register(NewRollbackCommand(shell))
+	cfgCmd := NewConfigCommand(shell, layered)
+	shell.Register(cfgCmd)
 
 	desc := NewDescManager()
-	_ = desc.Load(descPath)
+	desc.isCommand = shell.isCommand
 
 	// 注册 HelpCommand（关键）
 	helpCmd := NewHelpCommand(desc, shell)
 	shell.Register(helpCmd)
+	shell.Register(NewDescCommand(shell, desc))
 	shell.LoadCommands(cfg, desc)
+	// desc.toml 中带 . 的命令名按已注册的命令解析，注册外部命令之后再读取，并按其中的分类更新外部命令
+	loadDesc(desc, descPath)
+	shell.reconcileCommands(cfg, desc)
+	if err := shell.audit.Configure(cfg.Audit); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 审计日志配置错误: %v\n", err)
+	}
+	shell.auth.Configure(cfg.RBAC)
+	if err := shell.history.Configure(cfg.History); err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 历史配置错误: %v\n", err)
+	}
+
+	// 交互终端下前台作业接管终端，Ctrl-C / Ctrl-Z 只作用于作业
+	shell.interactive = !quiet && readline.IsTerminal(int(os.Stdin.Fd()))
+	shell.forwardSignals()
+
+	if *watchdog {
+		os.Exit(shell.confirmWatchdog())
+	}
+
+	// 继续上次进程留下的 commit confirmed 计时；会话断开时未确认的提交留给看门狗到期回滚
+	shell.resumeConfirm()
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP, syscall.SIGTERM)
+	go func() {
+		sig := <-hup
+		shell.endSession()
+		os.Exit(128 + int(sig.(syscall.Signal)))
+	}()
+
+	// 非交互模式：不启动 readline 与文件监听，退出码反映执行结果
+	if quiet {
+		code := runScriptMode(shell, *command, *script)
+		shell.endSession()
+		os.Exit(code)
+	}
 
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// 监听 commands_dirs，外部命令随文件变化增删
+	cmdWatcher, err := NewCommandWatcher(shell, desc, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "⚠️ 无法监听命令目录: %v\n", err)
+	} else {
+		go cmdWatcher.Run(ctx)
+	}
+
 	// fsnotify 监听
 	go func() {
 		watcher, _ := fsnotify.NewWatcher()
 		defer watcher.Close()
-		_ = watcher.Add(flyosDir)
+		// 各配置层所在目录及其 conf.d
+		for _, dir := range configDirs() {
+			_ = watcher.Add(dir)
+			_ = watcher.Add(filepath.Join(dir, "conf.d"))
+		}
 		var debounce *time.Timer
 		for {
 			select {
 			case ev := <-watcher.Events:
-				switch filepath.Base(ev.Name) {
-				case "config.toml":
-					if ev.Op&fsnotify.Write != 0 {
+				if ev.Op&fsnotify.Create != 0 && filepath.Base(ev.Name) == "conf.d" {
+					_ = watcher.Add(ev.Name)
+				}
+				switch {
+				case isConfigFile(ev.Name) || filepath.Base(ev.Name) == "conf.d":
+					if ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Remove|fsnotify.Rename) != 0 {
 						if debounce != nil {
 							debounce.Stop()
 						}
 						debounce = time.AfterFunc(300*time.Millisecond, func() {
-							cfg, err := parseConfig(cfgPath)
+							layered, err := loadConfig()
 							if err != nil {
 								fmt.Println("❌ reload config failed:", err)
 								return
 							}
-							shell.LoadCommands(cfg, desc)
+							cfgCmd.Set(layered)
+							cfg := layered.Config
+							if cmdWatcher != nil {
+								cmdWatcher.SetConfig(cfg)
+							} else {
+								shell.LoadCommands(cfg, desc)
+							}
+							if err := shell.audit.Configure(cfg.Audit); err != nil {
+								fmt.Println("⚠️ 审计日志配置错误:", err)
+							}
+							shell.auth.Configure(cfg.RBAC)
+							if err := shell.history.Configure(cfg.History); err != nil {
+								fmt.Println("⚠️ 历史配置错误:", err)
+							}
 						})
 					}
-				case "desc.toml":
+				case ev.Name == descPath:
 					if ev.Op&fsnotify.Write != 0 {
 						if debounce != nil {
 							debounce.Stop()
 						}
 						debounce = time.AfterFunc(300*time.Millisecond, func() {
-							_ = desc.Load(descPath)
+							loadDesc(desc, descPath)
+							// 分类可能变化
+							if cmdWatcher != nil {
+								cmdWatcher.Reconcile()
+							}
 						})
 					}
 				}
@@ -223,4 +399,5 @@ func main() {
 	}
 	fmt.Println("🚀 FlyOS REPL 已启动！💡 输入 help 查看命令，输入 exit 安全退出 ")
 	repl.Loop()
+	shell.endSession()
 }
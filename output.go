@@ -0,0 +1,362 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// OutputFormat list / help / env 的输出格式
+type OutputFormat string
+
+const (
+	OutputText  OutputFormat = "text"  // 默认，带图标的可读文本
+	OutputTable OutputFormat = "table" // 无装饰的对齐表格，便于 grep / awk
+	OutputJSON  OutputFormat = "json"  // 机器可读，字段名与结构保持稳定
+)
+
+// outputVar 会话变量，set FLYOS_OUTPUT=json 切换本会话的输出格式；启动参数 --output 设置其初值
+const outputVar = "FLYOS_OUTPUT"
+
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	switch f := OutputFormat(strings.ToLower(s)); f {
+	case OutputText, OutputTable, OutputJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("未知输出格式: %s（可选 text、table、json）", s)
+}
+
+// OutputFormat 当前会话的输出格式，未设置或非法时为 text
+func (s *Shell) OutputFormat() OutputFormat {
+	v, ok := s.LookupVar(outputVar)
+	if !ok || v == "" {
+		return OutputText
+	}
+	f, err := ParseOutputFormat(v)
+	if err != nil {
+		return OutputText
+	}
+	return f
+}
+
+// 以下为 JSON 输出的结构，字段只增不改；列表字段为空时输出 [] 而不是 null
+
+// commandInfo list 与 help 中的一条命令
+type commandInfo struct {
+	Name     string `json:"name"`
+	Category string `json:"category"`
+	Builtin  bool   `json:"builtin"`
+	Path     string `json:"path"`
+	Desc     string `json:"desc"`
+}
+
+// listOutput list
+type listOutput struct {
+	Commands []commandInfo `json:"commands"`
+}
+
+// helpOverview help（不带参数）
+type helpOverview struct {
+	Kind       string        `json:"kind"` // "overview"
+	Builtins   []commandInfo `json:"builtins"`
+	Categories []string      `json:"categories"`
+}
+
+// commandHelp help COMMAND
+type commandHelp struct {
+	Kind        string     `json:"kind"` // "command"
+	Name        string     `json:"name"`
+	Category    string     `json:"category"`
+	Builtin     bool       `json:"builtin"`
+	Path        string     `json:"path"`
+	Desc        string     `json:"desc"`
+	Usage       string     `json:"usage"`
+	Flags       []string   `json:"flags"`
+	Subcommands []string   `json:"subcommands"`
+	Args        []string   `json:"args"`
+	Returns     []string   `json:"returns"`
+	Schema      *ArgSchema `json:"schema,omitempty"`
+}
+
+// helpCategory help CATEGORY [GROUP...]，commands 只含本层命令，下一层见 groups
+type helpCategory struct {
+	Kind     string        `json:"kind"` // "category"
+	Category string        `json:"category"`
+	Path     []string      `json:"path"`
+	Groups   []string      `json:"groups"`
+	Commands []commandInfo `json:"commands"`
+}
+
+// helpSearch help KEYWORD，未找到时 matches 为空，suggestions 为相近的名称
+type helpSearch struct {
+	Kind        string        `json:"kind"` // "search"
+	Query       string        `json:"query"`
+	Matches     []commandInfo `json:"matches"`
+	Suggestions []string      `json:"suggestions"`
+}
+
+// envOutput env，变量按名称排序输出
+type envOutput struct {
+	Env map[string]string `json:"env"`
+}
+
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// writeTable 输出带表头的制表符对齐表格
+func writeTable(w io.Writer, header []string, rows [][]string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+func infoOf(cmd Command) commandInfo {
+	return commandInfo{
+		Name:     cmd.Name(),
+		Category: cmd.Category(),
+		Builtin:  cmd.IsBuiltin(),
+		Path:     cmd.Path(),
+		Desc:     cmd.Desc(),
+	}
+}
+
+// describe 已注册命令取自身信息并用 desc.toml 补全描述；仅在 desc.toml 中登记的命令取 desc.toml；
+// 当前角色不可见的命令视为不存在
+func describe(name string, shell *Shell, d *DescManager) (commandInfo, bool) {
+	if !shell.permitted(name, d) {
+		return commandInfo{}, false
+	}
+	cmd, registered := shell.Lookup(name)
+	desc, described := d.Get(name)
+	switch {
+	case registered:
+		info := infoOf(cmd)
+		if info.Desc == "" && described {
+			info.Desc = desc.Desc
+		}
+		return info, true
+	case described:
+		return commandInfo{Name: name, Category: desc.Category, Desc: desc.Desc}, true
+	}
+	return commandInfo{}, false
+}
+
+func sortInfos(infos []commandInfo) {
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+}
+
+func infoRows(infos []commandInfo) [][]string {
+	rows := make([][]string, 0, len(infos))
+	for _, c := range infos {
+		kind := "external"
+		if c.Builtin {
+			kind = "builtin"
+		}
+		rows = append(rows, []string{c.Name, c.Category, kind, c.Path, c.Desc})
+	}
+	return rows
+}
+
+var infoHeader = []string{"NAME", "CATEGORY", "TYPE", "PATH", "DESC"}
+
+// writeList list 的 table / json 输出
+func (s *Shell) writeList(w io.Writer, format OutputFormat) error {
+	s.mu.RLock()
+	infos := make([]commandInfo, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		if s.Allowed(cmd) {
+			infos = append(infos, infoOf(cmd))
+		}
+	}
+	s.mu.RUnlock()
+	sortInfos(infos)
+	if format == OutputJSON {
+		return writeJSON(w, listOutput{Commands: infos})
+	}
+	return writeTable(w, infoHeader, infoRows(infos))
+}
+
+// writeEnv env 的 table / json 输出
+func writeEnv(w io.Writer, format OutputFormat, vars []string) error {
+	env := make(map[string]string, len(vars))
+	rows := make([][]string, 0, len(vars))
+	for _, kv := range vars {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			continue
+		}
+		env[k] = v
+		rows = append(rows, []string{k, v})
+	}
+	if format == OutputJSON {
+		return writeJSON(w, envOutput{Env: env})
+	}
+	sort.Slice(rows, func(i, j int) bool { return rows[i][0] < rows[j][0] })
+	return writeTable(w, []string{"NAME", "VALUE"}, rows)
+}
+
+// commandHelp 汇总单条命令的帮助信息
+func (d *DescManager) commandHelp(name string, shell *Shell) (commandHelp, bool) {
+	info, ok := describe(name, shell, d)
+	if !ok {
+		return commandHelp{}, false
+	}
+	h := commandHelp{
+		Kind:     "command",
+		Name:     info.Name,
+		Category: info.Category,
+		Builtin:  info.Builtin,
+		Path:     info.Path,
+		Desc:     info.Desc,
+	}
+	if cmd, ok := shell.Lookup(name); ok && selfDescribed(cmd) {
+		h.Usage = cmd.Usage()
+		h.Flags, h.Subcommands, h.Args, h.Returns = cmd.Flags(), cmd.Subcommands(), cmd.Args(), cmd.Returns()
+	} else if desc, ok := d.Get(name); ok {
+		h.Category = desc.Category
+		h.Usage = desc.Usage
+		h.Flags, h.Subcommands, h.Args, h.Returns = desc.Flags, desc.Subcommands, desc.Args, desc.Returns
+	}
+	if cmd, ok := shell.Lookup(name); !ok || isFileCommand(cmd) {
+		if desc, ok := d.Get(name); ok {
+			h.Schema = desc.Schema
+		}
+	}
+	h.Flags, h.Subcommands, h.Args, h.Returns = nonNil(h.Flags), nonNil(h.Subcommands), nonNil(h.Args), nonNil(h.Returns)
+	return h, true
+}
+
+// writeCommandHelp help COMMAND 的 table / json 输出，未找到时输出空的搜索结果
+func (d *DescManager) writeCommandHelp(w io.Writer, format OutputFormat, name string, shell *Shell) error {
+	h, ok := d.commandHelp(name, shell)
+	if !ok {
+		return writeSearch(w, format, helpSearch{Kind: "search", Query: name, Matches: []commandInfo{}, Suggestions: []string{}})
+	}
+	if format == OutputJSON {
+		return writeJSON(w, h)
+	}
+	rows := [][]string{
+		{"name", h.Name},
+		{"category", h.Category},
+		{"builtin", fmt.Sprint(h.Builtin)},
+		{"path", h.Path},
+		{"desc", h.Desc},
+		{"usage", h.Usage},
+	}
+	for _, f := range []struct {
+		field  string
+		values []string
+	}{{"flags", h.Flags}, {"subcommands", h.Subcommands}, {"args", h.Args}, {"returns", h.Returns}} {
+		for _, v := range f.values {
+			rows = append(rows, []string{f.field, v})
+		}
+	}
+	return writeTable(w, []string{"FIELD", "VALUE"}, rows)
+}
+
+func writeSearch(w io.Writer, format OutputFormat, s helpSearch) error {
+	if format == OutputJSON {
+		return writeJSON(w, s)
+	}
+	return writeTable(w, infoHeader, infoRows(s.Matches))
+}
+
+// writeStructured help 的 table / json 输出，匹配顺序与文本模式一致：命令、分类、关键字
+func (h *HelpCommand) writeStructured(w io.Writer, format OutputFormat, args []string) error {
+	if len(args) == 1 {
+		overview := helpOverview{Kind: "overview", Builtins: []commandInfo{}, Categories: h.categories()}
+		for _, name := range h.shell.Names() {
+			if cmd, ok := h.shell.Lookup(name); ok && cmd.IsBuiltin() {
+				overview.Builtins = append(overview.Builtins, infoOf(cmd))
+			}
+		}
+		sort.Strings(overview.Categories)
+		if format == OutputJSON {
+			return writeJSON(w, overview)
+		}
+		rows := infoRows(overview.Builtins)
+		for _, cat := range overview.Categories {
+			rows = append(rows, []string{"", cat, "category", "", ""})
+		}
+		return writeTable(w, infoHeader, rows)
+	}
+
+	target := args[1]
+	if _, ok := describe(target, h.shell, h.descMgr); ok {
+		return h.descMgr.writeCommandHelp(w, format, target, h.shell)
+	}
+
+	if cat, ok := h.category(target); ok {
+		n, path, found := h.lookupNode(cat, args[2:])
+		if !found {
+			missing := args[2+len(path)-1]
+			return writeSearch(w, format, helpSearch{Kind: "search", Query: strings.Join(args[1:], " "),
+				Matches: []commandInfo{}, Suggestions: nonNil(suggest(missing, h.groupNames(n)))})
+		}
+		out := helpCategory{Kind: "category", Category: cat, Path: path, Groups: nonNil(h.groupNames(n)), Commands: []commandInfo{}}
+		for _, name := range n.commands {
+			if info, ok := describe(name, h.shell, h.descMgr); ok {
+				out.Commands = append(out.Commands, info)
+			}
+		}
+		if len(path) == 1 {
+			for _, cmd := range h.selfDescribedIn(cat) {
+				out.Commands = append(out.Commands, infoOf(cmd))
+			}
+		}
+		sortInfos(out.Commands)
+		if format == OutputJSON {
+			return writeJSON(w, out)
+		}
+		rows := infoRows(out.Commands)
+		for _, g := range out.Groups {
+			rows = append(rows, []string{"", g, "group", "", ""})
+		}
+		return writeTable(w, infoHeader, rows)
+	}
+
+	search := helpSearch{Kind: "search", Query: target, Matches: []commandInfo{}, Suggestions: []string{}}
+	seen := make(map[string]bool)
+	keyword := strings.ToLower(target)
+	consider := func(info commandInfo) {
+		if seen[info.Name] {
+			return
+		}
+		if strings.Contains(strings.ToLower(info.Name), keyword) || strings.Contains(strings.ToLower(info.Desc), keyword) {
+			seen[info.Name] = true
+			search.Matches = append(search.Matches, info)
+		}
+	}
+	for _, name := range h.shell.Names() {
+		if info, ok := describe(name, h.shell, h.descMgr); ok {
+			consider(info)
+		}
+	}
+	h.descMgr.desc.Range(func(k, _ any) bool {
+		if info, ok := describe(k.(string), h.shell, h.descMgr); ok {
+			consider(info)
+		}
+		return true
+	})
+	sortInfos(search.Matches)
+	if len(search.Matches) == 0 {
+		search.Suggestions = nonNil(h.suggestFor(target))
+	}
+	return writeSearch(w, format, search)
+}
@@ -2,23 +2,25 @@ package dsl
 
 import (
 	"fmt"
+	"io"
 	"strings"
 )
 
 func init() {
 	Register("acl", execACL)
+	RegisterSource("acl", NewStore("acl"))
 }
 
-func execACL(cmd *Command) error {
+func execACL(cmd *Command, w io.Writer) error {
 	switch strings.ToLower(cmd.Verb) {
 	case "add", "set", "delete":
-		fmt.Printf("[acl %s] subtype=%s attrs=%v\n", cmd.Verb, cmd.Subtype, cmd.Attrs)
+		fmt.Fprintf(w, "[acl %s] subtype=%s attrs=%v\n", cmd.Verb, cmd.Subtype, cmd.Attrs)
 	case "sync":
 		for _, b := range cmd.Blocks {
-			fmt.Printf("[acl sync] subtype=%s attrs=%v\n", b.Subtype, b.Attrs)
+			fmt.Fprintf(w, "[acl sync] subtype=%s attrs=%v\n", b.Subtype, b.Attrs)
 		}
 	default:
-		fmt.Println("[acl] unknown verb", cmd.Verb)
+		fmt.Fprintln(w, "[acl] unknown verb", cmd.Verb)
 	}
 	return nil
 }
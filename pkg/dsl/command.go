@@ -2,6 +2,9 @@ package dsl
 
 import (
 	"fmt"
+	"io"
+	"os"
+	"sort"
 	"strings"
 )
 
@@ -9,11 +12,14 @@ type Command struct {
 	Kind    string
 	Verb    string
 	Subtype string
+	Name    string // list/show/get 查询的对象名
+	Where   []Cond // list/show/get 的过滤条件
 	Attrs   map[string]interface{}
 	Blocks  []Command
 }
 
-type ExecutorFunc func(cmd *Command) error
+// ExecutorFunc 执行一条命令，输出写到 w
+type ExecutorFunc func(cmd *Command, w io.Writer) error
 
 var executors = map[string]ExecutorFunc{}
 
@@ -21,21 +27,58 @@ func Register(kind string, fn ExecutorFunc) {
 	executors[strings.ToLower(kind)] = fn
 }
 
+// Registered kind 是否注册了执行器
+func Registered(kind string) bool {
+	_, ok := executors[strings.ToLower(kind)]
+	return ok
+}
+
+// Kinds 已注册的全部 kind，按名称排序
+func Kinds() []string {
+	kinds := make([]string, 0, len(executors))
+	for k := range executors {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	return kinds
+}
+
 func Execute(cmd *Command) error {
 	fn, ok := executors[strings.ToLower(cmd.Kind)]
 	if !ok {
 		return fmt.Errorf("no executor registered for kind '%s'", cmd.Kind)
 	}
-	return fn(cmd)
+	if err := fn(cmd, os.Stdout); err != nil {
+		return err
+	}
+	record(cmd)
+	return nil
 }
 
 func ExecuteAll(cmds []Command) error {
+	return ExecuteAllTo(os.Stdout, cmds)
+}
+
+// ExecuteAllTo 与 ExecuteAll 相同，执行器与查询的输出写到 w
+func ExecuteAllTo(w io.Writer, cmds []Command) error {
 	for i := range cmds {
 		cmd := &cmds[i]
 		// 对 sync，遍历 Blocks
 		if cmd.Verb == "sync" && len(cmd.Blocks) > 0 {
 			for _, b := range cmd.Blocks {
-				fmt.Printf("[%s %s] %s %v\n", cmd.Kind, cmd.Verb, b.Subtype, b.Attrs)
+				fmt.Fprintf(w, "[%s %s] %s %v\n", cmd.Kind, cmd.Verb, b.Subtype, b.Attrs)
+			}
+			record(cmd)
+			continue
+		}
+		// list/show/get 查询模块状态
+		if IsQuery(cmd.Verb) {
+			specs, err := Query(cmd)
+			if err != nil {
+				return err
+			}
+			for _, spec := range specs {
+				fmt.Fprintf(w, "[%s %s] %v\n", cmd.Kind, cmd.Verb, spec)
 			}
 			continue
 		}
@@ -44,9 +87,10 @@ func ExecuteAll(cmds []Command) error {
 		if !ok {
 			return fmt.Errorf("no executor registered for kind '%s'", cmd.Kind)
 		}
-		if err := fn(cmd); err != nil {
+		if err := fn(cmd, w); err != nil {
 			return err
 		}
+		record(cmd)
 	}
 	return nil
 }
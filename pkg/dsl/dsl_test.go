@@ -3,8 +3,11 @@ package dsl
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
+	"reflect"
+	"regexp"
 	"strings"
 	"testing"
 )
@@ -250,6 +253,10 @@ nat add masq-vlan {
 }
 
 `
+	// list 是查询语句（结果由 Execute 输出），fixture 中 list 之后的 { ... } 是示例输出而不是语句，解析前去掉
+	sample := regexp.MustCompile(`(?m)^(\w+ list.*)\n\{\n(?:.*\n)*?\}\n`)
+	src = sample.ReplaceAllString(src, "$1\n")
+
 	t.Run("Parse", func(t *testing.T) {
 		p := NewParser(src)
 		cmds, err := p.Parse()
@@ -257,15 +264,21 @@ nat add masq-vlan {
 			t.Fatalf("Parse failed: %v", err)
 		}
 
-		if len(cmds) != 7 {
-			t.Errorf("Expected 7 commands, got %d", len(cmds))
+		if len(cmds) != 44 {
+			t.Errorf("Expected 44 commands, got %d", len(cmds))
+		}
+		find := func(kind, verb, subtype string) Command {
+			for _, c := range cmds {
+				if c.Kind == kind && c.Verb == verb && c.Subtype == subtype {
+					return c
+				}
+			}
+			t.Fatalf("%s %s %s not found", kind, verb, subtype)
+			return Command{}
 		}
 
 		// 验证第一个 route add
-		cmd0 := cmds[0]
-		if cmd0.Kind != "route" || cmd0.Verb != "add" || cmd0.Subtype != "static" {
-			t.Errorf("cmd0 mismatch: %+v", cmd0)
-		}
+		cmd0 := find("route", "add", "static")
 		if prefix, ok := cmd0.Attrs["prefix"].(string); !ok || prefix != "10.0.0.0/24" {
 			t.Errorf("cmd0 prefix wrong: %v", cmd0.Attrs["prefix"])
 		}
@@ -274,7 +287,7 @@ nat add masq-vlan {
 		}
 
 		// 验证 BGP community 列表
-		cmd1 := cmds[1]
+		cmd1 := find("route", "set", "bgp")
 		if comm, ok := cmd1.Attrs["community"].([]string); !ok || len(comm) != 2 {
 			t.Errorf("BGP community not parsed as []string: %v", cmd1.Attrs["community"])
 		} else if comm[0] != "65001:100" || comm[1] != "65002:200" {
@@ -282,12 +295,21 @@ nat add masq-vlan {
 		}
 
 		// 验证 sync 块
-		syncCmd := cmds[5] // routes sync
-		if syncCmd.Kind != "route" || syncCmd.Verb != "sync" || len(syncCmd.Blocks) != 2 {
-			t.Errorf("Sync command malformed: %+v", syncCmd)
+		syncCmd := find("route", "sync", "")
+		var subtypes []string
+		for _, b := range syncCmd.Blocks {
+			subtypes = append(subtypes, b.Subtype)
 		}
-		if syncCmd.Blocks[0].Subtype != "static" || syncCmd.Blocks[1].Subtype != "bgp" {
-			t.Errorf("Sync subtypes wrong: %v", syncCmd.Blocks)
+		if want := []string{"static", "bgp", "ospf", "pbr", "static"}; !reflect.DeepEqual(subtypes, want) {
+			t.Errorf("Sync subtypes wrong: %v", subtypes)
+		}
+		if ipsec := find("ipsec", "sync", ""); len(ipsec.Blocks) != 2 || ipsec.Blocks[1].Subtype != "" {
+			t.Errorf("ipsec sync malformed: %+v", ipsec)
+		}
+
+		// 查询语句
+		if q := find("route", "list", "bgp"); q.Name != "" || len(q.Where) != 0 {
+			t.Errorf("route list bgp malformed: %+v", q)
 		}
 	})
 
@@ -297,17 +319,24 @@ nat add masq-vlan {
 		if err != nil {
 			t.Fatalf("Parse failed: %v", err)
 		}
+		// 只有 route 与 acl 注册了执行器，ExecuteAll 遇到其他 kind 即停止
+		var registered []Command
+		for _, c := range cmds {
+			if Registered(c.Kind) {
+				registered = append(registered, c)
+			}
+		}
 
 		output := captureOutput(func() {
-			if err := ExecuteAll(cmds); err != nil {
+			if err := ExecuteAll(registered); err != nil {
 				fmt.Printf("execute error: %v\n", err)
 			}
 		})
 
 		lines := strings.Split(strings.TrimSpace(output), "\n")
-		// 统计所有命令 + sync block，总共 9 行
-		if len(lines) != 9 {
-			t.Errorf("Expected 9 output lines, got %d:\n%s", len(lines), output)
+		// 11 条 add/set/delete，sync 的 7 个块，查询到的 2+1+1+1+5 个对象
+		if len(lines) != 28 {
+			t.Errorf("Expected 28 output lines, got %d:\n%s", len(lines), output)
 		}
 
 		// 检查关键输出是否存在
@@ -331,3 +360,396 @@ nat add masq-vlan {
 		}
 	})
 }
+
+func TestParseAttributes(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want map[string]interface{}
+	}{
+		{"同一行成对", `route add static { prefix 10.0.0.0/24 via 1.2.3.4 }`,
+			map[string]interface{}{"prefix": "10.0.0.0/24", "via": "1.2.3.4"}},
+		{"同一行成对含数字", `route add x { a 1 b 2 }`,
+			map[string]interface{}{"a": 1, "b": 2}},
+		{"分号分隔", `route add x { dev eth0; track yes }`,
+			map[string]interface{}{"dev": "eth0", "track": true}},
+		{"多词键", "interface add eth0 {\n    ip addr 192.168.1.1/24;\n    destination interface eth3\n}",
+			map[string]interface{}{"ip addr": "192.168.1.1/24", "destination interface": "eth3"}},
+		{"嵌套块", "nat add snat-out {\n    type snat;\n    match {\n        src 10.0.0.0/8;\n        out_interface bond0;\n    }\n    to 203.0.113.10;\n}",
+			map[string]interface{}{
+				"type":  "snat",
+				"match": map[string]interface{}{"src": "10.0.0.0/8", "out_interface": "bond0"},
+				"to":    "203.0.113.10",
+			}},
+		{"嵌套块中的列表", `spand add m { source { interfaces [ eth0, bond0 ]; direction both } }`,
+			map[string]interface{}{
+				"source": map[string]interface{}{"interfaces": []string{"eth0", "bond0"}, "direction": "both"},
+			}},
+		{"重复的键收集为列表", "interface add eth0 {\n    ip addr 10.0.0.1/24;\n    ip addr 10.0.1.1/24;\n    vids [ 100 ];\n    vids 200\n}",
+			map[string]interface{}{"ip addr": []string{"10.0.0.1/24", "10.0.1.1/24"}, "vids": []string{"100", "200"}}},
+		{"重复的块", `acl add x { rule { port 80 }; rule { port 443 } }`,
+			map[string]interface{}{"rule": []map[string]interface{}{{"port": 80}, {"port": 443}}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmds, err := NewParser(tt.src).Parse()
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if len(cmds) != 1 {
+				t.Fatalf("Expected 1 command, got %d", len(cmds))
+			}
+			if !reflect.DeepEqual(cmds[0].Attrs, tt.want) {
+				t.Errorf("attrs = %#v, want %#v", cmds[0].Attrs, tt.want)
+			}
+		})
+	}
+
+	// 无法解析的属性报告位置
+	bad := []struct {
+		src, msg string
+	}{
+		{`route add x { a }`, `1:17: expected value for attribute "a", got "}"`},
+		{`route add x { a 1 2 }`, `1:17: expected ; after attribute "a", got NUMBER "1"`},
+		{`route add x { m { q 1 }; m 2 }`, `1:26: attribute "m" mixes blocks and values`},
+	}
+	for _, tt := range bad {
+		_, err := NewParser(tt.src).Parse()
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: expected error %q, got %v", tt.src, tt.msg, err)
+		}
+	}
+}
+
+func TestParseStatementForms(t *testing.T) {
+	src := `vlan add bridge br0 {
+    type bridge;
+    vids [ 100, 200 ];
+}
+ipsec del ipsec-vpc
+ipsec sync {
+	ipsec-aws { local 203.0.113.8 }
+	{ local 203.0.113.40 }
+}`
+	cmds, err := NewParser(src).Parse()
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(cmds) != 3 {
+		t.Fatalf("Expected 3 commands, got %d", len(cmds))
+	}
+	// subtype 之后的第二个词是对象名
+	if c := cmds[0]; c.Subtype != "bridge" || c.Attrs["name"] != "br0" || c.Attrs["type"] != "bridge" {
+		t.Errorf("vlan add bridge br0 = %+v", c)
+	}
+	// del 是 delete 的缩写，名称只取同一行
+	if c := cmds[1]; c.Verb != "delete" || c.Subtype != "ipsec-vpc" || len(c.Attrs) != 0 {
+		t.Errorf("ipsec del = %+v", c)
+	}
+	// sync 中的对象可以省略 subtype
+	if c := cmds[2]; len(c.Blocks) != 2 || c.Blocks[0].Subtype != "ipsec-aws" || c.Blocks[1].Subtype != "" || c.Blocks[1].Attrs["local"] != "203.0.113.40" {
+		t.Errorf("ipsec sync = %+v", c)
+	}
+
+	bad := []struct {
+		src, msg string
+	}{
+		{`vlan add bridge br0 { name br1 }`, `1:17: name given twice: "br0" and br1`},
+		{`ipsec sync { 1 { a b } }`, `1:14: expected object in sync, got NUMBER "1"`},
+	}
+	for _, tt := range bad {
+		_, err := NewParser(tt.src).Parse()
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: expected error %q, got %v", tt.src, tt.msg, err)
+		}
+	}
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		src, want string
+	}{
+		{`"plain"`, `plain`},
+		{`"s3\"cr3t"`, `s3"cr3t`},
+		{`"a\\b"`, `a\b`},
+		{`"^10\."`, `^10\.`}, // 其他反斜杠原样保留
+		{`"x{y}"`, `x{y}`},
+	}
+	for _, tt := range tests {
+		tok := NewLexer(tt.src).NextToken()
+		if tok.Type != TT_STRING || tok.Literal != tt.want {
+			t.Errorf("%s: got %s %q, want STRING %q", tt.src, tok.Type, tok.Literal, tt.want)
+		}
+		if tok.End != len(tt.src) {
+			t.Errorf("%s: End = %d, want %d", tt.src, tok.End, len(tt.src))
+		}
+	}
+}
+
+func TestParseQuery(t *testing.T) {
+	tests := []struct {
+		src   string
+		want  Command
+		where []string
+	}{
+		{`route list`, Command{Kind: "route", Verb: "list"}, nil},
+		{`route list bgp`, Command{Kind: "route", Verb: "list", Subtype: "bgp"}, nil},
+		{`route show static 10.0.0.0/24`, Command{Kind: "route", Verb: "show", Subtype: "static", Name: "10.0.0.0/24"}, nil},
+		{`route get 10.0.0.0/24`, Command{Kind: "route", Verb: "get", Name: "10.0.0.0/24"}, nil},
+		{`route get static 10.0.0.0/24`, Command{Kind: "route", Verb: "get", Subtype: "static", Name: "10.0.0.0/24"}, nil},
+		{`route list where via = 1.2.3.4`, Command{Kind: "route", Verb: "list"}, []string{"via = 1.2.3.4"}},
+		{`acl list where port != 80 and proto = tcp`, Command{Kind: "acl", Verb: "list"}, []string{"port != 80", "proto = tcp"}},
+		{`route list bgp where prefix ~ "^10\."`, Command{Kind: "route", Verb: "list", Subtype: "bgp"}, []string{`prefix ~ ^10\.`}},
+		{`nat list where match.src = 10.0.0.0/8`, Command{Kind: "nat", Verb: "list"}, []string{"match.src = 10.0.0.0/8"}},
+		{`interface list where ip addr = 10.0.0.1/24`, Command{Kind: "interface", Verb: "list"}, []string{"ip addr = 10.0.0.1/24"}},
+		{`route list where track = yes`, Command{Kind: "route", Verb: "list"}, []string{"track = true"}},
+		{"route list\nroute get x", Command{Kind: "route", Verb: "list"}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			cmds, err := NewParser(tt.src).Parse()
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			c := cmds[0]
+			var where []string
+			for _, w := range c.Where {
+				where = append(where, w.String())
+			}
+			c.Where = nil
+			if !reflect.DeepEqual(c, tt.want) {
+				t.Errorf("command = %+v, want %+v", c, tt.want)
+			}
+			if !reflect.DeepEqual(where, tt.where) {
+				t.Errorf("where = %q, want %q", where, tt.where)
+			}
+		})
+	}
+
+	bad := []struct {
+		src, msg string
+	}{
+		{`route get`, "expected object name after get"},
+		{`route list a b c`, `1:16: expected where, got IDENT "c"`},
+		{`route list where via`, "expected operator =, != or ~"},
+		{`route list where via = `, "expected value"},
+		{`route list where = 1`, "expected attribute name"},
+		{`route list where prefix ~ "("`, "invalid pattern"},
+	}
+	for _, tt := range bad {
+		_, err := NewParser(tt.src).Parse()
+		if err == nil || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%s: expected error %q, got %v", tt.src, tt.msg, err)
+		}
+	}
+}
+
+func TestCondMatch(t *testing.T) {
+	spec := map[string]interface{}{
+		"name":      "10.0.0.0/24",
+		"via":       "1.2.3.4",
+		"metric":    10,
+		"track":     true,
+		"community": []string{"65001:100", "65002:200"},
+		"match":     map[string]interface{}{"src": "10.0.0.0/8"},
+		"rule":      []map[string]interface{}{{"port": 80}, {"port": 443}},
+	}
+	tests := []struct {
+		where string
+		want  bool
+	}{
+		{"via = 1.2.3.4", true},
+		{"via = 1.2.3.5", false},
+		{"via != 1.2.3.5", true},
+		{"via != 1.2.3.4", false},
+		{"metric = 10", true},
+		{"track = yes", true},
+		{"track = false", false},
+		{`name ~ "^10\."`, true},
+		{`name ~ "^192\."`, false},
+		{"community = 65002:200", true},
+		{"community != 65001:100", false},
+		{"missing = x", false},
+		{"missing != x", true},
+		{"match.src = 10.0.0.0/8", true},
+		{"rule.port = 443", true},
+		{"rule.port = 22", false},
+		{"via = 1.2.3.4 and metric = 10", true},
+		{"via = 1.2.3.4 and metric = 20", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.where, func(t *testing.T) {
+			cmds, err := NewParser("route list where " + tt.where).Parse()
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			got := true
+			for _, c := range cmds[0].Where {
+				got = got && c.Match(spec)
+			}
+			if got != tt.want {
+				t.Errorf("match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore(t *testing.T) {
+	st := NewStore("route")
+	apply := func(src string) {
+		t.Helper()
+		cmds, err := NewParser(src).Parse()
+		if err != nil {
+			t.Fatalf("Parse failed: %v", err)
+		}
+		for i := range cmds {
+			st.Apply(&cmds[i])
+		}
+	}
+	names := func() []string {
+		specs, _ := st.List()
+		var names []string
+		for _, sp := range specs {
+			names = append(names, fmt.Sprintf("%v/%v", sp["subtype"], sp["name"]))
+		}
+		return names
+	}
+
+	// 同一 subtype 的对象以 prefix 区分，不互相覆盖
+	apply(`route add static { prefix 10.0.0.0/24 via 1.2.3.4 }
+route add static { prefix 10.1.0.0/24 via 1.2.3.5 }
+route add default { via 9.9.9.9 }`)
+	if got, want := names(), []string{"static/10.0.0.0/24", "static/10.1.0.0/24", "<nil>/default"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("List = %q, want %q", got, want)
+	}
+
+	apply(`route set static { prefix 10.1.0.0/24; metric 5 }`)
+	sp, err := st.Get("10.1.0.0/24")
+	if err != nil || sp["via"] != "1.2.3.5" || sp["metric"] != 5 {
+		t.Errorf("Get after set = %v, %v", sp, err)
+	}
+	// 返回的是副本
+	sp["via"] = "x"
+	if sp, _ := st.Get("10.1.0.0/24"); sp["via"] != "1.2.3.5" {
+		t.Errorf("Get returned the stored spec")
+	}
+
+	apply(`route delete static { prefix 10.0.0.0/24 }`)
+	if got, want := names(), []string{"static/10.1.0.0/24", "<nil>/default"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List after delete = %q, want %q", got, want)
+	}
+	apply(`route delete static`)
+	if got, want := names(), []string{"<nil>/default"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List after delete static = %q, want %q", got, want)
+	}
+	if _, err := st.Get("10.1.0.0/24"); err == nil || err.Error() != "route '10.1.0.0/24' not found" {
+		t.Errorf("Get deleted = %v", err)
+	}
+
+	apply("route sync {\n    static { prefix 10.2.0.0/24 }\n    bgp { prefix 10.3.0.0/16 }\n}")
+	if got, want := names(), []string{"static/10.2.0.0/24", "bgp/10.3.0.0/16"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("List after sync = %q, want %q", got, want)
+	}
+
+	// Query 按 subtype 与 where 过滤
+	RegisterSource("teststore", st)
+	defer delete(sources, "teststore")
+	cmds, _ := NewParser(`teststore list bgp where prefix ~ "^10\."`).Parse()
+	specs, err := Query(&cmds[0])
+	if err != nil || len(specs) != 1 || specs[0]["name"] != "10.3.0.0/16" {
+		t.Errorf("Query = %v, %v", specs, err)
+	}
+}
+
+func TestLexerPosition(t *testing.T) {
+	src := "route add x {\n\tvia 1.2.3.4; // 注释\n  dev \"网 口\" mtu 1500\n}\n"
+	want := []struct {
+		typ        TokenType
+		lit        string
+		line, col  int
+		start, end int
+	}{
+		{TT_IDENT, "route", 1, 1, 0, 5},
+		{TT_ADD, "add", 1, 7, 6, 9},
+		{TT_IDENT, "x", 1, 11, 10, 11},
+		{TT_LBRACE, "{", 1, 13, 12, 13},
+		{TT_IDENT, "via", 2, 2, 15, 18},
+		{TT_IDENT, "1.2.3.4", 2, 6, 19, 26},
+		{TT_SEMI, ";", 2, 13, 26, 27},
+		{TT_IDENT, "dev", 3, 3, 40, 43},
+		{TT_STRING, "网 口", 3, 7, 44, 53}, // 列按字符计，偏移按字节计
+		{TT_IDENT, "mtu", 3, 13, 54, 57},
+		{TT_NUMBER, "1500", 3, 17, 58, 62},
+		{TT_RBRACE, "}", 4, 1, 63, 64},
+		{TT_EOF, "", 4, 2, 64, 64}, // EOF 紧跟最后一个 token，不在结尾的换行之后
+	}
+	l := NewLexer(src)
+	for i, w := range want {
+		tok := l.NextToken()
+		if tok.Type != w.typ || tok.Literal != w.lit || tok.Line != w.line || tok.Col != w.col || tok.Pos != w.start || tok.End != w.end {
+			t.Errorf("token %d = %s %q %d:%d [%d,%d), want %s %q %d:%d [%d,%d)", i,
+				tok.Type, tok.Literal, tok.Line, tok.Col, tok.Pos, tok.End,
+				w.typ, w.lit, w.line, w.col, w.start, w.end)
+		}
+	}
+}
+
+func TestParseErrorCaret(t *testing.T) {
+	tests := []struct {
+		name         string
+		src          string
+		err          string
+		line, marker string
+	}{
+		{"单行", `route add x { via }`,
+			`1:19: expected value for attribute "via", got "}"`,
+			`route add x { via }`,
+			`                  ^`},
+		{"token 长度", "route frob x",
+			`1:7: expected verb add/set/delete/list/show/get, got IDENT "frob"`,
+			"route frob x",
+			"      ^^^^"},
+		{"输入结束", "route add x {\n",
+			"1:14: expected }, got end of input",
+			"route add x {",
+			"             ^"},
+		{"制表符缩进", "# 说明\n\tacl add y { a }",
+			`2:16: expected value for attribute "a", got "}"`,
+			"\tacl add y { a }",
+			"\t              ^"},
+		{"多字节字符", `route add x { a "中文" "d" }`,
+			`1:17: expected ; after attribute "a", got STRING "中文"`,
+			`route add x { a "中文" "d" }`,
+			`                ^^^^`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewParser(tt.src).Parse()
+			var errs ParseErrors
+			if !errors.As(err, &errs) || len(errs) == 0 {
+				t.Fatalf("expected ParseErrors, got %v", err)
+			}
+			if errs[0].Error() != tt.err {
+				t.Errorf("error = %q, want %q", errs[0].Error(), tt.err)
+			}
+			line, marker := errs[0].Caret(tt.src)
+			if line != tt.line || marker != tt.marker {
+				t.Errorf("caret =\n%q\n%q\nwant\n%q\n%q", line, marker, tt.line, tt.marker)
+			}
+		})
+	}
+
+	// 出错后跳到下一条语句继续解析，报告全部错误
+	src := "route frob x\nacl add y { a }\nroute add z { via 1 }\n"
+	cmds, err := NewParser(src).Parse()
+	var errs ParseErrors
+	if !errors.As(err, &errs) || len(errs) != 2 || errs[1].Line != 2 {
+		t.Fatalf("expected 2 errors, got %v", err)
+	}
+	if len(cmds) == 0 || cmds[len(cmds)-1].Subtype != "z" {
+		t.Errorf("commands after recovery = %+v", cmds)
+	}
+	if _, marker := (ParseError{Line: 9}).Caret(src); marker != "" {
+		t.Errorf("caret for line out of range = %q", marker)
+	}
+}
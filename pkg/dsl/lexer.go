@@ -4,6 +4,7 @@ import (
 	"strconv"
 	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 type TokenType string
@@ -24,28 +25,49 @@ const (
 	TT_ADD    TokenType = "ADD"
 	TT_SET    TokenType = "SET"
 	TT_DELETE TokenType = "DELETE"
+	TT_EQ     TokenType = "="
+	TT_NEQ    TokenType = "!="
+	TT_MATCH  TokenType = "~"
 )
 
+// Token Pos、End 为起止字节偏移，Line、Col 从 1 开始，Col 按字符计
 type Token struct {
 	Type    TokenType
 	Literal string
 	Pos     int
+	End     int
+	Line    int
+	Col     int
 }
 
 type Lexer struct {
-	input   string
-	pos     int
-	readPos int
-	ch      rune
+	input     string
+	pos       int
+	readPos   int
+	ch        rune
+	line      int // l.ch 所在行
+	lineStart int // 该行起始的字节偏移
+
+	// 上一个 token 结束的位置，EOF 指向这里而不是输入末尾的空白之后
+	endPos, endLine, endCol int
 }
 
 func NewLexer(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1, endLine: 1, endCol: 1}
 	l.readChar()
 	return l
 }
 
+// col l.pos 所在的列
+func (l *Lexer) col() int {
+	return utf8.RuneCountInString(l.input[l.lineStart:l.pos]) + 1
+}
+
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPos
+	}
 	if l.readPos >= len(l.input) {
 		l.ch = 0
 	} else {
@@ -96,15 +118,19 @@ func (l *Lexer) readIdent() string {
 	return l.input[start:l.pos]
 }
 
+// readString 读取 "..."，\" 与 \\ 为转义，其他反斜杠原样保留（如正则中的 \.）
 func (l *Lexer) readString() string {
 	l.readChar()
-	start := l.pos
+	var b strings.Builder
 	for l.ch != '"' && l.ch != 0 {
+		if l.ch == '\\' && (l.peekChar() == '"' || l.peekChar() == '\\') {
+			l.readChar()
+		}
+		b.WriteByte(l.input[l.pos])
 		l.readChar()
 	}
-	val := l.input[start:l.pos]
 	l.readChar()
-	return val
+	return b.String()
 }
 
 func (l *Lexer) readNumberLike() string {
@@ -116,8 +142,20 @@ func (l *Lexer) readNumberLike() string {
 }
 
 func (l *Lexer) NextToken() Token {
+	tok := l.nextToken()
+	if tok.Type == TT_EOF {
+		tok.Pos, tok.Line, tok.Col = l.endPos, l.endLine, l.endCol
+		tok.End = tok.Pos
+	} else {
+		l.endPos, l.endLine, l.endCol = l.pos, l.line, l.col()
+		tok.End = l.pos
+	}
+	return tok
+}
+
+func (l *Lexer) nextToken() Token {
 	l.skipSpaceAndComments()
-	tok := Token{Pos: l.pos}
+	tok := Token{Pos: l.pos, Line: l.line, Col: l.col()}
 	switch l.ch {
 	case '{':
 		tok.Type = TT_LBRACE
@@ -137,6 +175,21 @@ func (l *Lexer) NextToken() Token {
 	case ',':
 		tok.Type = TT_COMMA
 		tok.Literal = ","
+	case '=':
+		tok.Type = TT_EQ
+		tok.Literal = "="
+	case '~':
+		tok.Type = TT_MATCH
+		tok.Literal = "~"
+	case '!':
+		if l.peekChar() != '=' {
+			tok.Type = TT_IDENT
+			tok.Literal = "!"
+			break
+		}
+		l.readChar()
+		tok.Type = TT_NEQ
+		tok.Literal = "!="
 	case '"':
 		tok.Type = TT_STRING
 		tok.Literal = l.readString()
@@ -1,17 +1,68 @@
 package dsl
 
 import (
-	"errors"
 	"fmt"
+	"regexp"
 	"strconv"
 	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 type Parser struct {
 	l         *Lexer
 	curToken  Token
 	peekToken Token
-	errors    []string
+	errors    []ParseError
+}
+
+// ParseError 解析错误及出错 token 的位置，Len 为 token 的字符数
+type ParseError struct {
+	Pos  int
+	Line int
+	Col  int
+	Len  int
+	Msg  string
+}
+
+func (e ParseError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Caret 返回出错的源码行，以及在出错 token 下方标出 ^ 的一行；制表符原样保留以便对齐
+func (e ParseError) Caret(src string) (line, marker string) {
+	lines := strings.Split(src, "\n")
+	if e.Line < 1 || e.Line > len(lines) {
+		return "", ""
+	}
+	line = strings.TrimRight(lines[e.Line-1], "\r")
+	var b strings.Builder
+	for i, r := range []rune(line) {
+		if i >= e.Col-1 {
+			break
+		}
+		if r == '\t' {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte(' ')
+		}
+	}
+	n := e.Len
+	if n < 1 {
+		n = 1
+	}
+	return line, b.String() + strings.Repeat("^", n)
+}
+
+// ParseErrors Parse 返回的全部错误，按出现顺序排列
+type ParseErrors []ParseError
+
+func (es ParseErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "; ")
 }
 
 func NewParser(input string) *Parser {
@@ -31,14 +82,20 @@ func (p *Parser) nextToken() {
 func (p *Parser) Parse() ([]Command, error) {
 	var cmds []Command
 	for p.curToken.Type != TT_EOF {
+		if p.curToken.Type != TT_IDENT {
+			p.error(p.curToken, "expected statement, got "+describe(p.curToken))
+			p.skipStatement()
+			continue
+		}
 		if cmd, ok := p.parseStatement(); ok && cmd != nil {
 			cmds = append(cmds, *cmd)
+			p.nextToken() // 越过语句的最后一个 token
 		} else {
-			p.nextToken()
+			p.skipStatement()
 		}
 	}
 	if len(p.errors) > 0 {
-		return cmds, errors.New(strings.Join(p.errors, ", "))
+		return cmds, ParseErrors(p.errors)
 	}
 	return cmds, nil
 }
@@ -50,28 +107,53 @@ func (p *Parser) parseStatement() (*Command, bool) {
 	}
 	kind := strings.ToLower(p.curToken.Literal)
 
-	// sync 块
-	if strings.HasSuffix(kind, "s") && p.peekToken.Type == TT_SYNC {
-		return p.parseSyncBlock(kind[:len(kind)-1])
+	// sync 块，kind 可以是单数（route sync）或复数（routes sync）
+	if p.peekToken.Type == TT_SYNC {
+		if strings.HasSuffix(kind, "s") && !Registered(kind) {
+			kind = kind[:len(kind)-1]
+		}
+		return p.parseSyncBlock(kind)
 	}
 
-	// add/set/delete
+	// add/set/delete，del 为 delete 的缩写
 	p.nextToken()
 	verb := strings.ToLower(p.curToken.Literal)
+	if IsQuery(verb) {
+		return p.parseQuery(kind, verb)
+	}
+	if verb == "del" {
+		verb = "delete"
+	}
 	if verb != "add" && verb != "set" && verb != "delete" {
-		p.error("expected verb add/set/delete")
+		p.error(p.curToken, "expected verb add/set/delete/list/show/get, got "+describe(p.curToken))
 		return nil, false
 	}
 
-	subtype := ""
+	// subtype 之后同一行上的第二个词是对象名，如 vlan add bridge br0 { ... }，记为属性 name
+	subtype, name := "", Token{}
 	if p.peekToken.Type == TT_IDENT {
 		p.nextToken()
 		subtype = p.curToken.Literal
+		if p.peekToken.Type == TT_IDENT && p.peekToken.Line == p.curToken.Line {
+			p.nextToken()
+			name = p.curToken
+		}
 	}
 
-	p.expect(TT_LBRACE)
-	attrs := p.parseAttributes()
-	p.expect(TT_RBRACE)
+	// delete 可以只给名称，如 route delete static
+	attrs := map[string]interface{}{}
+	if verb != "delete" || p.peekToken.Type == TT_LBRACE {
+		p.expect(TT_LBRACE)
+		attrs = p.parseAttributes()
+		p.expect(TT_RBRACE)
+	}
+	if name.Literal != "" {
+		if v, ok := attrs["name"]; ok && fmt.Sprint(v) != name.Literal {
+			p.error(name, fmt.Sprintf("name given twice: %q and %v", name.Literal, v))
+			return nil, false
+		}
+		attrs["name"] = name.Literal
+	}
 
 	return &Command{
 		Kind:    kind,
@@ -81,15 +163,106 @@ func (p *Parser) parseStatement() (*Command, bool) {
 	}, true
 }
 
-// parseSyncBlock 解析 sync 块
+// parseQuery 解析 KIND list|show|get [SUBTYPE] [NAME] [where KEY OP VALUE [and ...]]，
+// 名称与 subtype 写在同一行；get 只给一个参数时它是对象名
+func (p *Parser) parseQuery(kind, verb string) (*Command, bool) {
+	cmd := &Command{Kind: kind, Verb: verb}
+	line := p.curToken.Line
+	var args []string
+	for isScalar(p.peekToken) && p.peekToken.Line == line && !isWhere(p.peekToken) {
+		p.nextToken()
+		if len(args) == 2 {
+			p.error(p.curToken, "expected where, got "+describe(p.curToken))
+			return nil, false
+		}
+		args = append(args, p.curToken.Literal)
+	}
+	switch {
+	case len(args) == 2:
+		cmd.Subtype, cmd.Name = args[0], args[1]
+	case len(args) == 1 && verb == "get":
+		cmd.Name = args[0]
+	case len(args) == 1:
+		cmd.Subtype = args[0]
+	case verb == "get":
+		p.error(p.curToken, "expected object name after get")
+		return nil, false
+	}
+
+	if isWhere(p.peekToken) {
+		p.nextToken()
+		for {
+			c, ok := p.parseCond()
+			if !ok {
+				return nil, false
+			}
+			cmd.Where = append(cmd.Where, c)
+			if p.peekToken.Type != TT_IDENT || strings.ToLower(p.peekToken.Literal) != "and" {
+				break
+			}
+			p.nextToken()
+		}
+	}
+	return cmd, true
+}
+
+// parseCond 解析 KEY OP VALUE，KEY 可以有多个词（ip addr）或用 . 访问嵌套块（match.src）
+func (p *Parser) parseCond() (Cond, bool) {
+	var words []string
+	for isKeyWord(p.peekToken) {
+		p.nextToken()
+		words = append(words, p.curToken.Literal)
+	}
+	if len(words) == 0 {
+		p.error(p.peekToken, "expected attribute name, got "+describe(p.peekToken))
+		return Cond{}, false
+	}
+	p.nextToken()
+	op := p.curToken
+	if op.Type != TT_EQ && op.Type != TT_NEQ && op.Type != TT_MATCH {
+		p.error(op, "expected operator =, != or ~, got "+describe(op))
+		return Cond{}, false
+	}
+	p.nextToken()
+	if !isScalar(p.curToken) {
+		p.error(p.curToken, "expected value, got "+describe(p.curToken))
+		return Cond{}, false
+	}
+	c := Cond{Key: strings.Join(words, " "), Op: op.Literal, Value: p.curToken.Literal}
+	if p.curToken.Type == TT_BOOL {
+		c.Value = strconv.FormatBool(scalarValue(p.curToken).(bool))
+	}
+	if op.Type == TT_MATCH {
+		re, err := regexp.Compile(c.Value)
+		if err != nil {
+			p.error(p.curToken, "invalid pattern: "+err.Error())
+			return Cond{}, false
+		}
+		c.re = re
+	}
+	return c, true
+}
+
+func isWhere(tok Token) bool {
+	return tok.Type == TT_IDENT && strings.ToLower(tok.Literal) == "where"
+}
+
+// parseSyncBlock 解析 sync 块，其中的对象可以省略 subtype，只写 { ... }
 func (p *Parser) parseSyncBlock(kind string) (*Command, bool) {
 	p.expect(TT_SYNC)   // consume SYNC
 	p.expect(TT_LBRACE) // consume {
 
 	var blocks []Command
 	for p.peekToken.Type != TT_RBRACE && p.peekToken.Type != TT_EOF {
-		p.nextToken()
-		subtype := p.curToken.Literal
+		subtype := ""
+		if p.peekToken.Type != TT_LBRACE {
+			p.nextToken()
+			if p.curToken.Type != TT_IDENT {
+				p.error(p.curToken, "expected object in sync, got "+describe(p.curToken))
+				return nil, false
+			}
+			subtype = p.curToken.Literal
+		}
 
 		p.expect(TT_LBRACE)
 		attrs := p.parseAttributes()
@@ -111,51 +284,45 @@ func (p *Parser) parseSyncBlock(kind string) (*Command, bool) {
 	}, true
 }
 
-// parseAttributes 解析 key/value 属性
+// parseAttributes 解析花括号内的属性，停在 } 之前。属性以 ;、换行、列表或嵌套块分隔；
+// 同一行上的 key value key value 按对解析，否则最后一个值前面的标识符组成一个键，
+// 如 ip addr 192.168.1.1/24、destination interface eth3。值也可以是 [ ... ] 列表
+// 或 { ... } 嵌套块，重复出现的键收集为列表
 func (p *Parser) parseAttributes() map[string]interface{} {
 	attrs := map[string]interface{}{}
 	for p.peekToken.Type != TT_RBRACE && p.peekToken.Type != TT_EOF {
 		p.nextToken()
-		if p.curToken.Type != TT_IDENT {
-			p.error("expected attribute key")
+		if p.curToken.Type == TT_SEMI {
+			continue
+		}
+		if !isKeyWord(p.curToken) {
+			p.error(p.curToken, "expected attribute key, got "+describe(p.curToken))
+			p.skipAttribute()
 			continue
 		}
-		key := p.curToken.Literal
 
-		p.nextToken()
-		var val interface{}
-		switch p.curToken.Type {
-		case TT_STRING:
-			val = p.curToken.Literal
-		case TT_NUMBER:
-			if i, err := strconv.Atoi(p.curToken.Literal); err == nil {
-				val = i
-			} else {
-				val = p.curToken.Literal
-			}
-		case TT_BOOL:
-			v := strings.ToLower(p.curToken.Literal)
-			val = (v == "yes" || v == "true")
+		// 同一行上连续的标识符与标量，最后可以跟一个列表或嵌套块
+		toks := []Token{p.curToken}
+		for isScalar(p.peekToken) && p.peekToken.Line == p.curToken.Line {
+			p.nextToken()
+			toks = append(toks, p.curToken)
+		}
+		vals := make([]interface{}, len(toks))
+		for i, tok := range toks {
+			vals[i] = scalarValue(tok)
+		}
+		switch p.peekToken.Type {
+		case TT_LBRACE:
+			p.nextToken()
+			toks = append(toks, p.curToken)
+			vals = append(vals, p.parseAttributes())
+			p.expect(TT_RBRACE)
 		case TT_LBRACK:
-			var items []string
-			for {
-				p.nextToken()
-				if p.curToken.Type == TT_RBRACK || p.curToken.Type == TT_EOF {
-					break
-				}
-				if p.curToken.Type == TT_IDENT || p.curToken.Type == TT_STRING || p.curToken.Type == TT_NUMBER {
-					items = append(items, p.curToken.Literal)
-				}
-				if p.peekToken.Type == TT_COMMA {
-					p.nextToken()
-				}
-			}
-			val = items
-		default:
-			val = p.curToken.Literal
+			p.nextToken()
+			toks = append(toks, p.curToken)
+			vals = append(vals, p.parseList())
 		}
-
-		attrs[key] = val
+		p.assignAttrs(attrs, toks, vals)
 		if p.peekToken.Type == TT_SEMI {
 			p.nextToken()
 		}
@@ -163,13 +330,198 @@ func (p *Parser) parseAttributes() map[string]interface{} {
 	return attrs
 }
 
+// assignAttrs 把一条属性语句的 token 写入 attrs：偶数个且每个键都像名称时按 key value 成对写入，
+// 否则前面的词组成一个键；vals[i] 为 toks[i] 的值
+func (p *Parser) assignAttrs(attrs map[string]interface{}, toks []Token, vals []interface{}) {
+	n := len(toks)
+	pairs := n%2 == 0
+	for i := 0; i < n; i += 2 {
+		if !isKeyName(toks[i]) {
+			pairs = false
+		}
+	}
+	if pairs {
+		for i := 0; i < n; i += 2 {
+			if err := addAttr(attrs, toks[i].Literal, vals[i+1]); err != nil {
+				p.error(toks[i], err.Error())
+			}
+		}
+		return
+	}
+	if n < 2 {
+		p.error(p.peekToken, fmt.Sprintf("expected value for attribute %q, got %s", toks[0].Literal, describe(p.peekToken)))
+		return
+	}
+
+	words := make([]string, n-1)
+	for i, tok := range toks[:n-1] {
+		if !isKeyName(tok) {
+			if i == 0 {
+				p.error(tok, "expected attribute key, got "+describe(tok))
+			} else {
+				p.error(tok, fmt.Sprintf("expected ; after attribute %q, got %s", strings.Join(words[:i], " "), describe(tok)))
+			}
+			return
+		}
+		words[i] = tok.Literal
+	}
+	if err := addAttr(attrs, strings.Join(words, " "), vals[n-1]); err != nil {
+		p.error(toks[0], err.Error())
+	}
+}
+
+// parseList 解析 [ a, b ]，curToken 为 [，结束时停在 ]
+func (p *Parser) parseList() []string {
+	var items []string
+	for {
+		p.nextToken()
+		if p.curToken.Type == TT_RBRACK || p.curToken.Type == TT_EOF {
+			break
+		}
+		if p.curToken.Type == TT_IDENT || p.curToken.Type == TT_STRING || p.curToken.Type == TT_NUMBER {
+			items = append(items, p.curToken.Literal)
+		}
+		if p.peekToken.Type == TT_COMMA {
+			p.nextToken()
+		}
+	}
+	return items
+}
+
+// skipAttribute 出错后跳过本行剩余的属性内容，停在 ; 或 } 之前
+func (p *Parser) skipAttribute() {
+	line := p.curToken.Line
+	for p.peekToken.Line == line && p.peekToken.Type != TT_SEMI && p.peekToken.Type != TT_RBRACE && p.peekToken.Type != TT_EOF {
+		p.nextToken()
+	}
+}
+
+// isKeyWord 可以作为属性键的 token，动词关键字也可以出现在键中
+func isKeyWord(tok Token) bool {
+	switch tok.Type {
+	case TT_IDENT, TT_ADD, TT_SET, TT_DELETE, TT_SYNC:
+		return true
+	}
+	return false
+}
+
+// isKeyName 像属性名的 token：以字母开头，且不含地址中才会出现的 . : /
+func isKeyName(tok Token) bool {
+	if !isKeyWord(tok) || tok.Literal == "" {
+		return false
+	}
+	r, _ := utf8.DecodeRuneInString(tok.Literal)
+	return unicode.IsLetter(r) && !strings.ContainsAny(tok.Literal, ".:/")
+}
+
+func isScalar(tok Token) bool {
+	return isKeyWord(tok) || tok.Type == TT_NUMBER || tok.Type == TT_STRING || tok.Type == TT_BOOL
+}
+
+func scalarValue(tok Token) interface{} {
+	switch tok.Type {
+	case TT_NUMBER:
+		if i, err := strconv.Atoi(tok.Literal); err == nil {
+			return i
+		}
+	case TT_BOOL:
+		v := strings.ToLower(tok.Literal)
+		return v == "yes" || v == "true"
+	}
+	return tok.Literal
+}
+
+// addAttr 写入属性，重复的键收集为列表：标量与列表合并为 []string，嵌套块合并为 []map[string]interface{}
+func addAttr(attrs map[string]interface{}, key string, val interface{}) error {
+	old, ok := attrs[key]
+	if !ok {
+		attrs[key] = val
+		return nil
+	}
+	if blocks, ok := asBlocks(old); ok {
+		b, ok := val.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("attribute %q mixes blocks and values", key)
+		}
+		attrs[key] = append(blocks, b)
+		return nil
+	}
+	list, _ := asStrings(old)
+	more, ok := asStrings(val)
+	if !ok {
+		return fmt.Errorf("attribute %q mixes blocks and values", key)
+	}
+	attrs[key] = append(list, more...)
+	return nil
+}
+
+func asBlocks(v interface{}) ([]map[string]interface{}, bool) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return []map[string]interface{}{val}, true
+	case []map[string]interface{}:
+		return val, true
+	}
+	return nil, false
+}
+
+func asStrings(v interface{}) ([]string, bool) {
+	switch val := v.(type) {
+	case []string:
+		return val, true
+	case string:
+		return []string{val}, true
+	case int:
+		return []string{strconv.Itoa(val)}, true
+	case bool:
+		return []string{strconv.FormatBool(val)}, true
+	}
+	return nil, false
+}
+
+// skipStatement 出错后跳到下一条语句：越过出错处所在的花括号块，或停在后面某一行开头的标识符
+func (p *Parser) skipStatement() {
+	line, depth := p.curToken.Line, 0
+	for p.curToken.Type != TT_EOF {
+		switch p.curToken.Type {
+		case TT_LBRACE:
+			depth++
+		case TT_RBRACE:
+			depth--
+			if depth <= 0 {
+				p.nextToken()
+				return
+			}
+		}
+		p.nextToken()
+		if depth == 0 && p.curToken.Type == TT_IDENT && p.curToken.Line > line {
+			return
+		}
+	}
+}
+
 func (p *Parser) expect(t TokenType) {
 	if p.peekToken.Type != t {
-		p.error(fmt.Sprintf("expected %s, got %s", t, p.peekToken.Type))
+		p.error(p.peekToken, fmt.Sprintf("expected %s, got %s", t, describe(p.peekToken)))
 	}
 	p.nextToken()
 }
 
-func (p *Parser) error(msg string) {
-	p.errors = append(p.errors, msg)
+func (p *Parser) error(tok Token, msg string) {
+	n := 0
+	if tok.End > tok.Pos && tok.End <= len(p.l.input) {
+		n = utf8.RuneCountInString(p.l.input[tok.Pos:tok.End])
+	}
+	p.errors = append(p.errors, ParseError{Pos: tok.Pos, Line: tok.Line, Col: tok.Col, Len: n, Msg: msg})
+}
+
+// describe 错误信息中的 token，标识符、数字与字符串带上原文
+func describe(tok Token) string {
+	switch tok.Type {
+	case TT_IDENT, TT_NUMBER, TT_STRING, TT_BOOL:
+		return fmt.Sprintf("%s %q", tok.Type, tok.Literal)
+	case TT_EOF:
+		return "end of input"
+	}
+	return fmt.Sprintf("%q", tok.Literal)
 }
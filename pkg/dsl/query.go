@@ -0,0 +1,276 @@
+package dsl
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"flyos/pkg/module"
+)
+
+var queryVerbs = map[string]bool{"list": true, "show": true, "get": true}
+
+// IsQuery verb 是否为只读的 list/show/get
+func IsQuery(verb string) bool {
+	return queryVerbs[strings.ToLower(verb)]
+}
+
+var sources = map[string]module.StatefulModule{}
+
+// RegisterSource 注册 kind 的状态模块，list/show/get 通过它的 Get/List 取得对象
+func RegisterSource(kind string, m module.StatefulModule) {
+	sources[strings.ToLower(kind)] = m
+}
+
+// Query 执行 list/show/get：给出名称时调用 Get，否则调用 List，再按 subtype 与 where 条件过滤
+func Query(cmd *Command) ([]module.Spec, error) {
+	m, ok := sources[strings.ToLower(cmd.Kind)]
+	if !ok {
+		return nil, fmt.Errorf("no module registered for kind '%s'", cmd.Kind)
+	}
+	var specs []module.Spec
+	if cmd.Name != "" {
+		spec, err := m.Get(cmd.Name)
+		if err != nil {
+			return nil, err
+		}
+		specs = []module.Spec{spec}
+	} else {
+		list, err := m.List()
+		if err != nil {
+			return nil, err
+		}
+		specs = list
+	}
+
+	var out []module.Spec
+next:
+	for _, spec := range specs {
+		if cmd.Subtype != "" && !matchSubtype(spec, cmd.Subtype) {
+			continue
+		}
+		for _, c := range cmd.Where {
+			if !c.Match(spec) {
+				continue next
+			}
+		}
+		out = append(out, spec)
+	}
+	return out, nil
+}
+
+// matchSubtype 按对象的 subtype 比较（如 route list bgp），没有时按 type 属性或名称比较
+func matchSubtype(spec module.Spec, subtype string) bool {
+	if t, ok := spec["subtype"]; ok {
+		return fmt.Sprint(t) == subtype
+	}
+	if t, ok := spec["type"]; ok {
+		return fmt.Sprint(t) == subtype
+	}
+	return fmt.Sprint(spec["name"]) == subtype
+}
+
+// Cond where 条件，Op 为 =、!= 或 ~（正则匹配）
+type Cond struct {
+	Key   string
+	Op    string
+	Value string
+	re    *regexp.Regexp
+}
+
+func (c Cond) String() string {
+	return c.Key + " " + c.Op + " " + c.Value
+}
+
+// Match 属性为列表时任意一项满足即可；!= 要求所有项都不相等，没有该属性也算不相等
+func (c Cond) Match(spec module.Spec) bool {
+	hit := false
+	for _, v := range lookup(spec, c.Key) {
+		if c.Op == "~" {
+			if c.re != nil {
+				hit = c.re.MatchString(v)
+			} else {
+				hit, _ = regexp.MatchString(c.Value, v)
+			}
+		} else {
+			hit = v == c.Value
+		}
+		if hit {
+			break
+		}
+	}
+	if c.Op == "!=" {
+		return !hit
+	}
+	return hit
+}
+
+// lookup 属性值的文本形式；键中的 . 访问嵌套块，如 match.src
+func lookup(attrs map[string]interface{}, key string) []string {
+	if v, ok := attrs[key]; ok {
+		return valueStrings(v)
+	}
+	head, rest, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil
+	}
+	switch sub := attrs[head].(type) {
+	case map[string]interface{}:
+		return lookup(sub, rest)
+	case module.Spec:
+		return lookup(sub, rest)
+	case []map[string]interface{}:
+		var vals []string
+		for _, b := range sub {
+			vals = append(vals, lookup(b, rest)...)
+		}
+		return vals
+	}
+	return nil
+}
+
+func valueStrings(v interface{}) []string {
+	switch val := v.(type) {
+	case []string:
+		return val
+	case []interface{}:
+		vals := make([]string, len(val))
+		for i, item := range val {
+			vals[i] = fmt.Sprint(item)
+		}
+		return vals
+	case map[string]interface{}, module.Spec, []map[string]interface{}:
+		return nil
+	}
+	return []string{fmt.Sprint(v)}
+}
+
+// Store 在内存中记录执行过的 add/set/delete/sync，
+// 为只有执行器、没有自己状态的 kind 实现 module.StatefulModule。
+// 每个 kind 一个 Store，对象以 ObjectName 为标识
+type Store struct {
+	kind string
+	mu   sync.RWMutex
+	objs map[string]module.Spec
+}
+
+func NewStore(kind string) *Store {
+	return &Store{kind: strings.ToLower(kind), objs: map[string]module.Spec{}}
+}
+
+func (s *Store) Name() string     { return s.kind }
+func (s *Store) Category() string { return "dsl" }
+func (s *Store) Version() string  { return "1.0" }
+
+// idKeys 标识对象的属性，按顺序取第一个存在的
+var idKeys = []string{"name", "prefix"}
+
+// ObjectName 对象的标识：属性中有 name 或 prefix 时取其值，subtype 另外记录在对象的 subtype 中，
+// 如 route add static { prefix 10.0.0.0/24 }；否则 subtype 就是对象名，如 acl add inbound { ... }
+func ObjectName(subtype string, attrs map[string]interface{}) (name string, typed bool) {
+	for _, k := range idKeys {
+		if v, ok := attrs[k]; ok && isIdentity(v) {
+			return fmt.Sprint(v), true
+		}
+	}
+	return subtype, false
+}
+
+func isIdentity(v interface{}) bool {
+	switch v.(type) {
+	case string, int, int64:
+		return true
+	}
+	return false
+}
+
+// Apply 按语句更新记录的对象；delete 没有给出标识属性时删除该 subtype 的全部对象
+func (s *Store) Apply(cmd *Command) {
+	spec := func(subtype string, attrs map[string]interface{}) (string, module.Spec) {
+		name, typed := ObjectName(subtype, attrs)
+		sp := module.Spec{"name": name}
+		for k, v := range attrs {
+			sp[k] = v
+		}
+		if typed && subtype != "" {
+			sp["subtype"] = subtype
+		}
+		return name, sp
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch strings.ToLower(cmd.Verb) {
+	case "add":
+		name, sp := spec(cmd.Subtype, cmd.Attrs)
+		s.objs[name] = sp
+	case "set":
+		name, sp := spec(cmd.Subtype, cmd.Attrs)
+		if old, ok := s.objs[name]; ok {
+			for k, v := range sp {
+				old[k] = v
+			}
+			break
+		}
+		s.objs[name] = sp
+	case "delete":
+		name, typed := ObjectName(cmd.Subtype, cmd.Attrs)
+		if _, ok := s.objs[name]; ok || typed {
+			delete(s.objs, name)
+			break
+		}
+		for k, sp := range s.objs {
+			if fmt.Sprint(sp["subtype"]) == cmd.Subtype {
+				delete(s.objs, k)
+			}
+		}
+	case "sync":
+		s.objs = map[string]module.Spec{}
+		for _, b := range cmd.Blocks {
+			name, sp := spec(b.Subtype, b.Attrs)
+			s.objs[name] = sp
+		}
+	}
+}
+
+func (s *Store) Get(name string) (module.Spec, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	sp, ok := s.objs[name]
+	if !ok {
+		return nil, fmt.Errorf("%s '%s' not found", s.kind, name)
+	}
+	return copySpec(sp), nil
+}
+
+// List 按名称排序
+func (s *Store) List() ([]module.Spec, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.objs))
+	for name := range s.objs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	specs := make([]module.Spec, len(names))
+	for i, name := range names {
+		specs[i] = copySpec(s.objs[name])
+	}
+	return specs, nil
+}
+
+func copySpec(sp module.Spec) module.Spec {
+	c := make(module.Spec, len(sp))
+	for k, v := range sp {
+		c[k] = v
+	}
+	return c
+}
+
+// record 执行成功后更新 kind 的 Store
+func record(cmd *Command) {
+	if st, ok := sources[strings.ToLower(cmd.Kind)].(*Store); ok {
+		st.Apply(cmd)
+	}
+}
@@ -2,27 +2,29 @@ package dsl
 
 import (
 	"fmt"
+	"io"
 	"strings"
 )
 
 func init() {
 	Register("route", execRoute)
+	RegisterSource("route", NewStore("route"))
 }
 
-func execRoute(cmd *Command) error {
+func execRoute(cmd *Command, w io.Writer) error {
 	switch strings.ToLower(cmd.Verb) {
 	case "add":
-		fmt.Println("[route add]", cmd.Subtype, cmd.Attrs)
+		fmt.Fprintln(w, "[route add]", cmd.Subtype, cmd.Attrs)
 	case "set":
-		fmt.Println("[route set]", cmd.Subtype, cmd.Attrs)
+		fmt.Fprintln(w, "[route set]", cmd.Subtype, cmd.Attrs)
 	case "delete":
-		fmt.Println("[route del]", cmd.Subtype, cmd.Attrs)
+		fmt.Fprintln(w, "[route del]", cmd.Subtype, cmd.Attrs)
 	case "sync":
 		for _, b := range cmd.Blocks {
-			fmt.Println("[route sync]", b.Subtype, b.Attrs)
+			fmt.Fprintln(w, "[route sync]", b.Subtype, b.Attrs)
 		}
 	default:
-		fmt.Println("[route] unknown verb", cmd.Verb)
+		fmt.Fprintln(w, "[route] unknown verb", cmd.Verb)
 	}
 	return nil
 }
@@ -0,0 +1,44 @@
+// pkg/runtime/eventbus/eventbus.go
+package eventbus
+
+import (
+	"flyos/pkg/module"
+	"sync"
+)
+
+// EventBus 不依赖 Runtime 的其他部分，flyos shell 也可以单独使用
+type EventBus struct {
+	listeners map[string][]func(module.Event)
+	mu        sync.RWMutex
+}
+
+var defaultBus = New()
+
+// Default 进程内共享的总线：Runtime 使用它，flyos shell 在同一进程中通过它发布事件
+func Default() *EventBus {
+	return defaultBus
+}
+
+func New() *EventBus {
+	return &EventBus{
+		listeners: make(map[string][]func(module.Event)),
+	}
+}
+
+func (eb *EventBus) Subscribe(topic string, fn func(module.Event)) {
+	eb.mu.Lock()
+	defer eb.mu.Unlock()
+	eb.listeners[topic] = append(eb.listeners[topic], fn)
+}
+
+func (eb *EventBus) Publish(event module.Event) {
+	eb.mu.RLock()
+	defer eb.mu.RUnlock()
+
+	// 广播给所有订阅者（实际可按 event.Type 路由）
+	for _, fns := range eb.listeners {
+		for _, fn := range fns {
+			go fn(event) // 异步处理
+		}
+	}
+}
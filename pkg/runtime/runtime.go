@@ -131,6 +131,11 @@ func (rt *Runtime) ExecuteFromJSON(kind string, data map[string]interface{}) err
 	return execObj.Execute(verb)
 }
 
+// EventBus Runtime 的事件总线，交给 flyos shell 等同进程的组件发布事件
+func (rt *Runtime) EventBus() *EventBus {
+	return rt.eventBus
+}
+
 // 发布事件
 func (rt *Runtime) PublishEvent(typ string, data map[string]interface{}) {
 	rt.eventBus.Publish(module.Event{Type: typ, Data: data})
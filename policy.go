@@ -0,0 +1,360 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// policyExecArg flyos 以此参数重新执行自身，设置 rlimit 与 nice 后再 exec 目标命令，
+// 保证限制在目标命令的第一条指令之前生效
+const policyExecArg = "__flyos_exec"
+
+// cpuSlack rusage 中的 CPU 时间与内核按 RLIMIT_CPU 计时的误差，到达限制时前者可能略少
+const cpuSlack = 100 * time.Millisecond
+
+func init() {
+	if len(os.Args) > 2 && os.Args[1] == policyExecArg {
+		os.Exit(execWithLimits(os.Args[2:]))
+	}
+}
+
+// ExecPolicy desc.toml 中为外部命令声明的执行限制
+type ExecPolicy struct {
+	Timeout    time.Duration // 超时后终止整个进程组
+	MaxMemory  uint64        // RLIMIT_AS，字节
+	MaxCPU     uint64        // RLIMIT_CPU，秒
+	RunAsUser  string        // 以指定用户身份运行，需要 flyos 具有 root 权限
+	Nice       int           // 调度优先级
+	credential *syscall.Credential
+
+	raw map[string]string // 限制名 -> desc.toml 中的原始写法，用于错误提示
+}
+
+// PolicyError 命令因触发执行限制而结束
+type PolicyError struct {
+	Limit string // desc.toml 中的限制名，如 timeout、max_cpu_seconds
+	Value string
+	Code  int // 退出码
+}
+
+func (e *PolicyError) Error() string {
+	switch e.Limit {
+	case "timeout":
+		return fmt.Sprintf("超过 timeout=%s，已终止进程组", e.Value)
+	case "max_memory":
+		return fmt.Sprintf("进程异常退出，可能超出 max_memory=%s", e.Value)
+	case "run_as_user":
+		return fmt.Sprintf("run_as_user=%s 需要以 root 身份运行 flyos", e.Value)
+	}
+	return fmt.Sprintf("超出 %s=%s", e.Limit, e.Value)
+}
+
+// Policy 解析 desc 中的执行限制，未声明任何限制时返回 nil
+func (d CommandDesc) Policy() (*ExecPolicy, error) {
+	p := &ExecPolicy{raw: make(map[string]string)}
+	if d.Timeout != nil {
+		timeout, err := parseTimeout(d.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("timeout 无效: %v", err)
+		}
+		p.Timeout = timeout
+		p.raw["timeout"] = timeout.String()
+	}
+	if d.MaxMemory != nil {
+		size, err := parseSize(d.MaxMemory)
+		if err != nil {
+			return nil, fmt.Errorf("max_memory 无效: %v", err)
+		}
+		p.MaxMemory = size
+		p.raw["max_memory"] = fmt.Sprint(d.MaxMemory)
+	}
+	if d.MaxCPUSeconds < 0 {
+		return nil, fmt.Errorf("max_cpu_seconds 无效: %d", d.MaxCPUSeconds)
+	}
+	if d.MaxCPUSeconds > 0 {
+		p.MaxCPU = uint64(d.MaxCPUSeconds)
+		p.raw["max_cpu_seconds"] = strconv.FormatInt(d.MaxCPUSeconds, 10)
+	}
+	if d.Nice < -20 || d.Nice > 19 {
+		return nil, fmt.Errorf("nice 应在 -20 到 19 之间: %d", d.Nice)
+	}
+	p.Nice = d.Nice
+	if d.RunAsUser != "" {
+		cred, err := lookupCredential(d.RunAsUser)
+		if err != nil {
+			return nil, fmt.Errorf("run_as_user 无效: %v", err)
+		}
+		p.RunAsUser = d.RunAsUser
+		p.credential = cred
+		p.raw["run_as_user"] = d.RunAsUser
+	}
+	if len(p.raw) == 0 && p.Nice == 0 {
+		return nil, nil
+	}
+	return p, nil
+}
+
+// parseTimeout 整数为秒，字符串按 time.ParseDuration 解析，如 "90s"、"5m"
+func parseTimeout(v interface{}) (time.Duration, error) {
+	var d time.Duration
+	switch val := v.(type) {
+	case int64:
+		d = time.Duration(val) * time.Second
+	case float64:
+		d = time.Duration(val * float64(time.Second))
+	case string:
+		var err error
+		if d, err = time.ParseDuration(val); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, fmt.Errorf("不支持的类型 %T", v)
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("必须大于 0: %v", v)
+	}
+	return d, nil
+}
+
+// parseSize 整数为字节，字符串支持 K / M / G / T 后缀（1024 进制），如 "512M"
+func parseSize(v interface{}) (uint64, error) {
+	switch val := v.(type) {
+	case int64:
+		if val <= 0 {
+			return 0, fmt.Errorf("必须大于 0: %d", val)
+		}
+		return uint64(val), nil
+	case float64:
+		// JSON 中的数字
+		if val <= 0 || val != float64(uint64(val)) {
+			return 0, fmt.Errorf("应为正整数: %v", val)
+		}
+		return uint64(val), nil
+	case string:
+		s := strings.ToUpper(strings.TrimSpace(val))
+		s = strings.TrimSuffix(strings.TrimSuffix(s, "B"), "I")
+		shift := 0
+		if n := len(s); n > 0 {
+			if i := strings.IndexByte("KMGT", s[n-1]); i >= 0 {
+				shift = 10 * (i + 1)
+				s = s[:n-1]
+			}
+		}
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, 64)
+		if err != nil || n == 0 {
+			return 0, fmt.Errorf("无法解析 %q", val)
+		}
+		return n << shift, nil
+	}
+	return 0, fmt.Errorf("不支持的类型 %T", v)
+}
+
+// lookupCredential 用户名或 uid 转换为进程凭据，包含附加组
+func lookupCredential(name string) (*syscall.Credential, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		if u, err = user.LookupId(name); err != nil {
+			return nil, err
+		}
+	}
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	cred := &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	if ids, err := u.GroupIds(); err == nil {
+		for _, id := range ids {
+			if g, err := strconv.ParseUint(id, 10, 32); err == nil {
+				cred.Groups = append(cred.Groups, uint32(g))
+			}
+		}
+	}
+	return cred, nil
+}
+
+// prepare 按限制改写 cmd：rlimit 与 nice 通过 flyos 自身中转，身份切换交给 SysProcAttr
+func (p *ExecPolicy) prepare(cmd *exec.Cmd) error {
+	if p.credential != nil && int(p.credential.Uid) != os.Getuid() {
+		if os.Geteuid() != 0 {
+			return &PolicyError{Limit: "run_as_user", Value: p.RunAsUser, Code: 126}
+		}
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.Credential = p.credential
+	}
+	if p.MaxMemory == 0 && p.MaxCPU == 0 && p.Nice == 0 {
+		return nil
+	}
+	self, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := []string{self, policyExecArg,
+		"as=" + strconv.FormatUint(p.MaxMemory, 10),
+		"cpu=" + strconv.FormatUint(p.MaxCPU, 10),
+		"nice=" + strconv.Itoa(p.Nice),
+		"--"}
+	cmd.Args = append(args, cmd.Args...)
+	cmd.Path = self
+	return nil
+}
+
+// watch 进程启动后开始计时，超时先发 SIGTERM，仍未退出再发 SIGKILL
+func (p *ExecPolicy) watch() (onStart func(pid int), stop func() bool) {
+	var (
+		timedOut atomic.Bool
+		timer    atomic.Pointer[time.Timer]
+	)
+	onStart = func(pid int) {
+		if p.Timeout <= 0 {
+			return
+		}
+		timer.Store(time.AfterFunc(p.Timeout, func() {
+			pgid, err := syscall.Getpgid(pid)
+			if err != nil {
+				return
+			}
+			timedOut.Store(true)
+			syscall.Kill(-pgid, syscall.SIGTERM)
+			syscall.Kill(-pgid, syscall.SIGCONT)
+			time.AfterFunc(3*time.Second, func() { syscall.Kill(-pgid, syscall.SIGKILL) })
+		}))
+	}
+	stop = func() bool {
+		if t := timer.Load(); t != nil {
+			t.Stop()
+		}
+		return timedOut.Load()
+	}
+	return onStart, stop
+}
+
+// explain 将触发限制导致的退出转换为 PolicyError
+func (p *ExecPolicy) explain(err error, timedOut bool) error {
+	if err == nil {
+		return nil
+	}
+	code := exitStatus(err)
+	switch {
+	case timedOut:
+		return &PolicyError{Limit: "timeout", Value: p.raw["timeout"], Code: 124}
+	case p.MaxCPU > 0 && code == 128+int(syscall.SIGXCPU):
+		return &PolicyError{Limit: "max_cpu_seconds", Value: p.raw["max_cpu_seconds"], Code: code}
+	case p.MaxCPU > 0 && code == 128+int(syscall.SIGKILL) && cpuTime(err)+cpuSlack >= time.Duration(p.MaxCPU)*time.Second:
+		// 忽略 SIGXCPU 的进程由硬限制 SIGKILL；其他来源的 SIGKILL 不算作 CPU 限制
+		return &PolicyError{Limit: "max_cpu_seconds", Value: p.raw["max_cpu_seconds"], Code: code}
+	case p.MaxMemory > 0 && code > 128 && maxRSS(err) >= p.MaxMemory/2:
+		// RLIMIT_AS 只让分配失败，进程随后通常因 SIGSEGV / SIGABRT / SIGBUS 退出；
+		// 常驻内存远未接近上限时是程序自身的错误，SIGKILL 不会由 RLIMIT_AS 引起
+		switch syscall.Signal(code - 128) {
+		case syscall.SIGSEGV, syscall.SIGABRT, syscall.SIGBUS:
+			return &PolicyError{Limit: "max_memory", Value: p.raw["max_memory"], Code: code}
+		}
+	}
+	return err
+}
+
+// rusage 已退出进程的资源用量：直接运行时来自 exec.ExitError，作业中运行时来自 exitCodeError
+func rusage(err error) *syscall.Rusage {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		ru, _ := exitErr.SysUsage().(*syscall.Rusage)
+		return ru
+	}
+	var codeErr *exitCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.rusage
+	}
+	return nil
+}
+
+// cpuTime 已退出进程消耗的用户态与内核态 CPU 时间，无法取得时为 0
+func cpuTime(err error) time.Duration {
+	ru := rusage(err)
+	if ru == nil {
+		return 0
+	}
+	return time.Duration(ru.Utime.Nano() + ru.Stime.Nano())
+}
+
+// maxRSS 已退出进程的峰值常驻内存（字节），无法取得时为 0
+func maxRSS(err error) uint64 {
+	ru := rusage(err)
+	if ru == nil || ru.Maxrss < 0 {
+		return 0
+	}
+	return uint64(ru.Maxrss) * 1024
+}
+
+// execWithLimits 中转进程：设置 rlimit 与 nice 后 exec 目标命令，失败返回 126
+func execWithLimits(args []string) int {
+	var (
+		limits = make(map[string]uint64)
+		nice   int
+	)
+	for len(args) > 0 && args[0] != "--" {
+		key, val, _ := strings.Cut(args[0], "=")
+		if key == "nice" {
+			nice, _ = strconv.Atoi(val)
+		} else {
+			limits[key], _ = strconv.ParseUint(val, 10, 64)
+		}
+		args = args[1:]
+	}
+	if len(args) < 2 {
+		fmt.Fprintln(os.Stderr, "💥 执行限制参数错误")
+		return 126
+	}
+	args = args[1:]
+
+	if n := limits["as"]; n > 0 {
+		if err := setRlimit(syscall.RLIMIT_AS, n, n); err != nil {
+			fmt.Fprintf(os.Stderr, "💥 设置 max_memory 失败: %v\n", err)
+			return 126
+		}
+	}
+	if n := limits["cpu"]; n > 0 {
+		// 软限制到达时收到 SIGXCPU，再过 1 秒由硬限制强制结束
+		if err := setRlimit(syscall.RLIMIT_CPU, n, n+1); err != nil {
+			fmt.Fprintf(os.Stderr, "💥 设置 max_cpu_seconds 失败: %v\n", err)
+			return 126
+		}
+	}
+	if nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PROCESS, 0, nice); err != nil {
+			fmt.Fprintf(os.Stderr, "💥 设置 nice=%d 失败: %v\n", nice, err)
+			return 126
+		}
+	}
+	err := syscall.Exec(args[0], args, os.Environ())
+	fmt.Fprintf(os.Stderr, "💥 执行失败 [%s]: %v\n", args[0], err)
+	return 126
+}
+
+// setRlimit 不超过当前硬限制
+func setRlimit(resource int, cur, max uint64) error {
+	var old syscall.Rlimit
+	if err := syscall.Getrlimit(resource, &old); err != nil {
+		return err
+	}
+	if max > old.Max {
+		max = old.Max
+	}
+	if cur > max {
+		cur = max
+	}
+	return syscall.Setrlimit(resource, &syscall.Rlimit{Cur: cur, Max: max})
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+)
+
+func TestExplainCPULimit(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("没有 sh")
+	}
+	p := &ExecPolicy{MaxCPU: 5, raw: map[string]string{"max_cpu_seconds": "5"}}
+	run := func(script string) error {
+		return p.explain(exec.Command("sh", "-c", script).Run(), false)
+	}
+
+	var pe *PolicyError
+	if err := run("kill -XCPU $$"); !errors.As(err, &pe) || pe.Limit != "max_cpu_seconds" || pe.Code != 152 {
+		t.Errorf("SIGXCPU: got %v, want max_cpu_seconds", err)
+	}
+	// 没有用完 CPU 时间的 SIGKILL 来自其他地方，不归咎于限制
+	if err := run("kill -KILL $$"); errors.As(err, &pe) || exitStatus(err) != 137 {
+		t.Errorf("SIGKILL: got %v, want plain exit 137", err)
+	}
+	if err := run("exit 3"); errors.As(err, &pe) || exitStatus(err) != 3 {
+		t.Errorf("exit 3: got %v", err)
+	}
+}
+
+func TestExplainJobPath(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("没有 sh")
+	}
+	// REPL 中外部命令在作业中运行，Job.run 返回带资源用量的 exitCodeError
+	run := func(p *ExecPolicy, script string) error {
+		j := newJob(1, script, true, false)
+		return p.explain(j.run(exec.Command("sh", "-c", script), nil), false)
+	}
+	var pe *PolicyError
+
+	cpu := &ExecPolicy{MaxCPU: 1, raw: map[string]string{"max_cpu_seconds": "1"}}
+	// 忽略 SIGXCPU 后由硬限制 SIGKILL
+	if err := run(cpu, `trap "" XCPU; ulimit -t 1; while :; do :; done`); !errors.As(err, &pe) || pe.Limit != "max_cpu_seconds" || pe.Code != 137 {
+		t.Errorf("CPU 硬限制: got %v, want max_cpu_seconds", err)
+	}
+	if err := run(cpu, "kill -KILL $$"); errors.As(err, &pe) || exitStatus(err) != 137 {
+		t.Errorf("SIGKILL: got %v, want plain exit 137", err)
+	}
+
+	// 常驻内存远未接近上限时的崩溃不归咎于 max_memory
+	mem := &ExecPolicy{MaxMemory: 1 << 40, raw: map[string]string{"max_memory": "1T"}}
+	for _, script := range []string{"kill -SEGV $$", "kill -KILL $$"} {
+		if err := run(mem, script); errors.As(err, &pe) {
+			t.Errorf("%s: got %v, want plain exit", script, err)
+		}
+	}
+	if err := run(mem, "exit 3"); errors.As(err, &pe) || exitStatus(err) != 3 {
+		t.Errorf("exit 3: got %v", err)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// RBACConfig config.toml [rbac]，未定义任何角色时不启用授权；
+// 只从 /etc/flyos 中 root 所有的配置读取，用户目录下的设置被忽略（见 isTrustedLayer）。
+// 启用授权后不能导出 LD_PRELOAD 等动态链接器变量（见 isLoaderVar）。外部命令的分类
+// 同样只取自 /etc/flyos/desc.toml（见 authCategory）
+//
+//	[rbac]
+//	default_role = "viewer"          # 未匹配用户或组时使用
+//	[rbac.users]
+//	alice = "operator"
+//	[rbac.groups]
+//	wheel = "admin"
+//	[rbac.roles.viewer]
+//	allow_categories = ["sys", "status"]
+//	deny_commands    = ["*-delete"]
+//	[rbac.roles.admin]
+//	allow_categories = ["*"]
+type RBACConfig struct {
+	DefaultRole string                `toml:"default_role"`
+	Users       map[string]string     `toml:"users"`  // OS 用户名 -> 角色
+	Groups      map[string]string     `toml:"groups"` // OS 组名 -> 角色
+	Roles       map[string]RoleConfig `toml:"roles"`
+}
+
+// RoleConfig 角色的授权规则，分类与命令名均支持通配符；deny 优先于 allow
+type RoleConfig struct {
+	AllowCategories []string `toml:"allow_categories"`
+	DenyCategories  []string `toml:"deny_categories"`
+	AllowCommands   []string `toml:"allow_commands"`
+	DenyCommands    []string `toml:"deny_commands"`
+}
+
+// alwaysAllowed 任何角色都可以执行的内置命令，避免配置错误时无法退出或查看帮助
+var alwaysAllowed = map[string]bool{"exit": true, "help": true, "list": true}
+
+// Authorizer 按当前 OS 用户的角色判断命令是否可见、可执行
+type Authorizer struct {
+	mu      sync.RWMutex
+	enabled bool
+	roles   []string // 当前用户的角色，多个角色取并集
+	rules   []RoleConfig
+
+	user   string
+	groups []string
+}
+
+func NewAuthorizer() *Authorizer {
+	a := &Authorizer{}
+	if u, err := user.Current(); err == nil {
+		a.user = u.Username
+		if ids, err := u.GroupIds(); err == nil {
+			for _, id := range ids {
+				if g, err := user.LookupGroupId(id); err == nil {
+					a.groups = append(a.groups, g.Name)
+				}
+			}
+		}
+	}
+	return a
+}
+
+// Configure 根据配置确定当前用户的角色，配置热加载时重新调用
+func (a *Authorizer) Configure(cfg RBACConfig) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.enabled = len(cfg.Roles) > 0
+	a.roles, a.rules = nil, nil
+	if !a.enabled {
+		return
+	}
+
+	seen := make(map[string]bool)
+	add := func(role string) {
+		if role == "" || seen[role] {
+			return
+		}
+		seen[role] = true
+		rule, ok := cfg.Roles[role]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "⚠️ rbac 中未定义角色: %s\n", role)
+			return
+		}
+		a.roles = append(a.roles, role)
+		a.rules = append(a.rules, rule)
+	}
+	add(cfg.Users[a.user])
+	groups := append([]string(nil), a.groups...)
+	sort.Strings(groups)
+	for _, g := range groups {
+		add(cfg.Groups[g])
+	}
+	if len(a.roles) == 0 {
+		add(cfg.DefaultRole)
+	}
+}
+
+// Enabled 是否启用了授权
+func (a *Authorizer) Enabled() bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.enabled
+}
+
+// Roles 当前用户的角色，未启用授权时返回 nil
+func (a *Authorizer) Roles() []string {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return append([]string(nil), a.roles...)
+}
+
+// Allowed 任一角色允许即可执行
+func (a *Authorizer) Allowed(name, category string) bool {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	if !a.enabled || alwaysAllowed[name] {
+		return true
+	}
+	for _, rule := range a.rules {
+		if rule.allows(name, category) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r RoleConfig) allows(name, category string) bool {
+	if matchAny(r.DenyCommands, name) || matchAny(r.DenyCategories, category) {
+		return false
+	}
+	return matchAny(r.AllowCommands, name) || matchAny(r.AllowCategories, category)
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, s); ok || strings.EqualFold(p, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// Allowed 当前用户是否可以看到并执行该命令
+func (s *Shell) Allowed(cmd Command) bool {
+	return s.auth.Allowed(cmd.Name(), authCategory(cmd))
+}
+
+// authCategory 授权使用的分类：内置命令的分类写在代码中；外部命令只采用可信 desc.toml 中的分类，
+// 未登记时为 default；别名与函数展开后的每条命令仍分别授权
+func authCategory(cmd Command) string {
+	f, ok := cmd.(*FileCommand)
+	if !ok {
+		return cmd.Category()
+	}
+	if f.descMgr != nil {
+		if category, ok := f.descMgr.trustedCategory(f.name); ok {
+			return category
+		}
+	}
+	return "default"
+}
+
+// permitted 按名称判断：已注册命令同 Allowed，仅在 desc.toml 中登记的命令取可信 desc.toml 中的分类
+func (s *Shell) permitted(name string, d *DescManager) bool {
+	if cmd, ok := s.Lookup(name); ok {
+		return s.Allowed(cmd)
+	}
+	category, ok := d.trustedCategory(name)
+	if !ok {
+		category = "default"
+	}
+	return s.auth.Allowed(name, category)
+}
+
+// deniedError 角色不允许执行
+type deniedError struct {
+	name  string
+	roles []string
+}
+
+func (e *deniedError) Error() string {
+	if len(e.roles) == 0 {
+		return fmt.Sprintf("无权执行 %s：当前用户未分配角色", e.name)
+	}
+	return fmt.Sprintf("无权执行 %s：角色 %s 不允许", e.name, strings.Join(e.roles, ", "))
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAllowedUsesTrustedCategory(t *testing.T) {
+	homeDir = t.TempDir()
+	s := NewShell(map[string]string{})
+	s.auth.Configure(RBACConfig{
+		DefaultRole: "viewer",
+		Roles: map[string]RoleConfig{
+			"viewer": {AllowCategories: []string{"status"}},
+		},
+	})
+
+	// 用户目录下的 desc.toml 把命令放到被允许的分类，不能因此获得授权
+	path := filepath.Join(homeDir, "desc.toml")
+	if err := os.WriteFile(path, []byte("[status.reboot]\ndesc = \"重启\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d := NewDescManager()
+	if err := d.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	d.LoadTrusted(path)
+	cmd := &FileCommand{name: "reboot", path: "/sbin/reboot", category: "status", descMgr: d}
+	if got := cmd.Category(); got != "status" {
+		t.Errorf("显示的分类 = %q, want status", got)
+	}
+	if s.Allowed(cmd) {
+		t.Error("用户可写的 desc.toml 中的分类被用于授权")
+	}
+	if s.permitted("reboot", d) {
+		t.Error("permitted 采用了用户可写的 desc.toml 中的分类")
+	}
+
+	// 可信 desc.toml 中的分类才用于授权
+	d.trusted.Store("reboot", "status")
+	if !s.Allowed(cmd) {
+		t.Error("可信 desc.toml 中的分类未用于授权")
+	}
+
+	// 内置命令的分类来自代码
+	if !s.Allowed(&ListCommand{shell: s}) {
+		t.Error("list 应始终允许")
+	}
+	if s.Allowed(NewSetCommand(s)) {
+		t.Error("sys 分类的 set 不应允许")
+	}
+}
@@ -0,0 +1,377 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ArgSchema desc.toml 中可选的参数定义，声明后 flyos 在执行前检查参数
+//
+//	[net.ifstat.schema]
+//	positional = [
+//	    { name = "IFACE", type = "interface", required = true },
+//	    { name = "COUNT", type = "int", default = 5, min = 1 },
+//	]
+//	flags = [
+//	    { name = "--mode", short = "-m", type = "enum", values = ["fast", "slow"], default = "fast" },
+//	    { name = "--verbose", short = "-v", type = "bool" },
+//	]
+type ArgSchema struct {
+	Positional []ArgSpec `toml:"positional" json:"positional"`
+	Flags      []ArgSpec `toml:"flags" json:"flags"`
+	Extra      bool      `toml:"extra" json:"extra"` // 允许未声明的选项与多余的位置参数
+}
+
+// ArgSpec 一个位置参数或选项
+type ArgSpec struct {
+	Name     string      `toml:"name" json:"name"`   // 位置参数的显示名，或选项的长名如 --mode
+	Short    string      `toml:"short" json:"short"` // 选项的短名如 -m
+	Type     string      `toml:"type" json:"type"`   // string（默认）、int、ip、cidr、enum、interface、bool（仅选项）
+	Values   []string    `toml:"values" json:"values"`
+	Required bool        `toml:"required" json:"required"`
+	Default  interface{} `toml:"default" json:"default"` // 未提供时补上，位置参数补在末尾，选项补在命令名之后
+	Min      *int64      `toml:"min" json:"min"`
+	Max      *int64      `toml:"max" json:"max"`
+	Variadic bool        `toml:"variadic" json:"variadic"` // 仅最后一个位置参数，可重复出现
+	Desc     string      `toml:"desc" json:"desc"`
+}
+
+var argTypes = map[string]bool{"": true, "string": true, "int": true, "ip": true, "cidr": true, "enum": true, "interface": true, "bool": true}
+
+// defaultValue 默认值的字符串形式，未设置时为空
+func (a *ArgSpec) defaultValue() string {
+	if a.Default == nil {
+		return ""
+	}
+	return fmt.Sprint(a.Default)
+}
+
+// check 检查 schema 本身是否有效
+func (s *ArgSchema) check() error {
+	optional := false
+	for i, a := range s.Positional {
+		if a.Name == "" {
+			return fmt.Errorf("positional[%d] 缺少 name", i)
+		}
+		if a.Type == "bool" {
+			return fmt.Errorf("位置参数 %s 不能是 bool", a.Name)
+		}
+		if a.Variadic && i != len(s.Positional)-1 {
+			return fmt.Errorf("只有最后一个位置参数可以是 variadic: %s", a.Name)
+		}
+		if a.Required && optional {
+			return fmt.Errorf("必填参数 %s 不能位于可选参数之后", a.Name)
+		}
+		optional = optional || !a.Required
+		if err := a.checkSpec(); err != nil {
+			return err
+		}
+	}
+	for i, a := range s.Flags {
+		if !strings.HasPrefix(a.Name, "-") {
+			return fmt.Errorf("flags[%d] 的 name 应以 - 开头: %q", i, a.Name)
+		}
+		if a.Short != "" && !strings.HasPrefix(a.Short, "-") {
+			return fmt.Errorf("选项 %s 的 short 应以 - 开头: %q", a.Name, a.Short)
+		}
+		if a.Type == "bool" && (a.Required || a.Default != nil) {
+			return fmt.Errorf("bool 选项 %s 不能设置 required 或 default", a.Name)
+		}
+		if err := a.checkSpec(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *ArgSpec) checkSpec() error {
+	if !argTypes[a.Type] {
+		return fmt.Errorf("%s 的类型未知: %s", a.Name, a.Type)
+	}
+	if a.Type == "enum" && len(a.Values) == 0 {
+		return fmt.Errorf("enum 参数 %s 缺少 values", a.Name)
+	}
+	if a.Default != nil && a.Type != "interface" {
+		if err := a.validate(a.defaultValue()); err != nil {
+			return fmt.Errorf("%s 的 default %v", a.Name, err)
+		}
+	}
+	return nil
+}
+
+// validate 按类型检查一个值
+func (a *ArgSpec) validate(v string) error {
+	switch a.Type {
+	case "int":
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("应为整数")
+		}
+		if a.Min != nil && n < *a.Min {
+			return fmt.Errorf("不能小于 %d", *a.Min)
+		}
+		if a.Max != nil && n > *a.Max {
+			return fmt.Errorf("不能大于 %d", *a.Max)
+		}
+	case "ip":
+		if net.ParseIP(v) == nil {
+			return fmt.Errorf("应为 IP 地址")
+		}
+	case "cidr":
+		if _, _, err := net.ParseCIDR(v); err != nil {
+			return fmt.Errorf("应为 CIDR，如 10.0.0.0/24")
+		}
+	case "enum":
+		for _, allowed := range a.Values {
+			if v == allowed {
+				return nil
+			}
+		}
+		return fmt.Errorf("应为 %s 之一", strings.Join(a.Values, "、"))
+	case "interface":
+		if _, err := net.InterfaceByName(v); err != nil {
+			return fmt.Errorf("网络接口不存在")
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(v); err != nil {
+			return fmt.Errorf("应为 true 或 false")
+		}
+	}
+	return nil
+}
+
+// ArgError 参数不符合 schema，Index 为出错参数在 argv 中的位置，-1 表示缺少参数
+type ArgError struct {
+	Argv  []string
+	Index int
+	Msg   string
+}
+
+func (e *ArgError) Error() string { return e.Msg }
+
+// render 打印错误并在命令行中标出出错的参数
+func (e *ArgError) render(w io.Writer, usage string) {
+	fmt.Fprintf(w, "❌ %s: %s\n", e.Argv[0], e.Msg)
+	line := make([]string, len(e.Argv))
+	offset, width := 0, 0
+	for i, arg := range e.Argv {
+		if strings.ContainsAny(arg, " \t'\"") || arg == "" {
+			arg = shellQuote(arg)
+		}
+		line[i] = arg
+		if i < e.Index {
+			offset += utf8.RuneCountInString(arg) + 1
+		} else if i == e.Index {
+			width = utf8.RuneCountInString(arg)
+		}
+	}
+	joined := strings.Join(line, " ")
+	if e.Index < 0 {
+		offset, width = utf8.RuneCountInString(joined)+1, 1
+	}
+	fmt.Fprintf(w, "   %s\n   %s%s\n", joined, strings.Repeat(" ", offset), strings.Repeat("^", width))
+	if usage != "" {
+		fmt.Fprintf(w, "📌 用法: %s\n", usage)
+	}
+}
+
+// Apply 检查 argv，返回补上默认值后的 argv
+func (s *ArgSchema) Apply(argv []string) ([]string, error) {
+	fail := func(i int, format string, a ...any) error {
+		return &ArgError{Argv: argv, Index: i, Msg: fmt.Sprintf(format, a...)}
+	}
+	flag := func(name string) *ArgSpec {
+		for i := range s.Flags {
+			if s.Flags[i].Name == name || (s.Flags[i].Short != "" && s.Flags[i].Short == name) {
+				return &s.Flags[i]
+			}
+		}
+		return nil
+	}
+	// positional 下一个位置参数的定义，没有时为 nil
+	positional := func(npos int) *ArgSpec {
+		switch {
+		case npos < len(s.Positional):
+			return &s.Positional[npos]
+		case len(s.Positional) > 0 && s.Positional[len(s.Positional)-1].Variadic:
+			return &s.Positional[len(s.Positional)-1]
+		}
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	npos := 0
+	dashdash := false
+	for i := 1; i < len(argv); i++ {
+		arg := argv[i]
+		if !dashdash && arg == "--" {
+			dashdash = true
+			continue
+		}
+		if !dashdash && strings.HasPrefix(arg, "-") && arg != "-" && !negativeInt(arg, flag, positional(npos)) {
+			name, val, hasVal := strings.Cut(arg, "=")
+			spec := flag(name)
+			if spec == nil {
+				if s.Extra {
+					continue
+				}
+				return nil, fail(i, "未知选项 %s", name)
+			}
+			if spec.Type == "bool" {
+				if hasVal {
+					if err := spec.validate(val); err != nil {
+						return nil, fail(i, "选项 %s %v", spec.Name, err)
+					}
+				}
+				seen[spec.Name] = true
+				continue
+			}
+			if !hasVal {
+				if i+1 >= len(argv) {
+					return nil, fail(i, "选项 %s 缺少值", spec.Name)
+				}
+				i++
+				val = argv[i]
+			}
+			if err := spec.validate(val); err != nil {
+				return nil, fail(i, "选项 %s %v: %s", spec.Name, err, val)
+			}
+			seen[spec.Name] = true
+			continue
+		}
+
+		spec := positional(npos)
+		switch {
+		case spec != nil:
+		case s.Extra:
+			npos++
+			continue
+		default:
+			return nil, fail(i, "多余的参数: %s", arg)
+		}
+		if err := spec.validate(arg); err != nil {
+			return nil, fail(i, "参数 %s %v: %s", spec.Name, err, arg)
+		}
+		npos++
+	}
+
+	var defaults []string
+	for _, spec := range s.Flags {
+		if seen[spec.Name] {
+			continue
+		}
+		if spec.Required {
+			return nil, fail(-1, "缺少选项 %s", spec.Name)
+		}
+		if spec.Default != nil {
+			defaults = append(defaults, spec.Name, spec.defaultValue())
+		}
+	}
+	result := append(append([]string{argv[0]}, defaults...), argv[1:]...)
+	for i := npos; i < len(s.Positional); i++ {
+		spec := s.Positional[i]
+		if spec.Required {
+			return nil, fail(-1, "缺少参数 %s", spec.Name)
+		}
+		if spec.Default == nil {
+			break
+		}
+		result = append(result, spec.defaultValue())
+	}
+	return result, nil
+}
+
+// negativeInt 以 - 开头的参数不是已声明的选项、下一个位置参数为 int 且它是整数时，按位置参数处理，如 -5
+func negativeInt(arg string, flag func(string) *ArgSpec, next *ArgSpec) bool {
+	if next == nil || next.Type != "int" {
+		return false
+	}
+	if name, _, _ := strings.Cut(arg, "="); flag(name) != nil {
+		return false
+	}
+	_, err := strconv.ParseInt(arg, 10, 64)
+	return err == nil
+}
+
+// describe 帮助中显示的参数说明
+func (s *ArgSchema) describe() []string {
+	var lines []string
+	add := func(name string, a ArgSpec) {
+		typ := a.Type
+		if typ == "" {
+			typ = "string"
+		}
+		if typ == "enum" {
+			typ += "(" + strings.Join(a.Values, "|") + ")"
+		}
+		var notes []string
+		if a.Required {
+			notes = append(notes, "必填")
+		}
+		if a.Default != nil {
+			notes = append(notes, "默认 "+a.defaultValue())
+		}
+		if a.Variadic {
+			notes = append(notes, "可重复")
+		}
+		if a.Desc != "" {
+			notes = append(notes, a.Desc)
+		}
+		line := fmt.Sprintf("%-16s %-12s", name, typ)
+		if len(notes) > 0 {
+			line += "  # " + strings.Join(notes, "，")
+		}
+		lines = append(lines, strings.TrimRight(line, " "))
+	}
+	for _, a := range s.Positional {
+		add(a.Name, a)
+	}
+	for _, a := range s.Flags {
+		name := a.Name
+		if a.Short != "" {
+			name = a.Short + ", " + a.Name
+		}
+		add(name, a)
+	}
+	return lines
+}
+
+func isFileCommand(cmd Command) bool {
+	_, ok := cmd.(*FileCommand)
+	return ok
+}
+
+// printSchema 帮助中列出 schema 定义的参数
+func (d *DescManager) printSchema(w io.Writer, name string) {
+	desc, ok := d.Get(name)
+	if !ok || desc.Schema == nil {
+		return
+	}
+	fmt.Fprintln(w, "🧾  Schema:")
+	for _, line := range desc.Schema.describe() {
+		fmt.Fprintln(w, "      "+line)
+	}
+}
+
+// checkSchema desc lint 使用：把 desc.toml 中的 schema 表解码后检查
+func checkSchema(v interface{}) error {
+	table, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("应为表")
+	}
+	data, err := toml.Marshal(table)
+	if err != nil {
+		return err
+	}
+	var schema ArgSchema
+	if err := toml.Unmarshal(data, &schema); err != nil {
+		return err
+	}
+	return schema.check()
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func int64p(n int64) *int64 { return &n }
+
+func TestSchemaCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema ArgSchema
+		err    string
+	}{
+		{"valid", ArgSchema{
+			Positional: []ArgSpec{{Name: "HOST", Type: "ip", Required: true}, {Name: "COUNT", Type: "int", Default: 5, Min: int64p(1)}},
+			Flags:      []ArgSpec{{Name: "--mode", Short: "-m", Type: "enum", Values: []string{"fast", "slow"}, Default: "fast"}},
+		}, ""},
+		{"missing name", ArgSchema{Positional: []ArgSpec{{Type: "int"}}}, "缺少 name"},
+		{"bool positional", ArgSchema{Positional: []ArgSpec{{Name: "X", Type: "bool"}}}, "不能是 bool"},
+		{"variadic not last", ArgSchema{Positional: []ArgSpec{{Name: "A", Variadic: true}, {Name: "B"}}}, "variadic"},
+		{"required after optional", ArgSchema{Positional: []ArgSpec{{Name: "A"}, {Name: "B", Required: true}}}, "不能位于可选参数之后"},
+		{"flag without dash", ArgSchema{Flags: []ArgSpec{{Name: "mode"}}}, "应以 - 开头"},
+		{"short without dash", ArgSchema{Flags: []ArgSpec{{Name: "--mode", Short: "m"}}}, "short 应以 - 开头"},
+		{"bool default", ArgSchema{Flags: []ArgSpec{{Name: "-v", Type: "bool", Default: true}}}, "不能设置 required 或 default"},
+		{"unknown type", ArgSchema{Flags: []ArgSpec{{Name: "--x", Type: "float"}}}, "类型未知"},
+		{"enum without values", ArgSchema{Flags: []ArgSpec{{Name: "--x", Type: "enum"}}}, "缺少 values"},
+		{"bad default", ArgSchema{Positional: []ArgSpec{{Name: "N", Type: "int", Default: 0, Min: int64p(1)}}}, "不能小于 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schema.check()
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("check() = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("check() = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestSchemaApply(t *testing.T) {
+	schema := ArgSchema{
+		Positional: []ArgSpec{
+			{Name: "HOST", Type: "ip", Required: true},
+			{Name: "OFFSET", Type: "int", Default: 0},
+		},
+		Flags: []ArgSpec{
+			{Name: "--mode", Short: "-m", Type: "enum", Values: []string{"fast", "slow"}, Default: "fast"},
+			{Name: "--count", Short: "-c", Type: "int", Min: int64p(1)},
+			{Name: "--verbose", Short: "-v", Type: "bool"},
+		},
+	}
+	tests := []struct {
+		name  string
+		argv  string
+		want  string
+		err   string
+		index int
+	}{
+		{"defaults", "cmd 10.0.0.1", "cmd --mode fast 10.0.0.1 0", "", 0},
+		{"flags", "cmd -m slow -v --count=3 10.0.0.1 7", "cmd -m slow -v --count=3 10.0.0.1 7", "", 0},
+		{"negative int positional", "cmd 10.0.0.1 -5", "cmd --mode fast 10.0.0.1 -5", "", 0},
+		{"negative after dashdash", "cmd -- 10.0.0.1 -5", "cmd --mode fast -- 10.0.0.1 -5", "", 0},
+		{"unknown flag", "cmd 10.0.0.1 -x", "", "未知选项 -x", 2},
+		{"negative where ip expected", "cmd -5", "", "未知选项 -5", 1},
+		{"bad flag value", "cmd -c 0 10.0.0.1", "", "选项 --count 不能小于 1: 0", 2},
+		{"missing flag value", "cmd 10.0.0.1 -m", "", "选项 --mode 缺少值", 2},
+		{"bad enum", "cmd --mode=warp 10.0.0.1", "", "应为 fast、slow 之一", 1},
+		{"bad positional", "cmd 10.0.0.1 x", "", "参数 OFFSET 应为整数: x", 2},
+		{"extra positional", "cmd 10.0.0.1 1 2", "", "多余的参数: 2", 3},
+		{"missing positional", "cmd -v", "", "缺少参数 HOST", -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := schema.Apply(strings.Fields(tt.argv))
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("Apply() = %v", err)
+				}
+				if strings.Join(got, " ") != tt.want {
+					t.Errorf("Apply() = %q, want %q", strings.Join(got, " "), tt.want)
+				}
+				return
+			}
+			var argErr *ArgError
+			if !errors.As(err, &argErr) {
+				t.Fatalf("Apply() = %v, want ArgError %q", err, tt.err)
+			}
+			if !strings.Contains(argErr.Msg, tt.err) || argErr.Index != tt.index {
+				t.Errorf("Apply() = %q at %d, want %q at %d", argErr.Msg, argErr.Index, tt.err, tt.index)
+			}
+		})
+	}
+
+	extra := ArgSchema{Positional: []ArgSpec{{Name: "N", Type: "int"}}, Extra: true}
+	if got, err := extra.Apply([]string{"cmd", "-5", "--other", "x"}); err != nil || len(got) != 4 {
+		t.Errorf("extra: Apply() = %q, %v", got, err)
+	}
+}
+
+func TestArgErrorRender(t *testing.T) {
+	tests := []struct {
+		name string
+		err  ArgError
+		want string
+	}{
+		{"index", ArgError{Argv: []string{"ping", "-c", "x", "10.0.0.1"}, Index: 2, Msg: "选项 --count 应为整数: x"},
+			"❌ ping: 选项 --count 应为整数: x\n   ping -c x 10.0.0.1\n           ^\n📌 用法: ping HOST\n"},
+		{"quoted", ArgError{Argv: []string{"ping", "a b"}, Index: 1, Msg: "参数 HOST 应为 IP 地址"},
+			"❌ ping: 参数 HOST 应为 IP 地址\n   ping 'a b'\n        ^^^^^\n📌 用法: ping HOST\n"},
+		{"missing", ArgError{Argv: []string{"ping"}, Index: -1, Msg: "缺少参数 HOST"},
+			"❌ ping: 缺少参数 HOST\n   ping\n        ^\n📌 用法: ping HOST\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			tt.err.render(&b, "ping HOST")
+			if b.String() != tt.want {
+				t.Errorf("render() =\n%s\nwant\n%s", b.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// runScriptMode 处理 flyos -c "cmd" 与 flyos -f script.fly
+func runScriptMode(shell *Shell, command, script string) int {
+	if command != "" {
+		return shell.RunScript(strings.NewReader(command), "-c")
+	}
+	if script == "-" {
+		return shell.RunScript(os.Stdin, "stdin")
+	}
+	f, err := os.Open(script)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 打开脚本失败: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	return shell.RunScript(f, script)
+}
+
+// RunScript 逐行执行脚本，返回最后一条命令的退出码
+// 空行与 # 注释被忽略，行尾的 \ 表示续行，DSL 语句读到花括号配平为止；语法错误会中止脚本并返回 2
+func (s *Shell) RunScript(r io.Reader, name string) int {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var (
+		buf    strings.Builder
+		lineNo int
+		start  int  // 当前逻辑行的起始行号
+		block  bool // 当前逻辑行是 DSL 语句
+	)
+	run := func() bool {
+		line := buf.String()
+		buf.Reset()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			return true
+		}
+		if block {
+			return s.RunDSL(line, name, start) != 2 && !s.Exited()
+		}
+		if _, err := parseLine(line, s.isCommand); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ %s:%d: %v\n", name, start, err)
+			s.setStatus(2)
+			return false
+		}
+		s.RunLine(line)
+		return !s.Exited()
+	}
+
+	for sc.Scan() {
+		lineNo++
+		line := strings.TrimRight(sc.Text(), "\r")
+		if buf.Len() == 0 {
+			start = lineNo
+			block = isDSLLine(line)
+		}
+		if block {
+			buf.WriteString(line + "\n")
+			if dslDepth(buf.String()) > 0 {
+				continue
+			}
+			if !run() {
+				return s.LastStatus()
+			}
+			continue
+		}
+		if isContinued(line) {
+			buf.WriteString(line[:len(line)-1])
+			continue
+		}
+		buf.WriteString(line)
+		if !run() {
+			return s.LastStatus()
+		}
+	}
+	if err := sc.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "❌ 读取脚本 %s 失败: %v\n", name, err)
+		return 1
+	}
+	if buf.Len() > 0 {
+		run()
+	}
+	return s.LastStatus()
+}
+
+// isContinued 行尾有奇数个反斜杠时表示续行
+func isContinued(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
@@ -1,26 +1,60 @@
 package main
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"runtime"
 	"sort"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
+
+	"flyos/pkg/runtime/eventbus"
 )
 
 // Shell
 type Shell struct {
 	mu       sync.RWMutex
 	commands map[string]Command
-	env      map[string]string
+	env      map[string]string // 会话变量
+	exported map[string]bool   // 导出给外部命令的变量名
+	status   int               // 上一条命令的退出码，即 $?
+	exited   bool              // 已执行 exit
+	errexit  bool              // set -e：命令失败即结束
+
+	jobs        *JobTable   // 作业表
+	interactive bool        // 交互终端：前台作业接管终端
+	audit       *AuditLog   // 命令审计日志
+	auth        *Authorizer // 基于角色的命令授权
+	history     *History    // REPL 命令历史
+	configDB    *ConfigDB   // configure 模式的候选配置与提交历史
+	confirm     *ConfirmTimer
+	events      *eventbus.EventBus // runtime EventBus（eventbus.Default），发布 commit confirmed 事件
 }
 
+// NewShell 传入的 env（config.toml [env]）全部作为导出变量
 func NewShell(env map[string]string) *Shell {
+	exported := make(map[string]bool, len(env))
+	for name := range env {
+		exported[name] = true
+	}
 	return &Shell{
 		commands: make(map[string]Command),
 		env:      env,
+		exported: exported,
+		jobs:     NewJobTable(),
+		audit:    NewAuditLog(),
+		auth:     NewAuthorizer(),
+		history:  NewHistory(),
+		configDB: NewConfigDB(configDBDir),
+		confirm:  NewConfirmTimer(filepath.Join(configDBDir, "confirm.json")),
 	}
 }
 
@@ -30,7 +64,50 @@ func (s *Shell) Register(cmd Command) {
 	s.commands[cmd.Name()] = cmd
 }
 
-func (s *Shell) List() {
+// Lookup 按名称查找命令
+func (s *Shell) Lookup(name string) (Command, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cmd, ok := s.commands[name]
+	return cmd, ok
+}
+
+// Names 返回排序后的、当前角色可见的命令名
+func (s *Shell) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	names := make([]string, 0, len(s.commands))
+	for name, cmd := range s.commands {
+		if s.Allowed(cmd) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isCommand 是否有该名称的已注册命令
+func (s *Shell) isCommand(name string) bool {
+	_, ok := s.Lookup(name)
+	return ok
+}
+
+// Commands 返回已注册命令的快照，调用方遍历时不持有 s.mu，命令目录可能同时被重新扫描
+func (s *Shell) Commands() []Command {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	cmds := make([]Command, 0, len(s.commands))
+	for _, cmd := range s.commands {
+		cmds = append(cmds, cmd)
+	}
+	return cmds
+}
+
+func (s *Shell) List(w io.Writer) {
+	if f := s.OutputFormat(); f != OutputText {
+		s.writeList(w, f)
+		return
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
@@ -39,6 +116,9 @@ func (s *Shell) List() {
 	externalCategories := make(map[string][]Command)
 
 	for _, cmd := range s.commands {
+		if !s.Allowed(cmd) {
+			continue
+		}
 		if cmd.IsBuiltin() {
 			builtinCategories[cmd.Category()] = append(builtinCategories[cmd.Category()], cmd)
 		} else {
@@ -47,9 +127,9 @@ func (s *Shell) List() {
 	}
 
 	// 内置命令输出
-	fmt.Println("🛠️ 内置命令:")
+	fmt.Fprintln(w, "🛠️ 内置命令:")
 	if len(builtinCategories) == 0 {
-		fmt.Println("  <无>")
+		fmt.Fprintln(w, "  <无>")
 	} else {
 		bcats := make([]string, 0, len(builtinCategories))
 		for c := range builtinCategories {
@@ -57,8 +137,8 @@ func (s *Shell) List() {
 		}
 		sort.Strings(bcats)
 		for _, cat := range bcats {
-			fmt.Println("🗂 分类:")
-			fmt.Printf("\n[%s]\n", cat)
+			fmt.Fprintln(w, "🗂 分类:")
+			fmt.Fprintf(w, "\n[%s]\n", cat)
 			cmds := builtinCategories[cat]
 			sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name() < cmds[j].Name() })
 			for _, c := range cmds {
@@ -66,24 +146,24 @@ func (s *Shell) List() {
 				if desc == "" {
 					desc = "<暂无描述>"
 				}
-				fmt.Printf("  %-10s - %s\n", c.Name(), desc)
+				fmt.Fprintf(w, "  %-10s - %s\n", c.Name(), desc)
 			}
 		}
 	}
 
 	// 外部命令输出
-	fmt.Println("\n📦 外部命令:")
+	fmt.Fprintln(w, "\n📦 外部命令:")
 	if len(externalCategories) == 0 {
-		fmt.Println("  <无>")
+		fmt.Fprintln(w, "  <无>")
 	} else {
 		ecats := make([]string, 0, len(externalCategories))
 		for c := range externalCategories {
 			ecats = append(ecats, c)
 		}
 		sort.Strings(ecats)
-		fmt.Println("🗂 分类:")
+		fmt.Fprintln(w, "🗂 分类:")
 		for _, cat := range ecats {
-			fmt.Printf("\n[%s]\n", cat)
+			fmt.Fprintf(w, "\n[%s]\n", cat)
 			cmds := externalCategories[cat]
 			sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name() < cmds[j].Name() })
 			for _, c := range cmds {
@@ -91,27 +171,296 @@ func (s *Shell) List() {
 				if desc == "" {
 					desc = "<暂无描述>"
 				}
-				fmt.Printf("  %-10s → %-20s %s\n", c.Name(), c.Path(), desc)
+				path := c.Path()
+				if path == "" {
+					path = "-"
+				}
+				fmt.Fprintf(w, "  %-10s → %-20s %s\n", c.Name(), path, desc)
 			}
 		}
 	}
 }
 
-// RunCommand
-func (s *Shell) RunCommand(args []string) {
+// LastStatus 返回上一条命令的退出码
+func (s *Shell) LastStatus() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.status
+}
+
+// Exited 是否已执行 exit
+func (s *Shell) Exited() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.exited
+}
+
+func (s *Shell) setStatus(code int) {
+	s.mu.Lock()
+	s.status = code
+	s.mu.Unlock()
+}
+
+// RunLine 解析并执行一行命令，支持管道、重定向、&& || ; 连接与 & 后台作业
+func (s *Shell) RunLine(line string) int {
+	return s.runLine(line, defaultStdio())
+}
+
+// capture 执行命令替换 $(...)，返回去掉结尾换行的标准输出
+func (s *Shell) capture(line string, params []string) string {
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "💥 创建管道失败: %v\n", err)
+		return ""
+	}
+	var buf bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&buf, pr)
+		pr.Close()
+		close(done)
+	}()
+
+	exited := s.Exited()
+	s.runLine(line, &Stdio{In: os.Stdin, Out: pw, Err: os.Stderr, Params: params})
+	pw.Close()
+	<-done
+	// 命令替换中的 exit 不结束会话
+	s.mu.Lock()
+	s.exited = exited
+	s.mu.Unlock()
+	return strings.TrimRight(buf.String(), "\n")
+}
+
+func (s *Shell) runLine(line string, stdio *Stdio) int {
+	items, err := parseLine(line, s.isCommand)
+	if err != nil {
+		fmt.Fprintf(stdio.Err, "⚠️ %v\n", err)
+		s.setStatus(2)
+		return 2
+	}
+	for i, item := range items {
+		if s.Exited() {
+			break
+		}
+		status := s.LastStatus()
+		if (item.op == "&&" && status != 0) || (item.op == "||" && status == 0) {
+			continue
+		}
+		status = s.runJob(item, stdio)
+		s.setStatus(status)
+
+		// set -e：失败的命令不是 && / || 的左侧时结束执行
+		last := i == len(items)-1 || items[i+1].op == ";" || items[i+1].op == "&"
+		if status != 0 && last && s.Errexit() {
+			s.mu.Lock()
+			s.exited = true
+			s.mu.Unlock()
+		}
+	}
+	return s.LastStatus()
+}
+
+// Errexit 是否开启 set -e
+func (s *Shell) Errexit() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.errexit
+}
+
+// SetErrexit 开启或关闭 set -e
+func (s *Shell) SetErrexit(on bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errexit = on
+}
+
+// runPipeline 执行管道，有过滤器时先收集输出再过滤，返回最后一条命令的退出码
+func (s *Shell) runPipeline(p *pipeline, stdio *Stdio) int {
+	if len(p.filters) > 0 {
+		return s.runFiltered(p, stdio, func(st *Stdio) int { return s.runStages(p.cmds, st) })
+	}
+	return s.runStages(p.cmds, stdio)
+}
+
+// runStages 并发执行管道中的各条命令，返回最后一条命令的退出码
+func (s *Shell) runStages(cmds []*simpleCmd, stdio *Stdio) int {
+	n := len(cmds)
+	if n == 1 {
+		return s.runSimple(cmds[0], stdio)
+	}
+
+	statuses := make([]int, n)
+	var wg sync.WaitGroup
+	in := stdio.In
+	for i, c := range cmds {
+		st := *stdio
+		st.In = in
+		var pr, pw *os.File
+		if i < n-1 {
+			var err error
+			pr, pw, err = os.Pipe()
+			if err != nil {
+				fmt.Fprintf(stdio.Err, "💥 创建管道失败: %v\n", err)
+				return 1
+			}
+			st.Out = pw
+			in = pr
+		}
+		wg.Add(1)
+		go func(i int, c *simpleCmd, st *Stdio, pw *os.File) {
+			defer wg.Done()
+			statuses[i] = s.runSimple(c, st)
+			// 关闭本段的写端与读端，让下游读到 EOF、上游写入得到 EPIPE
+			if pw != nil {
+				pw.Close()
+			}
+			if r, ok := st.In.(*os.File); ok && i > 0 {
+				r.Close()
+			}
+		}(i, c, &st, pw)
+	}
+	wg.Wait()
+	return statuses[n-1]
+}
+
+// runSimple 展开单词、应用重定向后执行一条简单命令
+func (s *Shell) runSimple(c *simpleCmd, stdio *Stdio) int {
+	args := make([]string, 0, len(c.words))
+	for _, w := range c.words {
+		// "$@" 展开为多个参数
+		if (w == "$@" || w == `"$@"`) && stdio.Params != nil {
+			args = append(args, stdio.Params[1:]...)
+			continue
+		}
+		args = append(args, s.expandWord(w, stdio.Params))
+	}
+
+	st := *stdio
+	var opened []*os.File
+	defer func() {
+		for _, f := range opened {
+			f.Close()
+		}
+	}()
+	for _, r := range c.redirs {
+		switch r.op {
+		case ">&":
+			if r.fd == 1 {
+				st.Out = st.Err
+			} else {
+				st.Err = st.Out
+			}
+			continue
+		}
+		name := s.expandWord(r.target, stdio.Params)
+		var (
+			f   *os.File
+			err error
+		)
+		switch r.op {
+		case "<":
+			f, err = os.Open(name)
+		case ">":
+			f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+		case ">>":
+			f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		}
+		if err != nil {
+			fmt.Fprintf(stdio.Err, "⚠️ 重定向失败: %v\n", err)
+			return 1
+		}
+		opened = append(opened, f)
+		switch r.fd {
+		case 0:
+			st.In = f
+		case 1:
+			st.Out = f
+		case 2:
+			st.Err = f
+		}
+	}
+	if c.run != nil {
+		return c.run(&st)
+	}
+	return s.RunCommand(args, &st)
+}
+
+// RunCommand 执行一条已展开的命令，返回退出码；每次执行（包括被拒绝的）都写入审计日志
+func (s *Shell) RunCommand(args []string, stdio *Stdio) int {
 	if len(args) == 0 {
-		return
+		return 0
 	}
+	start := time.Now()
 	s.mu.RLock()
 	cmd, ok := s.commands[args[0]]
 	s.mu.RUnlock()
 	if !ok {
-		fmt.Printf("⚠️ 未找到命令: %s\n", args[0])
-		return
+		fmt.Fprintf(stdio.Err, "⚠️ 未找到命令: %s\n", args[0])
+		printSuggestions(stdio.Err, suggest(args[0], s.Names()))
+		s.audit.Record(args, "", 127, start)
+		return 127
+	}
+	if !s.Allowed(cmd) {
+		fmt.Fprintf(stdio.Err, "⛔ %v\n", &deniedError{name: args[0], roles: s.auth.Roles()})
+		s.audit.Record(args, cmd.Path(), 126, start)
+		return 126
+	}
+	code := s.execute(cmd, args, stdio)
+	s.audit.Record(args, cmd.Path(), code, start)
+	return code
+}
+
+// execute 调用命令并把返回的错误转换为退出码
+func (s *Shell) execute(cmd Command, args []string, stdio *Stdio) int {
+	err := cmd.Execute(args, s.exportedEnv(), stdio)
+	var exitReq *exitRequest
+	if errors.As(err, &exitReq) {
+		if s.leaveConfigure(stdio) {
+			return 0
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.exited = true
+		if exitReq.code >= 0 {
+			s.status = exitReq.code
+		}
+		return s.status
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		var codeErr *exitCodeError
+		if !errors.As(err, &exitErr) && !errors.As(err, &codeErr) {
+			fmt.Fprintf(stdio.Err, "💥 执行失败 [%s]: %v\n", args[0], err)
+		}
 	}
-	if err := cmd.Execute(args, s.env); err != nil {
-		fmt.Printf("💥 执行失败 [%s]: %v\n", args[0], err)
+	return exitStatus(err)
+}
+
+// exitStatus 将 Execute 返回的错误转换为退出码
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var codeErr *exitCodeError
+	if errors.As(err, &codeErr) {
+		return codeErr.code
 	}
+	var policyErr *PolicyError
+	if errors.As(err, &policyErr) {
+		return policyErr.Code
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		if code := exitErr.ExitCode(); code >= 0 {
+			return code
+		}
+		// 被信号终止，按惯例返回 128+信号值
+		if ws, ok := exitErr.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+	}
+	return 1
 }
 
 // FuzzyFind 支持命令模糊匹配
@@ -130,44 +479,122 @@ func (s *Shell) FuzzyFind(keyword string) []Command {
 	return results
 }
 
-// LoadCommands
-func (s *Shell) LoadCommands(cfg *Config, descMgr *DescManager) {
+// commandChanges 一次对账中外部命令的变化
+type commandChanges struct {
+	added, updated, removed []string
+}
+
+func (c commandChanges) empty() bool {
+	return len(c.added)+len(c.updated)+len(c.removed) == 0
+}
+
+// String 形如 "新增 a, b；更新 c；移除 d"
+func (c commandChanges) String() string {
+	var parts []string
+	for _, p := range []struct {
+		label string
+		names []string
+	}{{"新增", c.added}, {"更新", c.updated}, {"移除", c.removed}} {
+		if len(p.names) > 0 {
+			sort.Strings(p.names)
+			parts = append(parts, p.label+" "+strings.Join(p.names, ", "))
+		}
+	}
+	return strings.Join(parts, "；")
+}
+
+// scanCommands 扫描 commands_dirs（递归）中的可执行文件，同名时先出现的目录优先
+func scanCommands(cfg *Config, descMgr *DescManager) map[string]*FileCommand {
 	excluded := make(map[string]bool)
 	for _, e := range cfg.Excludes {
 		excluded[e] = true
 	}
-	newMap := make(map[string]Command)
+	found := make(map[string]*FileCommand)
 	for _, dir := range cfg.CommandsDirs {
 		filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
 			if err != nil {
-				return err
+				// 跳过无法读取的目录或文件，继续扫描其余部分；不存在的目录不提示
+				if !errors.Is(err, fs.ErrNotExist) {
+					fmt.Fprintf(os.Stderr, "⚠️ 扫描命令目录时跳过 %s: %v\n", path, err)
+				}
+				return nil
 			}
 			if d.IsDir() || excluded[d.Name()] {
 				return nil
 			}
-			if isExecutable(path) {
-				name := d.Name()
-				category := "default"
-				// 如果 descMgr 有记录，则取分类
-				if desc, ok := descMgr.Get(name); ok {
-					category = desc.Category
-				}
-				newMap[name] = &FileCommand{
-					name:     name,
-					path:     path,
-					category: category,
-				}
+			name := d.Name()
+			if _, dup := found[name]; dup || !isExecutable(path) {
+				return nil
+			}
+			var modTime time.Time
+			if info, err := os.Stat(path); err == nil {
+				modTime = info.ModTime()
+			}
+			found[name] = &FileCommand{
+				name:     name,
+				path:     path,
+				category: "default",
+				modTime:  modTime,
+				descMgr:  descMgr,
 			}
 			return nil
 		})
 	}
 
+	if cfg.SelfDescribe {
+		descMgr.selfDescribe(found)
+	} else {
+		descMgr.clearSelfDescribed()
+	}
+	// 如果 descMgr 有记录，则取分类
+	for name, cmd := range found {
+		if desc, ok := descMgr.Get(name); ok {
+			cmd.category = desc.Category
+		}
+	}
+	return found
+}
+
+// reconcileCommands 使已注册的外部命令与磁盘一致：新增、替换路径或内容有变化的，
+// 移除已删除、被排除或失去执行权限的；内置命令、别名与函数不受影响
+func (s *Shell) reconcileCommands(cfg *Config, descMgr *DescManager) (commandChanges, int) {
+	found := scanCommands(cfg, descMgr)
+
 	s.mu.Lock()
-	for k, v := range newMap {
-		s.commands[k] = v
+	defer s.mu.Unlock()
+	var changes commandChanges
+	for name, cmd := range s.commands {
+		if _, ok := cmd.(*FileCommand); !ok {
+			continue
+		}
+		if _, exists := found[name]; !exists {
+			delete(s.commands, name)
+			changes.removed = append(changes.removed, name)
+		}
 	}
-	s.mu.Unlock()
-	fmt.Printf("🔄 已加载 %d 个📦外部命令\n", len(newMap))
+	for name, cmd := range found {
+		switch old, ok := s.commands[name].(*FileCommand); {
+		case !ok:
+			changes.added = append(changes.added, name)
+		case old.path != cmd.path || old.category != cmd.category || !old.modTime.Equal(cmd.modTime):
+			changes.updated = append(changes.updated, name)
+		default:
+			continue
+		}
+		s.commands[name] = cmd
+	}
+	return changes, len(found)
+}
+
+// LoadCommands 加载 commands_dirs 中的外部命令与配置中的别名、函数，可重复调用
+func (s *Shell) LoadCommands(cfg *Config, descMgr *DescManager) {
+	changes, total := s.reconcileCommands(cfg, descMgr)
+	logInfo("🔄 已加载 %d 个📦外部命令\n", total)
+	// 首次加载全部为新增，只打印总数
+	if len(changes.added) != total && !changes.empty() {
+		logInfo("📦 外部命令变化: %s\n", changes)
+	}
+	s.loadAliases(cfg, descMgr)
 }
 
 // 文件扫描
@@ -177,17 +604,8 @@ func isExecutable(path string) bool {
 		return false
 	}
 	if runtime.GOOS != "windows" {
-		if info.Mode().Perm()&0111 != 0 {
-			return true
-		}
-		f, err := os.Open(path)
-		if err != nil {
-			return false
-		}
-		defer f.Close()
-		buf := make([]byte, 2)
-		n, _ := f.Read(buf)
-		return n == 2 && buf[0] == '#' && buf[1] == '!'
+		// 没有执行权限的文件（包括带 #! 的脚本）无法直接执行
+		return info.Mode().Perm()&0111 != 0
 	}
 	ext := strings.ToLower(filepath.Ext(path))
 	switch ext {
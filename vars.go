@@ -0,0 +1,243 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// 变量规则：
+//   - 会话变量保存在 Shell.env，set 定义的变量只在 flyos 内可见；
+//   - config.toml [env] 与 export 的变量会导出，通过 mergeEnv 覆盖进程环境后传给外部命令；
+//   - unset 只删除会话变量，进程原有环境变量如需对子进程隐藏可 export NAME= 置空；
+//   - 启用 RBAC 时不导出 LD_PRELOAD 等动态链接器变量，避免把代码注入被授权的外部命令。
+
+// isValidVarName 变量名规则：字母或下划线开头，后接字母、数字、下划线
+func isValidVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, r := range name {
+		if r == '_' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (i > 0 && r >= '0' && r <= '9') {
+			continue
+		}
+		return false
+	}
+	return true
+}
+
+// SetVar 设置会话变量，export 为 true 时同时导出；已导出的变量重新赋值后仍保持导出
+func (s *Shell) SetVar(name, value string, export bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.env[name] = value
+	if export {
+		s.exported[name] = true
+	}
+}
+
+// UnsetVar 删除会话变量
+func (s *Shell) UnsetVar(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.env, name)
+	delete(s.exported, name)
+}
+
+// LookupVar 先查会话变量，再查进程环境
+func (s *Shell) LookupVar(name string) (string, bool) {
+	s.mu.RLock()
+	v, ok := s.env[name]
+	s.mu.RUnlock()
+	if ok {
+		return v, true
+	}
+	return os.LookupEnv(name)
+}
+
+// exportedEnv 返回需要传给外部命令的变量副本
+func (s *Shell) exportedEnv() map[string]string {
+	restricted := s.auth.Enabled()
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	env := make(map[string]string, len(s.exported))
+	for name := range s.exported {
+		if restricted && isLoaderVar(name) {
+			continue
+		}
+		if v, ok := s.env[name]; ok {
+			env[name] = v
+		}
+	}
+	return env
+}
+
+// isLoaderVar 动态链接器读取的变量，如 LD_PRELOAD、LD_LIBRARY_PATH、LD_AUDIT
+func isLoaderVar(name string) bool {
+	return strings.HasPrefix(name, "LD_") || strings.HasPrefix(name, "DYLD_")
+}
+
+// parseAssign 解析 NAME=VALUE
+func parseAssign(arg string) (name, value string, hasValue bool, err error) {
+	name, value, hasValue = strings.Cut(arg, "=")
+	if !isValidVarName(name) {
+		return "", "", false, fmt.Errorf("非法变量名: %s", name)
+	}
+	return name, value, hasValue, nil
+}
+
+// Builtin Set
+type SetCommand struct {
+	shell *Shell
+}
+
+func NewSetCommand(s *Shell) *SetCommand {
+	return &SetCommand{shell: s}
+}
+
+func (c *SetCommand) Name() string     { return "set" }
+func (c *SetCommand) Category() string { return "sys" }
+func (c *SetCommand) Path() string     { return "" }
+func (c *SetCommand) IsBuiltin() bool  { return true }
+func (c *SetCommand) Desc() string     { return "设置会话变量（不传给外部命令）" }
+func (c *SetCommand) Usage() string    { return "set [-e|+e] [NAME=VALUE...]" }
+func (c *SetCommand) Args() []string {
+	return []string{
+		"NAME=VALUE 可选，不带参数时打印全部会话变量",
+		"FLYOS_OUTPUT=text|table|json 设置 list、help、env 的输出格式",
+	}
+}
+func (c *SetCommand) Returns() []string { return []string{"设置或打印会话变量"} }
+func (c *SetCommand) Flags() []string {
+	return []string{"-e    # 命令失败时立即结束（脚本模式常用）", "+e    # 关闭 -e"}
+}
+func (c *SetCommand) Subcommands() []string { return nil }
+func (c *SetCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) <= 1 {
+		// 先复制再输出，输出到管道时不持有锁，避免管道另一端的命令设置变量时死锁
+		c.shell.mu.RLock()
+		vars := make(map[string]string, len(c.shell.env))
+		names := make([]string, 0, len(c.shell.env))
+		for name, value := range c.shell.env {
+			vars[name] = value
+			names = append(names, name)
+		}
+		c.shell.mu.RUnlock()
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stdio.Out, "%s=%s\n", name, vars[name])
+		}
+		return nil
+	}
+	for _, arg := range args[1:] {
+		switch arg {
+		case "-e":
+			c.shell.SetErrexit(true)
+			continue
+		case "+e":
+			c.shell.SetErrexit(false)
+			continue
+		}
+		name, value, hasValue, err := parseAssign(arg)
+		if err != nil {
+			return err
+		}
+		if !hasValue {
+			return fmt.Errorf("用法: %s", c.Usage())
+		}
+		if name == outputVar && value != "" {
+			if _, err := ParseOutputFormat(value); err != nil {
+				return err
+			}
+		}
+		c.shell.SetVar(name, value, false)
+	}
+	return nil
+}
+
+// Builtin Unset
+type UnsetCommand struct {
+	shell *Shell
+}
+
+func NewUnsetCommand(s *Shell) *UnsetCommand {
+	return &UnsetCommand{shell: s}
+}
+
+func (c *UnsetCommand) Name() string          { return "unset" }
+func (c *UnsetCommand) Category() string      { return "sys" }
+func (c *UnsetCommand) Path() string          { return "" }
+func (c *UnsetCommand) IsBuiltin() bool       { return true }
+func (c *UnsetCommand) Desc() string          { return "删除会话变量" }
+func (c *UnsetCommand) Usage() string         { return "unset NAME..." }
+func (c *UnsetCommand) Args() []string        { return []string{"NAME 需要删除的变量名"} }
+func (c *UnsetCommand) Returns() []string     { return []string{"删除会话变量及其导出标记"} }
+func (c *UnsetCommand) Flags() []string       { return nil }
+func (c *UnsetCommand) Subcommands() []string { return nil }
+func (c *UnsetCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) <= 1 {
+		return fmt.Errorf("用法: %s", c.Usage())
+	}
+	for _, name := range args[1:] {
+		if !isValidVarName(name) {
+			return fmt.Errorf("非法变量名: %s", name)
+		}
+		c.shell.UnsetVar(name)
+	}
+	return nil
+}
+
+// Builtin Export
+type ExportCommand struct {
+	shell *Shell
+}
+
+func NewExportCommand(s *Shell) *ExportCommand {
+	return &ExportCommand{shell: s}
+}
+
+func (c *ExportCommand) Name() string     { return "export" }
+func (c *ExportCommand) Category() string { return "sys" }
+func (c *ExportCommand) Path() string     { return "" }
+func (c *ExportCommand) IsBuiltin() bool  { return true }
+func (c *ExportCommand) Desc() string     { return "导出变量给外部命令" }
+func (c *ExportCommand) Usage() string    { return "export [NAME[=VALUE]...]" }
+func (c *ExportCommand) Args() []string {
+	return []string{
+		"NAME[=VALUE] 可选，不带参数时打印全部导出变量",
+		"启用 RBAC 时不能导出 LD_PRELOAD 等 LD_*、DYLD_* 变量",
+	}
+}
+func (c *ExportCommand) Returns() []string     { return []string{"导出或打印变量"} }
+func (c *ExportCommand) Flags() []string       { return nil }
+func (c *ExportCommand) Subcommands() []string { return nil }
+func (c *ExportCommand) Execute(args []string, env map[string]string, stdio *Stdio) error {
+	if len(args) <= 1 {
+		exported := c.shell.exportedEnv()
+		names := make([]string, 0, len(exported))
+		for name := range exported {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(stdio.Out, "export %s=%s\n", name, exported[name])
+		}
+		return nil
+	}
+	for _, arg := range args[1:] {
+		name, value, hasValue, err := parseAssign(arg)
+		if err != nil {
+			return err
+		}
+		if isLoaderVar(name) && c.shell.auth.Enabled() {
+			return fmt.Errorf("启用 RBAC 时不能导出动态链接器变量 %s", name)
+		}
+		if !hasValue {
+			// 仅导出已有变量，不存在时取进程环境或空值
+			value, _ = c.shell.LookupVar(name)
+		}
+		c.shell.SetVar(name, value, true)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// CommandWatcher 递归监听 commands_dirs，文件新增、删除、修改或权限变化时重新对账外部命令
+type CommandWatcher struct {
+	shell   *Shell
+	descMgr *DescManager
+	watcher *fsnotify.Watcher
+
+	mu       sync.Mutex
+	cfg      *Config
+	dirs     map[string]bool // 已监听的目录
+	debounce *time.Timer
+}
+
+func NewCommandWatcher(s *Shell, d *DescManager, cfg *Config) (*CommandWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &CommandWatcher{shell: s, descMgr: d, watcher: watcher, cfg: cfg, dirs: make(map[string]bool)}
+	w.mu.Lock()
+	w.syncDirs()
+	w.mu.Unlock()
+	return w, nil
+}
+
+// SetConfig 配置重新加载后更新监听目录并重新加载命令
+func (w *CommandWatcher) SetConfig(cfg *Config) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.cfg = cfg
+	w.syncDirs()
+	w.shell.LoadCommands(cfg, w.descMgr)
+}
+
+// Reconcile 只对账外部命令，有变化时打印摘要
+func (w *CommandWatcher) Reconcile() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.syncDirs()
+	changes, total := w.shell.reconcileCommands(w.cfg, w.descMgr)
+	if changes.empty() {
+		return
+	}
+	fmt.Printf("\n🔄 外部命令已更新（共 %d 个）: %s\n", total, changes)
+	// 新增或移除的命令与别名、函数重名时，重新注册别名与函数
+	for _, name := range append(changes.added, changes.removed...) {
+		_, isAlias := w.cfg.Aliases[name]
+		_, isFunc := w.cfg.Functions[name]
+		if isAlias || isFunc {
+			w.shell.loadAliases(w.cfg, w.descMgr)
+			return
+		}
+	}
+}
+
+// syncDirs 监听 commands_dirs 下的全部目录，移除已不存在或不再配置的目录；调用方持有 mu
+func (w *CommandWatcher) syncDirs() {
+	want := make(map[string]bool)
+	for _, root := range w.cfg.CommandsDirs {
+		filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				want[path] = true
+			}
+			return nil
+		})
+	}
+	for dir := range w.dirs {
+		if !want[dir] {
+			w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+		}
+	}
+	for dir := range want {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️ 无法监听命令目录 %s: %v\n", dir, err)
+			continue
+		}
+		w.dirs[dir] = true
+	}
+}
+
+// Run 事件合并 300ms 后对账一次，直到 ctx 结束
+func (w *CommandWatcher) Run(ctx context.Context) {
+	defer w.watcher.Close()
+	for {
+		select {
+		case _, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			w.mu.Lock()
+			if w.debounce != nil {
+				w.debounce.Stop()
+			}
+			w.debounce = time.AfterFunc(300*time.Millisecond, w.Reconcile)
+			w.mu.Unlock()
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			fmt.Fprintf(os.Stderr, "⚠️ 命令目录监听出错: %v\n", err)
+		case <-ctx.Done():
+			return
+		}
+	}
+}